@@ -0,0 +1,191 @@
+package evtx
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	errors "github.com/pkg/errors"
+)
+
+const (
+	// Separates the archive from the glob matching members inside
+	// it, e.g. collection.zip!**/winevt/Logs/*.evtx
+	ARCHIVE_SEPARATOR = "!"
+)
+
+var (
+	zip_magic = []byte("PK\x03\x04")
+	tar_magic = []byte("ustar")
+)
+
+// ArchiveMember is a file inside a ZIP or TAR archive.
+type ArchiveMember struct {
+	Archive string
+	Member  string
+	Size    int64
+}
+
+func (self *ArchiveMember) Path() string {
+	return self.Archive + ARCHIVE_SEPARATOR + self.Member
+}
+
+// SplitArchivePath splits a path like "collection.zip!**/*.evtx" into
+// the archive path and the glob. The archive must exist as a regular
+// file so that file names which happen to contain the separator are
+// not split.
+func SplitArchivePath(filename string) (archive, glob string, ok bool) {
+	for i := 0; i < len(filename); i++ {
+		if !strings.HasPrefix(filename[i:], ARCHIVE_SEPARATOR) {
+			continue
+		}
+
+		stat, err := os.Stat(filename[:i])
+		if err == nil && stat.Mode().IsRegular() {
+			return filename[:i], filename[i+len(ARCHIVE_SEPARATOR):], true
+		}
+	}
+	return "", "", false
+}
+
+// WalkArchive calls the callback for each member of the archive
+// matching the glob. The member is not extracted to disk. If the
+// archive allows random access to the member (stored ZIP members and
+// uncompressed TAR files) the reader is also an io.ReadSeeker and
+// can be parsed with GetChunks(). Otherwise it must be read in
+// sequence with NewChunkStream().
+//
+// The reader is only valid until the callback returns.
+func WalkArchive(archive, glob string,
+	cb func(member *ArchiveMember, reader io.Reader) error) error {
+	fd, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(fd, header)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, zip_magic):
+		return walkZip(fd, stat.Size(), archive, glob, cb)
+
+	case len(header) > 262 && bytes.HasPrefix(header[257:], tar_magic):
+		_, err = fd.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		return walkTar(fd, fd, archive, glob, cb)
+	}
+
+	// Maybe a compressed tar file - these can only be read in
+	// sequence.
+	_, err = fd.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	reader, err := NewDecompressingReader(fd)
+	if err != nil {
+		return err
+	}
+	return walkTar(reader, nil, archive, glob, cb)
+}
+
+func walkZip(fd *os.File, size int64, archive, glob string,
+	cb func(member *ArchiveMember, reader io.Reader) error) error {
+	zip_reader, err := zip.NewReader(fd, size)
+	if err != nil {
+		return errors.Wrap(err, "Opening zip")
+	}
+
+	for _, file := range zip_reader.File {
+		if file.FileInfo().IsDir() || !MatchGlob(glob, file.Name) {
+			continue
+		}
+
+		member := &ArchiveMember{
+			Archive: archive,
+			Member:  file.Name,
+			Size:    int64(file.UncompressedSize64),
+		}
+
+		// Stored members can be read in place.
+		if file.Method == zip.Store {
+			offset, err := file.DataOffset()
+			if err != nil {
+				return err
+			}
+
+			err = cb(member, io.NewSectionReader(fd, offset, member.Size))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return err
+		}
+
+		err = cb(member, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// If fd is specified, it is the underlying file of an uncompressed
+// tar. After tar_reader.Next() the file is positioned at the start
+// of the member's data so we can read the member in place.
+func walkTar(reader io.Reader, fd *os.File, archive, glob string,
+	cb func(member *ArchiveMember, reader io.Reader) error) error {
+	tar_reader := tar.NewReader(reader)
+	for {
+		header, err := tar_reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "Reading tar")
+		}
+
+		if header.Typeflag != tar.TypeReg || !MatchGlob(glob, header.Name) {
+			continue
+		}
+
+		member := &ArchiveMember{
+			Archive: archive,
+			Member:  header.Name,
+			Size:    header.Size,
+		}
+
+		var member_reader io.Reader = tar_reader
+		if fd != nil {
+			offset, err := fd.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			member_reader = io.NewSectionReader(fd, offset, header.Size)
+		}
+
+		err = cb(member, member_reader)
+		if err != nil {
+			return err
+		}
+	}
+}
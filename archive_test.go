@@ -0,0 +1,123 @@
+package evtx
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitArchivePath(t *testing.T) {
+	for _, tc := range []struct {
+		path, archive, glob string
+		ok                  bool
+	}{
+		{"testdata/logs.zip!**/*.evtx", "testdata/logs.zip", "**/*.evtx", true},
+		{"testdata/logs.tar.gz!C/Windows/*", "testdata/logs.tar.gz", "C/Windows/*", true},
+
+		// The glob may contain the separator too.
+		{"testdata/logs.zip!**/a!b.evtx", "testdata/logs.zip", "**/a!b.evtx", true},
+
+		// Only existing files are archives.
+		{"testdata/missing.zip!**/*.evtx", "", "", false},
+		{"testdata!**/*.evtx", "", "", false},
+		{"testdata/logs.zip", "", "", false},
+	} {
+		archive, glob, ok := SplitArchivePath(tc.path)
+		assert.Equal(t, tc.ok, ok, tc.path)
+		assert.Equal(t, tc.archive, archive, tc.path)
+		assert.Equal(t, tc.glob, glob, tc.path)
+	}
+}
+
+// Count the events in an archive member, reading it in place if
+// possible.
+func countMemberEvents(t *testing.T, reader io.Reader) (int, bool) {
+	var chunks ChunkIterator
+
+	seeker, seekable := reader.(io.ReadSeeker)
+	if seekable {
+		found, err := GetChunks(seeker)
+		require.NoError(t, err)
+		chunks = NewChunkIterator(found)
+	} else {
+		stream, err := NewChunkStream(reader)
+		require.NoError(t, err)
+		chunks = stream
+	}
+
+	count := 0
+	for {
+		chunk, err := chunks.Next()
+		if err == io.EOF {
+			return count, seekable
+		}
+		require.NoError(t, err)
+
+		records, err := chunk.Parse(0)
+		require.NoError(t, err)
+		count += len(records)
+	}
+}
+
+func TestWalkArchive(t *testing.T) {
+	type walked struct {
+		path, member string
+		events       int
+		seekable     bool
+	}
+
+	walk := func(archive, glob string) []walked {
+		result := []walked{}
+		err := WalkArchive(archive, glob,
+			func(member *ArchiveMember, reader io.Reader) error {
+				events, seekable := countMemberEvents(t, reader)
+				result = append(result, walked{
+					member.Path(), member.Member, events, seekable})
+				return nil
+			})
+		require.NoError(t, err)
+		return result
+	}
+
+	// Stored ZIP members are read in place, deflated ones in
+	// sequence. Directories and members which do not match the glob
+	// are skipped.
+	assert.Equal(t, []walked{{
+		"testdata/logs.zip!C/Windows/System32/winevt/Logs/Security.evtx",
+		"C/Windows/System32/winevt/Logs/Security.evtx", 1, true,
+	}, {
+		"testdata/logs.zip!C/Windows/System32/winevt/Logs/CAPI2.evtx",
+		"C/Windows/System32/winevt/Logs/CAPI2.evtx", 1, false,
+	}}, walk("testdata/logs.zip", "**/winevt/Logs/*.evtx"))
+
+	// Members of a compressed tar can only be read in sequence.
+	assert.Equal(t, []walked{{
+		"testdata/logs.tar.gz!C/Windows/System32/winevt/Logs/Security.evtx",
+		"C/Windows/System32/winevt/Logs/Security.evtx", 1, false,
+	}, {
+		"testdata/logs.tar.gz!C/Windows/System32/winevt/Logs/CAPI2.evtx",
+		"C/Windows/System32/winevt/Logs/CAPI2.evtx", 1, false,
+	}}, walk("testdata/logs.tar.gz", "**/*.evtx"))
+
+	assert.Equal(t, []walked{}, walk("testdata/logs.zip", "*.evtx"))
+	assert.Equal(t, []walked{{
+		"testdata/logs.tar.gz!C/Windows/System32/winevt/Logs/CAPI2.evtx",
+		"C/Windows/System32/winevt/Logs/CAPI2.evtx", 1, false,
+	}}, walk("testdata/logs.tar.gz", "**/capi2.evtx"))
+
+	// Errors from the callback stop the walk.
+	calls := 0
+	err := WalkArchive("testdata/logs.zip", "**",
+		func(member *ArchiveMember, reader io.Reader) error {
+			calls++
+			return io.ErrUnexpectedEOF
+		})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, 1, calls)
+
+	// Files which are not archives are errors.
+	err = WalkArchive("testdata/messages.bundle", "**", nil)
+	assert.Error(t, err)
+}
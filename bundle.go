@@ -0,0 +1,987 @@
+package evtx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	errors "github.com/pkg/errors"
+)
+
+// A message bundle holds the same data as a message database in a
+// gzip compressed JSON document which is read without cgo. The
+// format is described in docs/message_bundle.md.
+const (
+	MESSAGE_BUNDLE_FORMAT  = "evtx-message-bundle"
+	MESSAGE_BUNDLE_VERSION = 1
+)
+
+// The formats of message sources.
+const (
+	MESSAGE_SOURCE_SQLITE      = "sqlite"
+	MESSAGE_SOURCE_BUNDLE      = "bundle"
+	MESSAGE_SOURCE_BUNDLE_JSON = "bundle_json"
+	MESSAGE_SOURCE_MAP         = "map"
+	MESSAGE_SOURCE_MTA         = "mta"
+)
+
+type MessageBundle struct {
+	Format    string            `json:"format"`
+	Version   int               `json:"version"`
+	Providers []*BundleProvider `json:"providers"`
+}
+
+// BundleProvider is a provider registered for a channel, like a row
+// of the providers table.
+type BundleProvider struct {
+	Name       string             `json:"name"`
+	Guid       string             `json:"guid,omitempty"`
+	Channel    string             `json:"channel,omitempty"`
+	Messages   []*BundleMessage   `json:"messages,omitempty"`
+	Parameters []*BundleParameter `json:"parameters,omitempty"`
+	Events     []*BundleEvent     `json:"events,omitempty"`
+	Values     []*BundleValue     `json:"values,omitempty"`
+}
+
+type BundleMessage struct {
+	// The full message id and the event id it belongs to.
+	Id      int64 `json:"id"`
+	EventId int   `json:"event_id"`
+
+	// The event version, -1 for all versions.
+	EventVersion int `json:"event_version"`
+
+	Language string `json:"language,omitempty"`
+	Build    int    `json:"build,omitempty"`
+	Message  string `json:"message"`
+}
+
+type BundleParameter struct {
+	Id       int64  `json:"id"`
+	Language string `json:"language,omitempty"`
+	Build    int    `json:"build,omitempty"`
+	Message  string `json:"message"`
+}
+
+// BundleEvent is an event definition from the provider's manifest
+// with its data fields in order.
+type BundleEvent struct {
+	EventId  int    `json:"event_id"`
+	Version  int    `json:"version"`
+	Channel  string `json:"channel,omitempty"`
+	Level    int    `json:"level,omitempty"`
+	Task     int    `json:"task,omitempty"`
+	Opcode   int    `json:"opcode,omitempty"`
+	Keywords uint64 `json:"keywords,omitempty"`
+
+	// -1 if the event has no message.
+	MessageId int64 `json:"message_id"`
+
+	Build  int            `json:"build,omitempty"`
+	Fields []*BundleField `json:"fields,omitempty"`
+}
+
+type BundleField struct {
+	Name    string `json:"name"`
+	InType  int    `json:"in_type,omitempty"`
+	OutType int    `json:"out_type,omitempty"`
+}
+
+// BundleValue is a named channel, level, task, opcode or keyword of
+// the provider.
+type BundleValue struct {
+	Kind  string `json:"kind"`
+	Value uint64 `json:"value"`
+	Task  int    `json:"task,omitempty"`
+	Name  string `json:"name,omitempty"`
+
+	// -1 if the value has no message.
+	MessageId int64 `json:"message_id"`
+
+	Build int `json:"build,omitempty"`
+}
+
+func NewMessageBundle() *MessageBundle {
+	return &MessageBundle{
+		Format:  MESSAGE_BUNDLE_FORMAT,
+		Version: MESSAGE_BUNDLE_VERSION,
+	}
+}
+
+// ReadMessageBundle reads a gzip compressed message bundle.
+func ReadMessageBundle(reader io.Reader) (*MessageBundle, error) {
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Reading message bundle")
+	}
+	defer gz.Close()
+
+	return ReadMessageBundleJSON(gz)
+}
+
+// ReadMessageBundleJSON reads an uncompressed message bundle, as
+// written by the messagedb export command.
+func ReadMessageBundleJSON(reader io.Reader) (*MessageBundle, error) {
+	bundle := &MessageBundle{}
+	err := json.NewDecoder(reader).Decode(bundle)
+	if err != nil {
+		return nil, errors.Wrap(err, "Reading message bundle")
+	}
+
+	if bundle.Format != MESSAGE_BUNDLE_FORMAT {
+		return nil, errors.Errorf("Not a message bundle: format %q", bundle.Format)
+	}
+
+	if bundle.Version > MESSAGE_BUNDLE_VERSION {
+		return nil, errors.Errorf(
+			"Message bundle version %v is newer than supported (%v)",
+			bundle.Version, MESSAGE_BUNDLE_VERSION)
+	}
+
+	return bundle, nil
+}
+
+// WriteMessageBundle writes the bundle gzip compressed.
+func WriteMessageBundle(writer io.Writer, bundle *MessageBundle) error {
+	gz := gzip.NewWriter(writer)
+	err := json.NewEncoder(gz).Encode(bundle)
+	if err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// WriteMessageBundleJSON writes the bundle as indented JSON for
+// editing.
+func WriteMessageBundleJSON(writer io.Writer, bundle *MessageBundle) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(bundle)
+}
+
+// DetectMessageSourceFormat tells if the file is a message database,
+// a message bundle (compressed or not), an MTA file saved by Event
+// Viewer or a YAML/JSON message map. Message maps are only recognized
+// by their extension.
+func DetectMessageSourceFormat(filename string) (string, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	header := make([]byte, 16)
+	n, err := io.ReadFull(fd, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("SQLite format 3\x00")):
+		return MESSAGE_SOURCE_SQLITE, nil
+
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return MESSAGE_SOURCE_BUNDLE, nil
+
+	case bytes.HasPrefix(header, []byte(EVTX_HEADER_MAGIC)):
+		return MESSAGE_SOURCE_MTA, nil
+
+	case bytes.HasPrefix(bytes.TrimSpace(header), []byte("{")):
+		_, err = fd.Seek(0, io.SeekStart)
+		if err != nil {
+			return "", err
+		}
+		if isBundleJSON(fd) {
+			return MESSAGE_SOURCE_BUNDLE_JSON, nil
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml", ".json":
+		return MESSAGE_SOURCE_MAP, nil
+	}
+
+	// SQLite treats an empty file as an empty database.
+	if n == 0 {
+		return MESSAGE_SOURCE_SQLITE, nil
+	}
+
+	return "", errors.Errorf("%v: Unknown message source format", filename)
+}
+
+// Bundles are written with the format as the first member.
+func isBundleJSON(reader io.Reader) bool {
+	decoder := json.NewDecoder(reader)
+	for _, expected := range []interface{}{
+		json.Delim('{'), "format", MESSAGE_BUNDLE_FORMAT} {
+		token, err := decoder.Token()
+		if err != nil || token != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadMessageBundle reads a message source of any format as a
+// bundle.
+func LoadMessageBundle(filename string) (*MessageBundle, error) {
+	format, err := DetectMessageSourceFormat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case MESSAGE_SOURCE_SQLITE:
+		return MessageDBToBundle(filename)
+
+	case MESSAGE_SOURCE_MAP:
+		resolver, err := LoadMapResolver(filename)
+		if err != nil {
+			return nil, err
+		}
+		return MapResolverToBundle(resolver), nil
+
+	case MESSAGE_SOURCE_MTA:
+		return LoadLocaleMetaData(filename)
+	}
+
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var bundle *MessageBundle
+	if format == MESSAGE_SOURCE_BUNDLE {
+		bundle, err = ReadMessageBundle(fd)
+	} else {
+		bundle, err = ReadMessageBundleJSON(fd)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, filename)
+	}
+	return bundle, nil
+}
+
+// MessageBundleToDB adds the contents of the bundle to a message
+// database. On errors nothing is added, and a database created for
+// the bundle is removed again.
+func MessageBundleToDB(bundle *MessageBundle, filename string) error {
+	_, err := os.Stat(filename)
+	created := os.IsNotExist(err)
+
+	writer, err := NewMessageDBWriter(filename)
+	if err != nil {
+		if created {
+			os.Remove(filename)
+		}
+		return err
+	}
+
+	err = writeBundleToDB(writer, bundle)
+	if err != nil {
+		writer.Abort()
+		if created {
+			os.Remove(filename)
+		}
+		return err
+	}
+	return writer.Close()
+}
+
+func writeBundleToDB(writer *MessageDBWriter, bundle *MessageBundle) error {
+	for _, provider := range bundle.Providers {
+		provider_id, err := writer.AddProvider(
+			provider.Name, provider.Guid, provider.Channel)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range provider.Messages {
+			writer.Build = message.Build
+			err = writer.AddLocalizedMessage(provider_id, message.Id,
+				message.EventId, message.EventVersion, message.Language,
+				message.Message)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, parameter := range provider.Parameters {
+			writer.Build = parameter.Build
+			err = writer.AddLocalizedParameter(provider_id, parameter.Id,
+				parameter.Language, parameter.Message)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, event := range provider.Events {
+			definition := &WEVTEvent{
+				Id:        event.EventId,
+				Version:   event.Version,
+				Level:     event.Level,
+				Opcode:    event.Opcode,
+				Task:      event.Task,
+				Keywords:  event.Keywords,
+				MessageId: event.MessageId,
+			}
+			if len(event.Fields) > 0 {
+				definition.Template = &WEVTTemplate{}
+				for _, field := range event.Fields {
+					definition.Template.Fields = append(definition.Template.Fields,
+						&WEVTField{
+							Name:    field.Name,
+							InType:  field.InType,
+							OutType: field.OutType,
+						})
+				}
+			}
+
+			writer.Build = event.Build
+			err = writer.AddEvent(provider_id, event.Channel, definition)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, value := range provider.Values {
+			writer.Build = value.Build
+			err = writer.AddProviderValue(provider_id, value.Kind, &WEVTValue{
+				Value:     value.Value,
+				Name:      value.Name,
+				MessageId: value.MessageId,
+				Task:      uint64(value.Task),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MessageDBToBundle reads all the providers of a message database
+// into a bundle.
+func MessageDBToBundle(filename string) (*MessageBundle, error) {
+	database, err := OpenMessageDBReadOnly(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer database.Close()
+
+	bundle := NewMessageBundle()
+	providers := make(map[int64]*BundleProvider)
+
+	err = scanMessageDB(database, `
+          SELECT id, name, guid, channel FROM providers ORDER BY id`,
+		func(scan func(...interface{}) error) error {
+			var id int64
+			var name sql.NullString
+			provider := &BundleProvider{}
+			err := scan(&id, &name, &provider.Guid, &provider.Channel)
+			if err != nil {
+				return err
+			}
+			provider.Name = name.String
+			providers[id] = provider
+			bundle.Providers = append(bundle.Providers, provider)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanMessageDB(database, `
+          SELECT provider_id, id, event_id, COALESCE(event_version, -1),
+                 language, build, message
+          FROM messages ORDER BY rowid`, func(scan func(...interface{}) error) error {
+		var provider_id int64
+		var message sql.NullString
+		item := &BundleMessage{}
+		err := scan(&provider_id, &item.Id, &item.EventId, &item.EventVersion,
+			&item.Language, &item.Build, &message)
+		if err != nil {
+			return err
+		}
+		item.Message = message.String
+
+		provider, pres := providers[provider_id]
+		if pres {
+			provider.Messages = append(provider.Messages, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanMessageDB(database, `
+          SELECT provider_id, id, language, build, message
+          FROM parameters ORDER BY rowid`, func(scan func(...interface{}) error) error {
+		var provider_id int64
+		var message sql.NullString
+		item := &BundleParameter{}
+		err := scan(&provider_id, &item.Id, &item.Language, &item.Build, &message)
+		if err != nil {
+			return err
+		}
+		item.Message = message.String
+
+		provider, pres := providers[provider_id]
+		if pres {
+			provider.Parameters = append(provider.Parameters, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Fields belong to the event of the same version and build.
+	type eventKey struct {
+		provider_id    int64
+		event_id       int
+		version, build int
+	}
+	events := make(map[eventKey]*BundleEvent)
+
+	err = scanMessageDB(database, `
+          SELECT provider_id, event_id, version, channel, level, task, opcode,
+                 keywords, COALESCE(message_id, -1), build
+          FROM events ORDER BY rowid`, func(scan func(...interface{}) error) error {
+		var provider_id, keywords int64
+		item := &BundleEvent{}
+		err := scan(&provider_id, &item.EventId, &item.Version, &item.Channel,
+			&item.Level, &item.Task, &item.Opcode, &keywords,
+			&item.MessageId, &item.Build)
+		if err != nil {
+			return err
+		}
+		item.Keywords = uint64(keywords)
+
+		provider, pres := providers[provider_id]
+		if pres {
+			provider.Events = append(provider.Events, item)
+			events[eventKey{provider_id, item.EventId, item.Version, item.Build}] = item
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanMessageDB(database, `
+          SELECT provider_id, event_id, version, build, name, in_type, out_type
+          FROM event_fields ORDER BY provider_id, event_id, version, build, position`,
+		func(scan func(...interface{}) error) error {
+			var key eventKey
+			field := &BundleField{}
+			err := scan(&key.provider_id, &key.event_id, &key.version, &key.build,
+				&field.Name, &field.InType, &field.OutType)
+			if err != nil {
+				return err
+			}
+
+			provider, pres := providers[key.provider_id]
+			if !pres {
+				return nil
+			}
+
+			event, pres := events[key]
+			if !pres {
+				event = &BundleEvent{
+					EventId:   key.event_id,
+					Version:   key.version,
+					MessageId: -1,
+					Build:     key.build,
+				}
+				events[key] = event
+				provider.Events = append(provider.Events, event)
+			}
+			event.Fields = append(event.Fields, field)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanMessageDB(database, `
+          SELECT provider_id, kind, value, task, name, COALESCE(message_id, -1), build
+          FROM provider_values ORDER BY rowid`, func(scan func(...interface{}) error) error {
+		var provider_id, value int64
+		item := &BundleValue{}
+		err := scan(&provider_id, &item.Kind, &value, &item.Task, &item.Name,
+			&item.MessageId, &item.Build)
+		if err != nil {
+			return err
+		}
+		item.Value = uint64(value)
+
+		provider, pres := providers[provider_id]
+		if pres {
+			provider.Values = append(provider.Values, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bundle, nil
+}
+
+// MapResolverToBundle converts the providers of a message map into a
+// bundle. Map messages apply to all event versions and languages.
+func MapResolverToBundle(resolver *MapResolver) *MessageBundle {
+	bundle := NewMessageBundle()
+	for _, item := range resolver.providers {
+		provider := &BundleProvider{
+			Name:    item.Name,
+			Guid:    NormalizeGUID(item.Guid),
+			Channel: item.Channel,
+		}
+
+		for _, id := range sortedKeys(item.Messages) {
+			provider.Messages = append(provider.Messages, &BundleMessage{
+				Id:           int64(id),
+				EventId:      id & 0xffff,
+				EventVersion: -1,
+				Message:      item.Messages[id],
+			})
+		}
+
+		for _, id := range sortedKeys(item.Parameters) {
+			provider.Parameters = append(provider.Parameters, &BundleParameter{
+				Id:      int64(id),
+				Message: item.Parameters[id],
+			})
+		}
+		bundle.Providers = append(bundle.Providers, provider)
+	}
+	return bundle
+}
+
+func sortedKeys(items map[int]string) []int {
+	result := make([]int, 0, len(items))
+	for k := range items {
+		result = append(result, k)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// Calls the callback with the scanner of each row.
+func scanMessageDB(database *sql.DB, query string,
+	cb func(scan func(...interface{}) error) error) error {
+	rows, err := database.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = cb(rows.Scan)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// The provider with its records indexed for lookups.
+type bundleProvider struct {
+	*BundleProvider
+
+	// The position in the bundle, which breaks ties.
+	index int
+
+	messages_by_event map[int][]*BundleMessage
+	messages_by_id    map[int64][]*BundleMessage
+	parameters        map[int64][]*BundleParameter
+	events            map[int][]*BundleEvent
+}
+
+// BundleResolver resolves messages from a message bundle. It picks
+// the same messages as the DBResolver does from the database the
+// bundle was converted from.
+type BundleResolver struct {
+	source string
+
+	providers []*bundleProvider
+	by_name   map[string][]*bundleProvider
+	by_guid   map[string][]*bundleProvider
+
+	language string
+	build    int
+}
+
+func NewBundleResolver(bundle *MessageBundle) *BundleResolver {
+	self := &BundleResolver{
+		source:  "bundle",
+		by_name: make(map[string][]*bundleProvider),
+		by_guid: make(map[string][]*bundleProvider),
+	}
+
+	for idx, item := range bundle.Providers {
+		provider := &bundleProvider{
+			BundleProvider:    item,
+			index:             idx,
+			messages_by_event: make(map[int][]*BundleMessage),
+			messages_by_id:    make(map[int64][]*BundleMessage),
+			parameters:        make(map[int64][]*BundleParameter),
+			events:            make(map[int][]*BundleEvent),
+		}
+
+		for _, message := range item.Messages {
+			provider.messages_by_event[message.EventId] = append(
+				provider.messages_by_event[message.EventId], message)
+			provider.messages_by_id[message.Id] = append(
+				provider.messages_by_id[message.Id], message)
+		}
+
+		for _, parameter := range item.Parameters {
+			provider.parameters[parameter.Id] = append(
+				provider.parameters[parameter.Id], parameter)
+		}
+
+		for _, event := range item.Events {
+			provider.events[event.EventId] = append(
+				provider.events[event.EventId], event)
+		}
+
+		name := strings.ToLower(item.Name)
+		self.by_name[name] = append(self.by_name[name], provider)
+
+		guid := NormalizeGUID(item.Guid)
+		if guid != "" {
+			self.by_guid[guid] = append(self.by_guid[guid], provider)
+		}
+		self.providers = append(self.providers, provider)
+	}
+
+	return self
+}
+
+// LoadBundleResolver reads a message bundle file, compressed or as
+// exported by the messagedb export command.
+func LoadBundleResolver(filename string) (*BundleResolver, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var bundle *MessageBundle
+	if isBundleJSON(fd) {
+		_, err = fd.Seek(0, io.SeekStart)
+		if err == nil {
+			bundle, err = ReadMessageBundleJSON(fd)
+		}
+	} else {
+		_, err = fd.Seek(0, io.SeekStart)
+		if err == nil {
+			bundle, err = ReadMessageBundle(fd)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, filename)
+	}
+
+	self := NewBundleResolver(bundle)
+	self.source = "bundle:" + filename
+	return self, nil
+}
+
+// SetLanguage sets the preferred language of messages (e.g. de-DE).
+func (self *BundleResolver) SetLanguage(language string) {
+	self.language = language
+}
+
+// SetBuild prefers messages extracted from this Windows build.
+func (self *BundleResolver) SetBuild(build int) {
+	self.build = build
+}
+
+func (self *BundleResolver) getLanguage(language string) string {
+	if language != "" {
+		return language
+	}
+	return self.language
+}
+
+// Matches providers by name or GUID like the message database does.
+func (self *BundleResolver) matchProviders(provider string) []*bundleProvider {
+	seen := make(map[*bundleProvider]bool)
+	result := []*bundleProvider{}
+	add := func(providers []*bundleProvider) {
+		for _, item := range providers {
+			if !seen[item] {
+				seen[item] = true
+				result = append(result, item)
+			}
+		}
+	}
+
+	add(self.by_name[strings.ToLower(provider)])
+
+	guid := NormalizeGUID(provider)
+	if guid != "" {
+		for _, item := range self.by_guid[guid] {
+			add([]*bundleProvider{item})
+			add(self.by_name[strings.ToLower(item.Name)])
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].index < result[j].index
+	})
+	return result
+}
+
+// Candidates are ranked by a list of criteria in order of
+// importance, larger is better.
+type bundleRank []int64
+
+func (self bundleRank) better(other bundleRank) bool {
+	for i := range self {
+		if i >= len(other) {
+			return true
+		}
+		if self[i] != other[i] {
+			return self[i] > other[i]
+		}
+	}
+	return false
+}
+
+func rankBool(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// The same order as messageOrder() of the message database.
+func (self *BundleResolver) messageRank(provider *bundleProvider,
+	channel, language, message_language string, build int) bundleRank {
+	primary := strings.ToLower(primaryLanguage(language))
+	message_language = strings.ToLower(message_language)
+
+	return bundleRank{
+		rankBool(strings.EqualFold(provider.Channel, channel)),
+		rankBool(strings.EqualFold(message_language, language)),
+		rankBool(message_language == primary ||
+			strings.HasPrefix(message_language, primary+"-")),
+		rankBool(strings.HasPrefix(message_language, "en-")),
+		rankBool(message_language == ""),
+		rankBool(build == self.build),
+		int64(build),
+	}
+}
+
+func (self *BundleResolver) GetMessage(
+	provider, channel string, event_id, number_of_expansions int) string {
+	return self.GetVersionedMessage(
+		provider, channel, event_id, -1, number_of_expansions)
+}
+
+func (self *BundleResolver) GetVersionedMessage(provider, channel string,
+	event_id, version, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id,
+		version, number_of_expansions)
+}
+
+func (self *BundleResolver) GetQualifiedMessage(provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	return getMessageV2(self, provider, channel, qualifiers, event_id,
+		version, number_of_expansions)
+}
+
+func (self *BundleResolver) GetParameter(provider, channel string,
+	parameter_id int) string {
+	return getParameterV2(self, provider, channel, parameter_id)
+}
+
+func (self *BundleResolver) LookupMessage(
+	ctx context.Context, request *MessageRequest) (*MessageResult, error) {
+	providers := self.matchProviders(request.Provider)
+	if len(providers) == 0 {
+		return nil, ErrProviderNotFound
+	}
+
+	message_id := int64(-1)
+	if request.Qualifiers >= 0 {
+		message_id = QualifiedMessageId(request.Qualifiers, request.EventId)
+	}
+
+	language := self.getLanguage(request.Language)
+	version := request.Version
+
+	var best *BundleMessage
+	var best_rank bundleRank
+	for _, provider := range providers {
+		for _, message := range provider.messages_by_event[request.EventId] {
+			// Messages for all versions have a version of -1.
+			rank := bundleRank{
+				rankBool(message.Id == message_id),
+				rankBool(message.EventVersion >= 0 && message.EventVersion == version),
+				rankBool(message.EventVersion < 0),
+				rankBool(message.EventVersion >= 0 && message.EventVersion < version),
+			}
+			rank = append(rank, self.messageRank(provider, request.Channel,
+				language, message.Language, message.Build)...)
+			rank = append(rank, int64(message.EventVersion))
+
+			if best == nil || rank.better(best_rank) {
+				best, best_rank = message, rank
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, ErrMessageNotFound
+	}
+	return &MessageResult{Message: best.Message, Source: self.source}, nil
+}
+
+func (self *BundleResolver) LookupParameter(
+	ctx context.Context, request *ParameterRequest) (*MessageResult, error) {
+	providers := self.matchProviders(request.Provider)
+	if len(providers) == 0 {
+		return nil, ErrProviderNotFound
+	}
+
+	language := self.getLanguage(request.Language)
+
+	var best *BundleParameter
+	var best_rank bundleRank
+	for _, provider := range providers {
+		for _, parameter := range provider.parameters[int64(request.ParameterId)] {
+			rank := self.messageRank(provider, request.Channel,
+				language, parameter.Language, parameter.Build)
+			if best == nil || rank.better(best_rank) {
+				best, best_rank = parameter, rank
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, ErrMessageNotFound
+	}
+	return &MessageResult{Message: best.Message, Source: self.source}, nil
+}
+
+// GetEventFields returns the names of the event's data fields from
+// the provider's manifest.
+func (self *BundleResolver) GetEventFields(provider, channel string,
+	event_id, version int) []string {
+	var best *BundleEvent
+	var best_rank bundleRank
+	for _, item := range self.matchProviders(provider) {
+		for _, event := range item.events[event_id] {
+			if len(event.Fields) == 0 {
+				continue
+			}
+
+			rank := bundleRank{
+				rankBool(event.Version == version),
+				rankBool(event.Version < version),
+				int64(event.Version),
+				rankBool(strings.EqualFold(item.Channel, channel)),
+				rankBool(event.Build == self.build),
+				int64(event.Build),
+				-int64(item.index),
+			}
+			if best == nil || rank.better(best_rank) {
+				best, best_rank = event, rank
+			}
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	result := make([]string, 0, len(best.Fields))
+	for _, field := range best.Fields {
+		result = append(result, field.Name)
+	}
+	return result
+}
+
+// GetProviderValueName returns the localized name of the provider's
+// level, task, opcode or keyword, or its name in the manifest if
+// there is no message.
+func (self *BundleResolver) GetProviderValueName(provider, channel, kind string,
+	value uint64, task int) string {
+	type candidate struct {
+		rank bundleRank
+		name string
+	}
+	candidates := []candidate{}
+
+	for _, item := range self.matchProviders(provider) {
+		for _, provider_value := range item.Values {
+			if provider_value.Kind != kind || provider_value.Value != value ||
+				(provider_value.Task != 0 && provider_value.Task != task) {
+				continue
+			}
+
+			task_rank := rankBool(provider_value.Task == task)
+			build_rank := bundleRank{
+				rankBool(provider_value.Build == self.build),
+				int64(provider_value.Build),
+			}
+
+			messages := []*BundleMessage{}
+			if provider_value.MessageId >= 0 {
+				messages = item.messages_by_id[provider_value.MessageId]
+			}
+
+			// Without a message only the channel is known.
+			if len(messages) == 0 {
+				rank := bundleRank{task_rank, 0,
+					rankBool(strings.EqualFold(item.Channel, channel)),
+					0, 0, 0, 0, 0, -1}
+				candidates = append(candidates, candidate{
+					rank: append(rank, build_rank...),
+					name: provider_value.Name,
+				})
+				continue
+			}
+
+			for _, message := range messages {
+				rank := bundleRank{task_rank, 1}
+				rank = append(rank, self.messageRank(item, channel,
+					self.language, message.Language, message.Build)...)
+				candidates = append(candidates, candidate{
+					rank: append(rank, build_rank...),
+					name: message.Message,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].rank.better(candidates[j].rank)
+	})
+
+	for _, item := range candidates {
+		if item.name != "" {
+			return item.name
+		}
+	}
+	return ""
+}
+
+func (self *BundleResolver) Close() {}
@@ -0,0 +1,421 @@
+package evtx
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MessageConflict is a message or parameter which two sources define
+// differently for the same Windows build.
+type MessageConflict struct {
+	// "message" or "parameter".
+	Kind     string
+	Provider string
+	Guid     string `json:",omitempty"`
+	Channel  string `json:",omitempty"`
+
+	Id           int64
+	EventId      int `json:",omitempty"`
+	EventVersion int `json:",omitempty"`
+	Language     string
+	Build        int
+
+	Kept    string
+	Dropped string
+}
+
+type bundleMessageKey struct {
+	id            int64
+	event_id      int
+	event_version int
+	language      string
+}
+
+type bundleParameterKey struct {
+	id       int64
+	language string
+}
+
+type mergedProvider struct {
+	*BundleProvider
+
+	messages   map[bundleMessageKey][]*BundleMessage
+	parameters map[bundleParameterKey][]*BundleParameter
+}
+
+type bundleMerger struct {
+	result    *MessageBundle
+	providers map[string]*mergedProvider
+	conflicts []*MessageConflict
+}
+
+func (self *bundleMerger) getProvider(provider *BundleProvider) *mergedProvider {
+	key := providerKey(provider.Name, provider.Guid, provider.Channel)
+	result, pres := self.providers[key]
+	if !pres {
+		result = &mergedProvider{
+			BundleProvider: &BundleProvider{
+				Name:    provider.Name,
+				Guid:    NormalizeGUID(provider.Guid),
+				Channel: provider.Channel,
+			},
+			messages:   make(map[bundleMessageKey][]*BundleMessage),
+			parameters: make(map[bundleParameterKey][]*BundleParameter),
+		}
+		self.providers[key] = result
+		self.result.Providers = append(self.result.Providers, result.BundleProvider)
+	}
+	return result
+}
+
+func (self *bundleMerger) conflict(kind string, provider *mergedProvider,
+	conflict *MessageConflict) {
+	conflict.Kind = kind
+	conflict.Provider = provider.Name
+	conflict.Guid = provider.Guid
+	conflict.Channel = provider.Channel
+	self.conflicts = append(self.conflicts, conflict)
+}
+
+func (self *bundleMerger) addMessage(provider *mergedProvider, message *BundleMessage) {
+	key := bundleMessageKey{message.Id, message.EventId,
+		message.EventVersion, strings.ToLower(message.Language)}
+
+	// The same text from several builds is only kept once, for the
+	// newest build.
+	existing := provider.messages[key]
+	for _, item := range existing {
+		if item.Message == message.Message {
+			if message.Build > item.Build {
+				item.Build = message.Build
+			}
+			return
+		}
+	}
+
+	for _, item := range existing {
+		if item.Build == message.Build {
+			self.conflict("message", provider, &MessageConflict{
+				Id:           message.Id,
+				EventId:      message.EventId,
+				EventVersion: message.EventVersion,
+				Language:     message.Language,
+				Build:        message.Build,
+				Kept:         item.Message,
+				Dropped:      message.Message,
+			})
+			return
+		}
+	}
+
+	message_copy := *message
+	provider.messages[key] = append(existing, &message_copy)
+	provider.Messages = append(provider.Messages, &message_copy)
+}
+
+func (self *bundleMerger) addParameter(provider *mergedProvider,
+	parameter *BundleParameter) {
+	key := bundleParameterKey{parameter.Id, strings.ToLower(parameter.Language)}
+
+	existing := provider.parameters[key]
+	for _, item := range existing {
+		if item.Message == parameter.Message {
+			if parameter.Build > item.Build {
+				item.Build = parameter.Build
+			}
+			return
+		}
+	}
+
+	for _, item := range existing {
+		if item.Build == parameter.Build {
+			self.conflict("parameter", provider, &MessageConflict{
+				Id:       parameter.Id,
+				Language: parameter.Language,
+				Build:    parameter.Build,
+				Kept:     item.Message,
+				Dropped:  parameter.Message,
+			})
+			return
+		}
+	}
+
+	parameter_copy := *parameter
+	provider.parameters[key] = append(existing, &parameter_copy)
+	provider.Parameters = append(provider.Parameters, &parameter_copy)
+}
+
+// Event definitions and values are kept once if they are the same
+// apart from the build.
+func (self *bundleMerger) addEvent(provider *mergedProvider, event *BundleEvent) {
+	for _, item := range provider.Events {
+		other := *event
+		other.Build = item.Build
+		if reflect.DeepEqual(item, &other) {
+			if event.Build > item.Build {
+				item.Build = event.Build
+			}
+			return
+		}
+	}
+
+	event_copy := *event
+	provider.Events = append(provider.Events, &event_copy)
+}
+
+func (self *bundleMerger) addValue(provider *mergedProvider, value *BundleValue) {
+	for _, item := range provider.Values {
+		other := *value
+		other.Build = item.Build
+		if *item == other {
+			if value.Build > item.Build {
+				item.Build = value.Build
+			}
+			return
+		}
+	}
+
+	value_copy := *value
+	provider.Values = append(provider.Values, &value_copy)
+}
+
+// MergeMessageBundles combines bundles, e.g. extracted from several
+// Windows builds, into one. Identical messages are only kept
+// once. Messages which differ for the same build are reported as
+// conflicts and the one from the earlier bundle is kept.
+func MergeMessageBundles(bundles ...*MessageBundle) (*MessageBundle, []*MessageConflict) {
+	merger := &bundleMerger{
+		result:    NewMessageBundle(),
+		providers: make(map[string]*mergedProvider),
+	}
+
+	for _, bundle := range bundles {
+		for _, item := range bundle.Providers {
+			provider := merger.getProvider(item)
+			for _, message := range item.Messages {
+				merger.addMessage(provider, message)
+			}
+			for _, parameter := range item.Parameters {
+				merger.addParameter(provider, parameter)
+			}
+			for _, event := range item.Events {
+				merger.addEvent(provider, event)
+			}
+			for _, value := range item.Values {
+				merger.addValue(provider, value)
+			}
+		}
+	}
+
+	return merger.result, merger.conflicts
+}
+
+// MessageDiff is a difference between two message sources.
+type MessageDiff struct {
+	// "added", "removed" or "changed".
+	Change string
+
+	// "provider", "message" or "parameter".
+	Kind     string
+	Provider string
+	Guid     string `json:",omitempty"`
+	Channel  string `json:",omitempty"`
+
+	Id           int64  `json:",omitempty"`
+	EventId      int    `json:",omitempty"`
+	EventVersion int    `json:",omitempty"`
+	Language     string `json:",omitempty"`
+
+	Old string `json:",omitempty"`
+	New string `json:",omitempty"`
+}
+
+// The text of each message of the provider, from the newest build.
+func newestMessages(provider *BundleProvider) (
+	map[bundleMessageKey]string, map[bundleParameterKey]string) {
+	messages := make(map[bundleMessageKey]string)
+	message_builds := make(map[bundleMessageKey]int)
+	for _, item := range provider.Messages {
+		key := bundleMessageKey{item.Id, item.EventId, item.EventVersion,
+			strings.ToLower(item.Language)}
+		build, pres := message_builds[key]
+		if !pres || item.Build > build {
+			messages[key] = item.Message
+			message_builds[key] = item.Build
+		}
+	}
+
+	parameters := make(map[bundleParameterKey]string)
+	parameter_builds := make(map[bundleParameterKey]int)
+	for _, item := range provider.Parameters {
+		key := bundleParameterKey{item.Id, strings.ToLower(item.Language)}
+		build, pres := parameter_builds[key]
+		if !pres || item.Build > build {
+			parameters[key] = item.Message
+			parameter_builds[key] = item.Build
+		}
+	}
+
+	return messages, parameters
+}
+
+// DiffMessageBundles compares the messages and parameters of two
+// bundles. Only the newest build of each message is compared, so
+// bundles extracted from different builds are compared by their
+// text.
+func DiffMessageBundles(old, newer *MessageBundle) []*MessageDiff {
+	result := []*MessageDiff{}
+
+	// Providers registered for several channels are compared
+	// separately.
+	old_providers := make(map[string]*BundleProvider)
+	for _, provider := range old.Providers {
+		old_providers[providerKey(provider.Name, provider.Guid, provider.Channel)] = provider
+	}
+
+	new_providers := make(map[string]*BundleProvider)
+	keys := []string{}
+	for _, provider := range newer.Providers {
+		key := providerKey(provider.Name, provider.Guid, provider.Channel)
+		new_providers[key] = provider
+		keys = append(keys, key)
+	}
+
+	for _, provider := range old.Providers {
+		key := providerKey(provider.Name, provider.Guid, provider.Channel)
+		_, pres := new_providers[key]
+		if !pres {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		old_provider, old_pres := old_providers[key]
+		new_provider, new_pres := new_providers[key]
+
+		provider := new_provider
+		if !new_pres {
+			provider = old_provider
+		}
+
+		diff := func(change, kind string) *MessageDiff {
+			item := &MessageDiff{
+				Change:   change,
+				Kind:     kind,
+				Provider: provider.Name,
+				Guid:     NormalizeGUID(provider.Guid),
+				Channel:  provider.Channel,
+			}
+			result = append(result, item)
+			return item
+		}
+
+		if !old_pres {
+			diff("added", "provider")
+			continue
+		}
+
+		if !new_pres {
+			diff("removed", "provider")
+			continue
+		}
+
+		old_messages, old_parameters := newestMessages(old_provider)
+		new_messages, new_parameters := newestMessages(new_provider)
+
+		for _, key := range sortedMessageKeys(old_messages, new_messages) {
+			old_text, old_pres := old_messages[key]
+			new_text, new_pres := new_messages[key]
+
+			change := "changed"
+			if !old_pres {
+				change = "added"
+			} else if !new_pres {
+				change = "removed"
+			} else if old_text == new_text {
+				continue
+			}
+
+			item := diff(change, "message")
+			item.Id = key.id
+			item.EventId = key.event_id
+			item.EventVersion = key.event_version
+			item.Language = key.language
+			item.Old = old_text
+			item.New = new_text
+		}
+
+		for _, key := range sortedParameterKeys(old_parameters, new_parameters) {
+			old_text, old_pres := old_parameters[key]
+			new_text, new_pres := new_parameters[key]
+
+			change := "changed"
+			if !old_pres {
+				change = "added"
+			} else if !new_pres {
+				change = "removed"
+			} else if old_text == new_text {
+				continue
+			}
+
+			item := diff(change, "parameter")
+			item.Id = key.id
+			item.Language = key.language
+			item.Old = old_text
+			item.New = new_text
+		}
+	}
+
+	return result
+}
+
+func sortedMessageKeys(maps ...map[bundleMessageKey]string) []bundleMessageKey {
+	seen := make(map[bundleMessageKey]bool)
+	result := []bundleMessageKey{}
+	for _, items := range maps {
+		for key := range items {
+			if !seen[key] {
+				seen[key] = true
+				result = append(result, key)
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.event_id != b.event_id {
+			return a.event_id < b.event_id
+		}
+		if a.id != b.id {
+			return a.id < b.id
+		}
+		if a.event_version != b.event_version {
+			return a.event_version < b.event_version
+		}
+		return a.language < b.language
+	})
+	return result
+}
+
+func sortedParameterKeys(maps ...map[bundleParameterKey]string) []bundleParameterKey {
+	seen := make(map[bundleParameterKey]bool)
+	result := []bundleParameterKey{}
+	for _, items := range maps {
+		for key := range items {
+			if !seen[key] {
+				seen[key] = true
+				result = append(result, key)
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].id != result[j].id {
+			return result[i].id < result[j].id
+		}
+		return result[i].language < result[j].language
+	})
+	return result
+}
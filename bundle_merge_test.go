@@ -0,0 +1,77 @@
+package evtx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBundle(build int, messages ...string) *MessageBundle {
+	provider := &BundleProvider{Name: "Provider", Channel: "System"}
+	for idx, message := range messages {
+		provider.Messages = append(provider.Messages, &BundleMessage{
+			Id: int64(idx + 1), EventId: idx + 1, EventVersion: -1,
+			Language: "en-US", Build: build, Message: message})
+	}
+	provider.Parameters = append(provider.Parameters, &BundleParameter{
+		Id: 10, Language: "en-US", Build: build, Message: "Parameter"})
+
+	bundle := NewMessageBundle()
+	bundle.Providers = append(bundle.Providers, provider)
+	return bundle
+}
+
+func TestMergeMessageBundles(t *testing.T) {
+	first := testBundle(9600, "One", "Two")
+	second := testBundle(19041, "One", "Two changed")
+	third := testBundle(19041, "One", "Two conflict")
+	third.Providers = append(third.Providers, &BundleProvider{Name: "Other"})
+
+	merged, conflicts := MergeMessageBundles(first, second, third)
+	assert.Equal(t, 2, len(merged.Providers))
+
+	// Identical messages are kept once for the newest build.
+	messages := merged.Providers[0].Messages
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, "One", messages[0].Message)
+	assert.Equal(t, 19041, messages[0].Build)
+	assert.Equal(t, 9600, messages[1].Build)
+	assert.Equal(t, "Two changed", messages[2].Message)
+	assert.Equal(t, 1, len(merged.Providers[0].Parameters))
+
+	assert.Equal(t, 1, len(conflicts))
+	assert.Equal(t, "message", conflicts[0].Kind)
+	assert.Equal(t, 2, conflicts[0].EventId)
+	assert.Equal(t, "Two changed", conflicts[0].Kept)
+	assert.Equal(t, "Two conflict", conflicts[0].Dropped)
+
+	// The inputs are not changed.
+	assert.Equal(t, 9600, first.Providers[0].Messages[0].Build)
+}
+
+func TestDiffMessageBundles(t *testing.T) {
+	old := testBundle(9600, "One", "Two", "Three")
+	newer := testBundle(19041, "One", "Two changed")
+	newer.Providers[0].Parameters = nil
+	newer.Providers = append(newer.Providers, &BundleProvider{Name: "Added"})
+
+	diffs := DiffMessageBundles(old, newer)
+	assert.Equal(t, 4, len(diffs))
+
+	assert.Equal(t, "added", diffs[0].Change)
+	assert.Equal(t, "provider", diffs[0].Kind)
+	assert.Equal(t, "Added", diffs[0].Provider)
+
+	assert.Equal(t, "changed", diffs[1].Change)
+	assert.Equal(t, 2, diffs[1].EventId)
+	assert.Equal(t, "Two", diffs[1].Old)
+	assert.Equal(t, "Two changed", diffs[1].New)
+
+	assert.Equal(t, "removed", diffs[2].Change)
+	assert.Equal(t, 3, diffs[2].EventId)
+
+	assert.Equal(t, "removed", diffs[3].Change)
+	assert.Equal(t, "parameter", diffs[3].Kind)
+
+	assert.Equal(t, 0, len(DiffMessageBundles(old, old)))
+}
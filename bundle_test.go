@@ -0,0 +1,73 @@
+package evtx
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageBundleFormat(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	bundle := NewMessageBundle()
+	bundle.Version = MESSAGE_BUNDLE_VERSION + 1
+	assert.NoError(t, WriteMessageBundle(buffer, bundle))
+
+	_, err := ReadMessageBundle(buffer)
+	assert.Error(t, err)
+
+	_, err = ReadMessageBundle(bytes.NewReader([]byte("not gzip")))
+	assert.Error(t, err)
+}
+
+// testdata/messages.bundle is the database of writeTestMessageDB()
+// converted with the bundle command. It is read without cgo.
+func TestMessageBundleFixture(t *testing.T) {
+	filename := filepath.Join("testdata", "messages.bundle")
+	format, err := DetectMessageSourceFormat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, MESSAGE_SOURCE_BUNDLE, format)
+
+	resolver, err := LoadBundleResolver(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "bundle:"+filename, resolver.source)
+
+	guid := "54849625-5478-4994-A5BA-3E3B0328C30D"
+	assert.Equal(t, "An account was logged on.",
+		resolver.GetMessage("Microsoft-Windows-Security-Auditing", "Security", 4624, 0))
+	assert.Equal(t, "System message", resolver.GetMessage("Service", "System", 1, 0))
+	assert.Equal(t, "Application message",
+		resolver.GetMessage("service", "Application", 1, 0))
+	assert.Equal(t, "The %1 service failed: %2",
+		resolver.GetQualifiedMessage("Service", "System", 0xC000, 7036, -1, 2))
+	assert.Equal(t, "Impersonation", resolver.GetParameter(guid, "", 1833))
+	assert.Equal(t, []string{"SubjectUserSid", "LogonType"},
+		resolver.GetEventFields(guid, "Security", 4624, 1))
+	assert.Equal(t, "Security", resolver.GetProviderValueName(guid, "", "channel", 16, 0))
+
+	resolver.SetLanguage("de-AT")
+	resolver.SetBuild(9600)
+	assert.Equal(t, "Deutsch", resolver.GetMessage("Localized", "", 1, 0))
+	assert.Equal(t, "Parameter auf Deutsch", resolver.GetParameter("Localized", "", 10))
+	assert.Equal(t, "Informationen", resolver.GetProviderValueName(guid, "", "level", 4, 0))
+	assert.Equal(t, "Older build", resolver.GetMessage("Localized", "", 3, 0))
+}
+
+func TestMessageBundleJSONResolver(t *testing.T) {
+	bundle, err := LoadMessageBundle(filepath.Join("testdata", "messages.bundle"))
+	require.NoError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "messages.json")
+	fd, err := os.Create(filename)
+	require.NoError(t, err)
+	assert.NoError(t, WriteMessageBundleJSON(fd, bundle))
+	fd.Close()
+
+	resolver, err := LoadBundleResolver(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "bundle:"+filename, resolver.source)
+	assert.Equal(t, "System message", resolver.GetMessage("Service", "System", 1, 0))
+}
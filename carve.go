@@ -0,0 +1,72 @@
+package evtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// Chunks are aligned to at least a sector on disk.
+	CARVE_ALIGNMENT = 0x200
+
+	carve_buffer_size = 0x100000
+)
+
+// Verify the chunk header checksum. This is the CRC32 of the first
+// 120 bytes and bytes 128 to 512 of the header.
+func verifyChunkHeader(buf []byte) bool {
+	if len(buf) < EVTX_CHUNK_HEADER_SIZE ||
+		!bytes.HasPrefix(buf, []byte(EVTX_CHUNK_HEADER_MAGIC)) {
+		return false
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(buf[:120])
+	crc.Write(buf[128:EVTX_CHUNK_HEADER_SIZE])
+
+	return crc.Sum32() == binary.LittleEndian.Uint32(buf[124:])
+}
+
+// CarveChunks scans the reader for EVTX chunks (e.g. in unallocated
+// disk space or memory) and calls the callback for each valid chunk
+// found. The offset of the chunk within the reader is stored in
+// Chunk.Offset.
+func CarveChunks(reader io.ReaderAt, size int64,
+	cb func(chunk *Chunk) error) error {
+	buf := make([]byte, carve_buffer_size)
+	magic := []byte(EVTX_CHUNK_HEADER_MAGIC)
+
+	for offset := int64(0); offset < size; offset += carve_buffer_size {
+		n, err := reader.ReadAt(buf, offset)
+		if n == 0 && err != nil {
+			return nil
+		}
+
+		for i := 0; i+len(magic) <= n; i += CARVE_ALIGNMENT {
+			if !bytes.Equal(buf[i:i+len(magic)], magic) {
+				continue
+			}
+
+			chunk_offset := offset + int64(i)
+			data := make([]byte, EVTX_CHUNK_SIZE)
+			read, _ := reader.ReadAt(data, chunk_offset)
+			if read < EVTX_CHUNK_SIZE || !verifyChunkHeader(data) {
+				continue
+			}
+
+			chunk, err := NewChunkFromBuffer(data, chunk_offset)
+			if err != nil {
+				continue
+			}
+
+			err = cb(chunk)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
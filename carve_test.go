@@ -0,0 +1,58 @@
+package evtx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Chunks should be found at any sector aligned offset.
+func TestCarveChunks(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/Security.evtx")
+	assert.NoError(t, err)
+
+	// Place the second chunk of the file at an offset which is
+	// sector aligned but not chunk aligned in a buffer of junk.
+	chunk_data := data[0x1000+EVTX_CHUNK_SIZE : 0x1000+2*EVTX_CHUNK_SIZE]
+	image := bytes.Repeat([]byte{0xaa}, 3*EVTX_CHUNK_SIZE)
+	copy(image[0x1200:], chunk_data)
+
+	offsets := []int64{}
+	records := 0
+	err = CarveChunks(bytes.NewReader(image), int64(len(image)),
+		func(chunk *Chunk) error {
+			offsets = append(offsets, chunk.Offset)
+			parsed, err := chunk.Parse(0)
+			records += len(parsed)
+			return err
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0x1200}, offsets)
+	assert.True(t, records > 0)
+
+	// Chunks at offsets which are not sector aligned are not found.
+	unaligned := bytes.Repeat([]byte{0xaa}, 3*EVTX_CHUNK_SIZE)
+	copy(unaligned[0x1234:], chunk_data)
+
+	offsets = nil
+	err = CarveChunks(bytes.NewReader(unaligned), int64(len(unaligned)),
+		func(chunk *Chunk) error {
+			offsets = append(offsets, chunk.Offset)
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(offsets))
+
+	// Corrupting the header invalidates the checksum.
+	image[0x1200+20] ^= 0xff
+	offsets = nil
+	err = CarveChunks(bytes.NewReader(image), int64(len(image)),
+		func(chunk *Chunk) error {
+			offsets = append(offsets, chunk.Offset)
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(offsets))
+}
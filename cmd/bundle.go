@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/evtx"
+)
+
+var (
+	bundle = app.Command("bundle", "Convert a message database or a YAML/JSON "+
+		"message map to a message bundle, which is read without cgo.")
+	bundle_source = bundle.Arg("source", "message database or message map").Required().
+			ExistingFile()
+	bundle_output = bundle.Arg("output", "The message bundle to write").Required().
+			String()
+)
+
+func doBundle() {
+	message_bundle, err := evtx.LoadMessageBundle(*bundle_source)
+	kingpin.FatalIfError(err, "Reading %v", *bundle_source)
+
+	fd, err := os.Create(*bundle_output)
+	kingpin.FatalIfError(err, "Creating %v", *bundle_output)
+
+	err = evtx.WriteMessageBundle(fd, message_bundle)
+	if err == nil {
+		err = fd.Close()
+	} else {
+		fd.Close()
+	}
+	kingpin.FatalIfError(err, "Writing %v", *bundle_output)
+
+	fmt.Printf("Wrote %v providers to %v\n", len(message_bundle.Providers),
+		*bundle_output)
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case bundle.FullCommand():
+			doBundle()
+
+		default:
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Velocidex/ordereddict"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/evtx"
+)
+
+var (
+	coverage       = app.Command("coverage", "Report which events get messages and from which message source.")
+	coverage_files = coverage.Arg("files", "Files, directories or globs to check (@file to read a list "+
+		"of files). Compressed files are decompressed.").Required().Strings()
+
+	coverage_glob = coverage.Flag("glob", "Which files to check in directories.").
+			Default("*.evtx").String()
+
+	coverage_message_file = coverage.Flag("messagedb", "Path to a messages database, message bundle, MTA file or a YAML/JSON "+
+		"message map. May be repeated: earlier sources take precedence and the native resolver is used last.").
+		Strings()
+
+	coverage_language = coverage.Flag("language", "Preferred language of messages (e.g. de-DE).").
+				String()
+
+	coverage_problems = coverage.Flag("problems", "Only report events with unresolved messages, "+
+		"parameters or missing inserts.").Bool()
+
+	coverage_json = coverage.Flag("json", "Write the report as JSON.").Bool()
+)
+
+func hasCoverageProblems(entry *evtx.CoverageEntry) bool {
+	return entry.Resolved < entry.Events || entry.InsertMismatches > 0 ||
+		len(entry.UnresolvedParameters) > 0
+}
+
+func doCoverage() {
+	resolver, err := newResolver(*coverage_message_file, false, *coverage_language)
+	kingpin.FatalIfError(err, " %v", err)
+
+	report := evtx.NewCoverageReport()
+	for _, arg := range *coverage_files {
+		filenames, _, err := expandPath(arg, *coverage_glob)
+		kingpin.FatalIfError(err, "Finding files")
+
+		// The order of the events does not matter so each file is
+		// read on its own.
+		for _, filename := range filenames {
+			forEachFileEvent(filename, func(event *ordereddict.Dict,
+				record *evtx.EventRecord) {
+				report.Add(evtx.CheckMessageCoverage(context.Background(), event,
+					record.MessageArgs, resolver, *coverage_language))
+			}, func(err error) {
+				fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", filename, err)
+			})
+		}
+	}
+
+	entries := []*evtx.CoverageEntry{}
+	for _, entry := range report.Entries() {
+		if !*coverage_problems || hasCoverageProblems(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	if *coverage_json {
+		for _, entry := range entries {
+			serialized, _ := json.MarshalIndent(entry, " ", " ")
+			fmt.Println(string(serialized))
+		}
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "Provider\tEventID\tEvents\tResolved\tSources\tInserts\tUnresolved Parameters")
+	for _, entry := range entries {
+		sources := []string{}
+		for source := range entry.Sources {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+
+		// The inserts the message uses out of those the event has.
+		inserts := ""
+		if entry.Resolved > 0 {
+			inserts = fmt.Sprintf("%v/%v", entry.MessageInserts, entry.EventInserts)
+			if entry.InsertMismatches > 0 {
+				inserts += " mismatch"
+			}
+		}
+
+		parameters := []string{}
+		for _, param_id := range entry.UnresolvedParameters {
+			parameters = append(parameters, fmt.Sprintf("%%%%%v", param_id))
+		}
+
+		fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", entry.Provider,
+			entry.EventId, entry.Events, entry.Resolved,
+			strings.Join(sources, ","), inserts, strings.Join(parameters, ","))
+	}
+	writer.Flush()
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case coverage.FullCommand():
+			doCoverage()
+
+		default:
+			return false
+		}
+		return true
+	})
+}
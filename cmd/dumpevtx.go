@@ -28,12 +28,27 @@ var (
 	command_handlers []CommandHandler
 )
 
+const (
+	// kingpin does not accept a bare "-" as an argument so we
+	// replace it with this placeholder before parsing.
+	STDIN_PATH = "<stdin>"
+)
+
 type CommandHandler func(command string) bool
 
 func main() {
 	app.HelpFlag.Short('h')
 	app.UsageTemplate(kingpin.CompactUsageTemplate)
-	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	args := []string{}
+	for _, arg := range os.Args[1:] {
+		if arg == "-" {
+			arg = STDIN_PATH
+		}
+		args = append(args, arg)
+	}
+
+	command := kingpin.MustParse(app.Parse(args))
 
 	for _, handler := range command_handlers {
 		if handler(command) {
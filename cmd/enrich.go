@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+
+	"github.com/Velocidex/ordereddict"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/evtx"
+)
+
+// The flags selecting the message sources and what is added to the
+// printed events. They are shared by all the commands printing
+// events.
+type enrichOptions struct {
+	message_files         *[]string
+	disable_messages      *bool
+	language              *string
+	resolve_parameters    *bool
+	resolve_names         *bool
+	resolve_sids          *bool
+	decode_security       *bool
+	message_errors        *bool
+	prefer_rendering_info *bool
+	check_schema          *bool
+}
+
+func addEnrichFlags(command *kingpin.CmdClause) *enrichOptions {
+	return &enrichOptions{
+		message_files: command.Flag("messagedb", "Path to a messages database, message bundle, MTA file or a YAML/JSON "+
+			"message map. May be repeated: earlier sources take precedence and the native resolver is used last.").
+			Strings(),
+
+		disable_messages: command.Flag("disable_messages", "Disable message resolver.").
+			Bool(),
+
+		language: command.Flag("language", "Preferred language of messages (e.g. de-DE).").
+			String(),
+
+		resolve_parameters: command.Flag("resolve_parameters",
+			"Replace %%NNNN codes in EventData and UserData with their parameter strings.").Bool(),
+
+		resolve_names: command.Flag("resolve_names",
+			"Add the names of the Level, Task, Opcode and Keywords (e.g. LevelName).").Bool(),
+
+		resolve_sids: command.Flag("resolve_sids",
+			"Add the account names of SIDs (e.g. UserIDName), learning accounts from all the events first.").Bool(),
+
+		decode_security: command.Flag("decode_security",
+			"Add the names of Security auditing codes, e.g. LogonTypeName and StatusName.").Bool(),
+
+		message_errors: command.Flag("message_errors",
+			"Add a MessageError field explaining why the message could not be resolved.").Bool(),
+
+		prefer_rendering_info: command.Flag("prefer_rendering_info",
+			"Use the message and names rendered into forwarded or exported events before the message sources.").Bool(),
+
+		check_schema: command.Flag("check_schema",
+			"Report differences between the event data and the fields declared in the provider's manifest.").Bool(),
+	}
+}
+
+// Build the message resolver selected by the flags.
+func (self *enrichOptions) newResolver() (evtx.MessageResolver, error) {
+	return newResolver(*self.message_files, *self.disable_messages,
+		*self.language)
+}
+
+// Add the message and the names selected by the flags to the
+// event. The SID resolver is nil unless SIDs are resolved.
+func (self *enrichOptions) enrichEvent(event *ordereddict.Dict,
+	args []interface{}, resolver evtx.MessageResolver,
+	sids *evtx.SIDResolver) {
+	if resolver != nil {
+		message, err := evtx.ExpandMessageWithContext(context.Background(),
+			event, args, resolver, "", *self.prefer_rendering_info)
+		if err == nil {
+			event.Set("Message", message.Message)
+			event.Set("MessageSource", message.Source)
+		} else {
+			event.Set("Message", "")
+			if *self.message_errors {
+				event.Set("MessageError", err.Error())
+			}
+		}
+
+		if *self.resolve_parameters {
+			evtx.ResolveParameters(event, resolver)
+		}
+
+		evtx.NameEventData(event, resolver)
+
+		if *self.check_schema {
+			schema_errors := evtx.CheckEventSchema(event, resolver)
+			if schema_errors != nil {
+				event.Set("SchemaErrors", schema_errors)
+			}
+		}
+	}
+
+	if *self.resolve_names {
+		evtx.ResolveSystemNames(event, resolver, *self.prefer_rendering_info)
+	}
+
+	if sids != nil {
+		sids.ResolveSIDs(event)
+	}
+
+	if *self.decode_security {
+		evtx.DecodeSecurityEventData(event)
+	}
+}
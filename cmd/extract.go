@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/evtx"
+)
+
+var (
+	extract      = app.Command("extract", "Extract all log messages from all providers.")
+	extract_file = extract.Arg("file", "File to write all messages").Required().
+			String()
+
+	extract_languages = extract.Flag("language", "Extract messages from MUI files of this language.").
+				Default("en-US").Strings()
+
+	extract_root = extract.Flag("root", "Extract from the Windows installation mounted at this directory instead of the running system.").
+			ExistingDir()
+)
+
+// Extract the messages of an offline system, e.g. a mounted disk
+// image.
+func extractOffline(writer *evtx.MessageDBWriter, root string) error {
+	system, err := evtx.OpenOfflineSystem(root)
+	if err != nil {
+		return err
+	}
+	defer system.Close()
+
+	writer.Build = system.BuildNumber()
+
+	return system.WalkProviders(func(provider *evtx.ProviderRegistration) error {
+		return evtx.ExtractProvider(writer, provider,
+			system.ExpandLocations, logExtract)
+	})
+}
+
+func logExtract(format string, args ...interface{}) {
+	fmt.Printf(format, args...)
+}
+
+func doExtract() {
+	writer, err := evtx.NewMessageDBWriter(*extract_file)
+	kingpin.FatalIfError(err, "Can not open file %s: %v", *extract_file, err)
+
+	evtx.MUILanguages = *extract_languages
+
+	if *extract_root != "" {
+		err = extractOffline(writer, *extract_root)
+	} else {
+		err = extractLive(writer)
+	}
+	kingpin.FatalIfError(err, "Walking providers")
+
+	err = writer.Close()
+	kingpin.FatalIfError(err, "Writing %v", *extract_file)
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case extract.FullCommand():
+			doExtract()
+
+		default:
+			return false
+		}
+		return true
+	})
+}
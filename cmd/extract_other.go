@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+
+	"www.velocidex.com/golang/evtx"
+)
+
+// The registry of the running system is only available on Windows.
+func extractLive(writer *evtx.MessageDBWriter) error {
+	return errors.New("Extracting from the running system is only supported on Windows, use --root to extract from a mounted image")
+}
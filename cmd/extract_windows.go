@@ -4,30 +4,35 @@
 package main
 
 import (
-	"database/sql"
-	"errors"
 	"fmt"
-	"os"
-	"strings"
+	"strconv"
 
-	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/sys/windows/registry"
-	kingpin "gopkg.in/alecthomas/kingpin.v2"
-	"www.velocidex.com/golang/binparsergen/reader"
 	"www.velocidex.com/golang/evtx"
-	pe "www.velocidex.com/golang/go-pe"
 )
 
-var (
-	extract      = app.Command("extract", "Extract all log messages from all providers.")
-	extract_file = extract.Arg("file", "File to write all messages").Required().
-			String()
-)
+// The build number of the running system.
+func getBuildNumber() int {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE,
+		`SOFTWARE\Microsoft\Windows NT\CurrentVersion`,
+		registry.READ|registry.WOW64_64KEY)
+	if err != nil {
+		return 0
+	}
+	defer key.Close()
+
+	build, _, err := key.GetStringValue("CurrentBuildNumber")
+	if err != nil {
+		return 0
+	}
 
-// Walk over all the providers in the registry and call the callback
-// with potential message files. The message_table paths are not
-// guaranteed to exists.
-func walkProvider(cb func(provider string, message_table string) error) error {
+	result, _ := strconv.Atoi(build)
+	return result
+}
+
+// Walk over all the providers in the registry: the legacy EventLog
+// sources of each channel and the manifest based publishers.
+func walkProviders(cb func(provider *evtx.ProviderRegistration) error) error {
 	channels_key, err := registry.OpenKey(registry.LOCAL_MACHINE,
 		`SYSTEM\CurrentControlSet\Services\EventLog`,
 		registry.READ|registry.ENUMERATE_SUB_KEYS|registry.WOW64_64KEY)
@@ -62,145 +67,80 @@ func walkProvider(cb func(provider string, message_table string) error) error {
 				continue
 			}
 
-			message_files, _, err := one_provider_key.GetStringValue("EventMessageFile")
-			if err != nil {
+			message_files, _, _ := one_provider_key.GetStringValue("EventMessageFile")
+			parameter_files, _, _ := one_provider_key.GetStringValue("ParameterMessageFile")
+			guid, _, _ := one_provider_key.GetStringValue("ProviderGuid")
+			one_provider_key.Close()
+
+			if message_files == "" && parameter_files == "" {
 				continue
 			}
 
-			for _, message_file := range evtx.ExpandLocations(message_files) {
-				err = cb(provider_name, message_file)
-				if err != nil {
-					fmt.Printf("While processing %v (%v): %v\n",
-						provider_name, message_file, err)
-					continue
-				}
+			err = cb(&evtx.ProviderRegistration{
+				Name:           provider_name,
+				Guid:           guid,
+				Channel:        channel,
+				MessageFiles:   message_files,
+				ParameterFiles: parameter_files,
+			})
+			if err != nil {
+				return err
 			}
 		}
-
 	}
 
-	return nil
-}
-
-func makeDatabase(filename string) (*sql.DB, error) {
-	database, err := sql.Open("sqlite3", filename)
+	publishers_key, err := registry.OpenKey(registry.LOCAL_MACHINE,
+		`Software\Microsoft\Windows\CurrentVersion\WinEVT\Publishers`,
+		registry.READ|registry.ENUMERATE_SUB_KEYS|registry.WOW64_64KEY)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer publishers_key.Close()
 
-	_, err = database.Exec(`
-    CREATE TABLE IF NOT EXISTS providers (
-         id INTEGER PRIMARY KEY,
-         name TEXT);
-
-    CREATE TABLE IF NOT EXISTS messages (
-         id INTEGER NOT NULL,
-         event_id INTEGER NOT NULL,
-         provider_id INTEGER NOT NULL,
-         message TEXT
-    );
-
-    CREATE INDEX message_idx
-    ON messages (event_id, provider_id);
-`)
+	guids, err := publishers_key.ReadSubKeyNames(-1)
 	if err != nil {
-		database.Close()
-		return nil, err
+		return err
 	}
 
-	return database, nil
-}
-
-func doExtract() {
-	providers := make(map[string]int64)
-
-	handle, err := makeDatabase(*extract_file)
-	kingpin.FatalIfError(err, "Can not open file %s: %v", *extract_file, err)
-
-	defer handle.Close()
-
-	get_provider_id, err := handle.Prepare("SELECT id from providers WHERE name = ?")
-	kingpin.FatalIfError(err, " %v", err)
-	defer get_provider_id.Close()
-
-	insert_provider_id, err := handle.Prepare("INSERT INTO providers (name) values (?)")
-	kingpin.FatalIfError(err, " %v", err)
-	defer insert_provider_id.Close()
-
-	insert_message, err := handle.Prepare("INSERT INTO messages (id, event_id, provider_id, message) VALUES (?, ?, ?, ?)")
-	kingpin.FatalIfError(err, " %v", err)
-	defer insert_message.Close()
-
-	walkProvider(func(provider, message_table string) error {
-		provider_id, pres := providers[provider]
-		if !pres {
-			rows, err := get_provider_id.Query(provider)
-			kingpin.FatalIfError(err, "%v", err)
-
-			defer rows.Close()
-
-			i := 0
-			for rows.Next() {
-				rows.Scan(&provider_id)
-				i += 1
-			}
-
-			if i == 0 {
-				res, err := insert_provider_id.Exec(provider)
-				if err != nil {
-					return err
-				}
-				provider_id, _ = res.LastInsertId()
-			}
-			providers[provider] = provider_id
+	for _, guid := range guids {
+		publisher_key, err := registry.OpenKey(publishers_key, guid,
+			registry.READ|registry.WOW64_64KEY)
+		if err != nil {
+			continue
 		}
 
-		fd, err := os.Open(message_table)
+		message_files, _, _ := publisher_key.GetStringValue("MessageFileName")
+		parameter_files, _, _ := publisher_key.GetStringValue("ParameterFileName")
+		name, _, err := publisher_key.GetStringValue("")
 		if err != nil {
-			return nil
+			name = evtx.NormalizeGUID(guid)
 		}
-		defer fd.Close()
-
-		fmt.Printf("Opening message table file %v\n", message_table)
+		publisher_key.Close()
 
-		reader, err := reader.NewPagedReader(fd, 4096, 100)
-		if err != nil {
-			return err
+		if message_files == "" && parameter_files == "" {
+			continue
 		}
 
-		pe_file, err := pe.NewPEFile(reader)
+		err = cb(&evtx.ProviderRegistration{
+			Name:           name,
+			Guid:           guid,
+			MessageFiles:   message_files,
+			ParameterFiles: parameter_files,
+		})
 		if err != nil {
 			return err
 		}
+	}
 
-		messages := pe_file.GetMessages()
-		if len(messages) > 10000 {
-			return errors.New("Too many messages in dll")
-		}
-		for _, msg := range messages {
-			message := strings.TrimSpace(msg.Message)
-			_, err := insert_message.Exec(msg.Id, msg.EventId, provider_id, message)
-			if err != nil {
-				fmt.Printf("Err: %v %v %v\n", err, msg.EventId, provider_id)
-			}
-		}
-		if len(messages) > 0 {
-			fmt.Printf("Got %v messages for provider %v (%v) in %v\n",
-				len(messages), provider, provider_id, message_table)
-		}
-		return nil
-	})
+	return nil
 }
 
-func init() {
-	command_handlers = append(command_handlers, func(command string) bool {
-		switch command {
-		case extract.FullCommand():
-			doExtract()
+// Extract the messages of the running system.
+func extractLive(writer *evtx.MessageDBWriter) error {
+	writer.Build = getBuildNumber()
 
-		default:
-			return false
-		}
-		return true
+	return walkProviders(func(provider *evtx.ProviderRegistration) error {
+		return evtx.ExtractProvider(writer, provider,
+			evtx.ExpandLocations, logExtract)
 	})
 }
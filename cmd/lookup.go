@@ -1,16 +1,16 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 
 	_ "github.com/mattn/go-sqlite3"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/evtx"
 )
 
 var (
 	lookup      = app.Command("lookup", "Lookup log message.")
-	lookup_file = lookup.Arg("file", "message database").Required().
+	lookup_file = lookup.Arg("file", "message database or bundle").Required().
 			String()
 
 	lookup_provider = lookup.Arg("provider", "Provider name").Required().String()
@@ -25,9 +25,18 @@ type Row struct {
 }
 
 func doLookup() {
-	database, err := sql.Open("sqlite3", *lookup_file)
+	format, err := evtx.DetectMessageSourceFormat(*lookup_file)
 	kingpin.FatalIfError(err, " %v", err)
 
+	if format != evtx.MESSAGE_SOURCE_SQLITE {
+		lookupBundle()
+		return
+	}
+
+	database, err := evtx.OpenMessageDBReadOnly(*lookup_file)
+	kingpin.FatalIfError(err, "Reading %v", *lookup_file)
+	defer database.Close()
+
 	get_events, err := database.Prepare(`
           SELECT messages.id, providers.name, event_id, message
           FROM messages left join providers ON messages.provider_id = providers.id
@@ -50,6 +59,24 @@ func doLookup() {
 	}
 }
 
+func lookupBundle() {
+	bundle, err := evtx.LoadMessageBundle(*lookup_file)
+	kingpin.FatalIfError(err, " %v", err)
+
+	for _, provider := range bundle.Providers {
+		if provider.Name != *lookup_provider {
+			continue
+		}
+
+		for _, message := range provider.Messages {
+			if int64(message.EventId) == *lookup_eventid {
+				fmt.Printf("%v %v %v %v\n", message.Id, message.EventId,
+					provider.Name, message.Message)
+			}
+		}
+	}
+}
+
 func init() {
 	command_handlers = append(command_handlers, func(command string) bool {
 		switch command {
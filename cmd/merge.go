@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Velocidex/ordereddict"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/evtx"
+)
+
+var (
+	merge       = app.Command("merge", "Merge the events of many files into a single timeline.")
+	merge_files = merge.Arg("files", "Files, directories or globs to merge (@file to read a list "+
+		"of files). Compressed files are decompressed.").Required().Strings()
+
+	merge_glob = merge.Flag("glob", "Which files to merge in directories.").
+			Default("*.evtx").String()
+
+	merge_output_file = merge.Flag("output", "File to write json in").
+				OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
+
+	merge_enrich = addEnrichFlags(merge)
+
+	merge_number_of_records = merge.Flag("number", "How many records to print").
+				Default("99999999").Int()
+)
+
+// Open the file for merging. Regular logs are only opened while a
+// chunk is read. Compressed files can only be read as a stream, so
+// they stay open until the merger is done with them.
+func openMergeSource(filename string) (*evtx.MergeSource, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, len(evtx.EVTX_HEADER_MAGIC))
+	_, err = io.ReadFull(fd, magic)
+	if err == nil {
+		_, err = fd.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	source := &evtx.MergeSource{Name: filename}
+
+	if string(magic) != evtx.EVTX_HEADER_MAGIC {
+		reader, err := evtx.NewDecompressingReader(fd)
+		if err == nil {
+			source.Chunks, err = evtx.NewChunkStream(reader)
+		}
+		if err != nil {
+			fd.Close()
+			return nil, err
+		}
+		source.Closer = fd
+		return source, nil
+	}
+	fd.Close()
+
+	source.Chunks, err = evtx.NewFileChunkIterator(filename)
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// Call the callback with each event of the file. The file is read on
+// its own in the order of its chunks and closed when done. Errors
+// reading chunks are passed to on_error and the rest of the file is
+// still read.
+func forEachFileEvent(filename string,
+	callback func(event *ordereddict.Dict, record *evtx.EventRecord),
+	on_error func(err error)) {
+	source, err := openMergeSource(filename)
+	if err != nil {
+		on_error(err)
+		return
+	}
+	if source.Closer != nil {
+		defer source.Closer.Close()
+	}
+
+	for {
+		chunk, err := source.Chunks.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			on_error(err)
+			return
+		}
+
+		// Some records may still be returned with an error.
+		records, err := chunk.Parse(0)
+		if err != nil {
+			on_error(fmt.Errorf("Chunk at offset %#x: %w", chunk.Offset, err))
+		}
+
+		for _, record := range records {
+			event_map, ok := record.Event.(*ordereddict.Dict)
+			if !ok {
+				continue
+			}
+
+			event, ok := ordereddict.GetMap(event_map, "Event")
+			if ok {
+				callback(event, record)
+			}
+		}
+	}
+}
+
+func doMerge() {
+	resolver, err := merge_enrich.newResolver()
+	kingpin.FatalIfError(err, " %v", err)
+
+	filenames := []string{}
+	for _, arg := range *merge_files {
+		found, _, err := expandPath(arg, *merge_glob)
+		kingpin.FatalIfError(err, "Finding files")
+		filenames = append(filenames, found...)
+	}
+
+	// Accounts are learned from all the merged logs before merging,
+	// so events before the one naming an account get its name too.
+	// Errors are reported when merging.
+	var sids *evtx.SIDResolver
+	if *merge_enrich.resolve_sids {
+		sids = evtx.NewSIDResolver()
+		for _, filename := range filenames {
+			forEachFileEvent(filename, func(event *ordereddict.Dict,
+				record *evtx.EventRecord) {
+				evtx.NameEventData(event, resolver)
+				sids.Learn(event)
+			}, func(err error) {})
+		}
+	}
+
+	sources := []*evtx.MergeSource{}
+	for _, filename := range filenames {
+		source, err := openMergeSource(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", filename, err)
+			continue
+		}
+
+		sources = append(sources, source)
+	}
+
+	merger := evtx.NewMerger(sources)
+	defer merger.Close()
+	merger.OnError = func(source string, err error) {
+		fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", source, err)
+	}
+
+	for count := 0; count < *merge_number_of_records; {
+		record, err := merger.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		kingpin.FatalIfError(err, "Merging")
+
+		event_map, ok := record.Record.Event.(*ordereddict.Dict)
+		if !ok {
+			continue
+		}
+
+		event, ok := ordereddict.GetMap(event_map, "Event")
+		if !ok {
+			continue
+		}
+
+		merge_enrich.enrichEvent(event, record.Record.MessageArgs, resolver, sids)
+		event.Set("Source", ordereddict.NewDict().Set("Path", record.Source.Name))
+
+		serialized, _ := json.MarshalIndent(event, " ", " ")
+		if *merge_output_file == nil {
+			fmt.Println(string(serialized))
+		} else {
+			(*merge_output_file).Write(serialized)
+		}
+		count++
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case merge.FullCommand():
+			doMerge()
+
+		default:
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/evtx"
+)
+
+var (
+	messagedb = app.Command("messagedb", "Inspect and maintain message databases. "+
+		"Sources may be databases, bundles or YAML/JSON message maps.")
+
+	messagedb_list        = messagedb.Command("list", "List the providers and their message counts.")
+	messagedb_list_source = messagedb_list.Arg("source", "Message source").Required().
+				ExistingFile()
+
+	messagedb_search        = messagedb.Command("search", "Search the message text.")
+	messagedb_search_source = messagedb_search.Arg("source", "Message source").Required().
+				ExistingFile()
+	messagedb_search_regex = messagedb_search.Arg("regex", "Regex to match the messages").
+				Required().String()
+	messagedb_search_provider = messagedb_search.Flag("provider",
+		"Only search providers matching this regex").String()
+
+	messagedb_merge        = messagedb.Command("merge", "Merge message sources, e.g. from several Windows builds.")
+	messagedb_merge_inputs = messagedb_merge.Arg("inputs", "Message sources, earlier sources "+
+		"win conflicts").Required().ExistingFiles()
+	messagedb_merge_output = messagedb_merge.Flag("output", "The file to write: a .bundle, "+
+		".json or otherwise a new message database").Required().String()
+
+	messagedb_diff     = messagedb.Command("diff", "Show the messages which differ between two sources.")
+	messagedb_diff_old = messagedb_diff.Arg("old", "Message source").Required().ExistingFile()
+	messagedb_diff_new = messagedb_diff.Arg("new", "Message source").Required().ExistingFile()
+
+	messagedb_export        = messagedb.Command("export", "Export a message source as JSON.")
+	messagedb_export_source = messagedb_export.Arg("source", "Message source").Required().
+				ExistingFile()
+	messagedb_export_output = messagedb_export.Flag("output", "File to write the JSON to").
+				String()
+
+	messagedb_import        = messagedb.Command("import", "Import an exported JSON file into a new message database.")
+	messagedb_import_source = messagedb_import.Arg("json", "Exported JSON file").Required().
+				ExistingFile()
+	messagedb_import_output = messagedb_import.Arg("database", "The message database to create").
+				Required().String()
+)
+
+func doMessageDBList() {
+	bundle, err := evtx.LoadMessageBundle(*messagedb_list_source)
+	kingpin.FatalIfError(err, "Reading %v", *messagedb_list_source)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "Provider\tGUID\tChannel\tMessages\tParameters\tEvents")
+	for _, provider := range bundle.Providers {
+		fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t%v\t%v\n", provider.Name,
+			provider.Guid, provider.Channel, len(provider.Messages),
+			len(provider.Parameters), len(provider.Events))
+	}
+	writer.Flush()
+}
+
+type searchResult struct {
+	Provider string
+	Guid     string `json:",omitempty"`
+	Channel  string `json:",omitempty"`
+	Id       int64
+	EventId  int
+	Language string `json:",omitempty"`
+	Build    int    `json:",omitempty"`
+	Message  string
+}
+
+func doMessageDBSearch() {
+	regex, err := regexp.Compile(*messagedb_search_regex)
+	kingpin.FatalIfError(err, "Search regex")
+
+	var provider_regex *regexp.Regexp
+	if *messagedb_search_provider != "" {
+		provider_regex, err = regexp.Compile("(?i)" + *messagedb_search_provider)
+		kingpin.FatalIfError(err, "Provider regex")
+	}
+
+	bundle, err := evtx.LoadMessageBundle(*messagedb_search_source)
+	kingpin.FatalIfError(err, "Reading %v", *messagedb_search_source)
+
+	for _, provider := range bundle.Providers {
+		if provider_regex != nil && !provider_regex.MatchString(provider.Name) {
+			continue
+		}
+
+		for _, message := range provider.Messages {
+			if !regex.MatchString(message.Message) {
+				continue
+			}
+
+			serialized, _ := json.MarshalIndent(&searchResult{
+				Provider: provider.Name,
+				Guid:     provider.Guid,
+				Channel:  provider.Channel,
+				Id:       message.Id,
+				EventId:  message.EventId,
+				Language: message.Language,
+				Build:    message.Build,
+				Message:  message.Message,
+			}, " ", " ")
+			fmt.Println(string(serialized))
+		}
+	}
+}
+
+// Write the bundle in the format given by the file's extension.
+func writeMessageSource(filename string, bundle *evtx.MessageBundle) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".bundle":
+		return writeMessageFile(filename, bundle, evtx.WriteMessageBundle)
+	case ".json":
+		return writeMessageFile(filename, bundle, evtx.WriteMessageBundleJSON)
+	}
+
+	// Adding to an existing database would mix in its messages
+	// without checking them for conflicts.
+	_, err := os.Stat(filename)
+	if err == nil {
+		return fmt.Errorf("%v already exists", filename)
+	}
+	return evtx.MessageBundleToDB(bundle, filename)
+}
+
+func writeMessageFile(filename string, bundle *evtx.MessageBundle,
+	write func(io.Writer, *evtx.MessageBundle) error) error {
+	fd, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = write(fd, bundle)
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+func doMessageDBMerge() {
+	bundles := []*evtx.MessageBundle{}
+	for _, filename := range *messagedb_merge_inputs {
+		bundle, err := evtx.LoadMessageBundle(filename)
+		kingpin.FatalIfError(err, "Reading %v", filename)
+		bundles = append(bundles, bundle)
+	}
+
+	merged, conflicts := evtx.MergeMessageBundles(bundles...)
+	for _, conflict := range conflicts {
+		serialized, _ := json.MarshalIndent(conflict, " ", " ")
+		fmt.Println(string(serialized))
+	}
+
+	err := writeMessageSource(*messagedb_merge_output, merged)
+	kingpin.FatalIfError(err, "Writing %v", *messagedb_merge_output)
+
+	fmt.Fprintf(os.Stderr, "Merged %v sources into %v providers with %v conflicts\n",
+		len(bundles), len(merged.Providers), len(conflicts))
+}
+
+func doMessageDBDiff() {
+	old, err := evtx.LoadMessageBundle(*messagedb_diff_old)
+	kingpin.FatalIfError(err, "Reading %v", *messagedb_diff_old)
+
+	newer, err := evtx.LoadMessageBundle(*messagedb_diff_new)
+	kingpin.FatalIfError(err, "Reading %v", *messagedb_diff_new)
+
+	for _, diff := range evtx.DiffMessageBundles(old, newer) {
+		serialized, _ := json.MarshalIndent(diff, " ", " ")
+		fmt.Println(string(serialized))
+	}
+}
+
+func doMessageDBExport() {
+	bundle, err := evtx.LoadMessageBundle(*messagedb_export_source)
+	kingpin.FatalIfError(err, "Reading %v", *messagedb_export_source)
+
+	if *messagedb_export_output == "" {
+		err = evtx.WriteMessageBundleJSON(os.Stdout, bundle)
+	} else {
+		err = writeMessageFile(*messagedb_export_output, bundle,
+			evtx.WriteMessageBundleJSON)
+	}
+	kingpin.FatalIfError(err, "Writing")
+}
+
+func doMessageDBImport() {
+	fd, err := os.Open(*messagedb_import_source)
+	kingpin.FatalIfError(err, "Reading %v", *messagedb_import_source)
+	defer fd.Close()
+
+	bundle, err := evtx.ReadMessageBundleJSON(fd)
+	kingpin.FatalIfError(err, "Reading %v", *messagedb_import_source)
+
+	_, err = os.Stat(*messagedb_import_output)
+	if err == nil {
+		kingpin.Fatalf("%v already exists, use messagedb merge to combine databases",
+			*messagedb_import_output)
+	}
+
+	err = evtx.MessageBundleToDB(bundle, *messagedb_import_output)
+	kingpin.FatalIfError(err, "Writing %v", *messagedb_import_output)
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case messagedb_list.FullCommand():
+			doMessageDBList()
+
+		case messagedb_search.FullCommand():
+			doMessageDBSearch()
+
+		case messagedb_merge.FullCommand():
+			doMessageDBMerge()
+
+		case messagedb_diff.FullCommand():
+			doMessageDBDiff()
+
+		case messagedb_export.FullCommand():
+			doMessageDBExport()
+
+		case messagedb_import.FullCommand():
+			doMessageDBImport()
+
+		default:
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"www.velocidex.com/golang/evtx"
+)
+
+var (
+	migrate      = app.Command("migrate", "Upgrade a message database to the current schema.")
+	migrate_file = migrate.Arg("file", "message database").Required().
+			ExistingFile()
+)
+
+func doMigrate() {
+	err := evtx.CheckSQLiteSupport(*migrate_file)
+	kingpin.FatalIfError(err, "Migrating %v", *migrate_file)
+
+	// The only command which upgrades existing databases.
+	database, err := sql.Open("sqlite3", *migrate_file)
+	kingpin.FatalIfError(err, "Migrating %v", *migrate_file)
+	defer database.Close()
+
+	err = evtx.MigrateMessageDB(database)
+	kingpin.FatalIfError(err, "Migrating %v", *migrate_file)
+
+	version, err := evtx.GetMessageDBVersion(database)
+	kingpin.FatalIfError(err, "Migrating %v", *migrate_file)
+
+	fmt.Printf("%v is at schema version %v\n", *migrate_file, version)
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case migrate.FullCommand():
+			doMigrate()
+
+		default:
+			return false
+		}
+		return true
+	})
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"sync"
 
 	"github.com/Velocidex/ordereddict"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -11,91 +15,540 @@ import (
 )
 
 var (
-	parse      = app.Command("parse", "Parse the events in the file.")
-	parse_file = parse.Arg("file", "File to parse").Required().
-			OpenFile(os.O_RDONLY, os.FileMode(0666))
+	parse       = app.Command("parse", "Parse the events in the file.")
+	parse_files = parse.Arg("files", "Files, directories or globs to parse (- for stdin, @file to read a list "+
+		"of files). Compressed files are decompressed. Use archive.zip!glob to parse members of a ZIP or "+
+		"TAR archive.").Required().Strings()
+
+	parse_glob = parse.Flag("glob", "Which files to parse in directories.").
+			Default("*.evtx").String()
+
+	parse_workers = parse.Flag("workers", "How many files to parse at the same time.").
+			Default(fmt.Sprintf("%v", runtime.NumCPU())).Int()
 
 	parse_output_file = parse.Flag("output", "File to write json in").
 				OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
 
-	parse_file_message_file = parse.Flag("messagedb", "Path to messages database.").
-				String()
-
-	parse_file_disable_message = parse.Flag("disable_messages", "Disable message resolver.").
-					Bool()
+	parse_enrich = addEnrichFlags(parse)
 
 	start_record_id   = parse.Flag("start", "First EventID to dump").Int()
 	number_of_records = parse.Flag("number", "How many records to print").
 				Default("99999999").Int()
 
 	event_id_filter = parse.Flag("event_id", "Only show these event IDs").Int()
+
+	parse_mmap = parse.Flag("mmap", "Memory map the file (falls back to regular reads if unavailable).").
+			Bool()
+
+	parse_ntfs = parse.Flag("ntfs", "The file is a raw (or split .001) image of an NTFS volume.").
+			Bool()
+
+	parse_ntfs_offset = parse.Flag("ntfs_offset", "Offset of the NTFS volume in the image.").
+				Int64()
+
+	parse_ntfs_directory = parse.Flag("ntfs_directory", "Directory in the image to parse logs from.").
+				Default(evtx.EVTX_LOG_DIRECTORY).String()
+
+	parse_ntfs_glob = parse.Flag("ntfs_glob", "Which files to parse in the directory.").
+			Default("*.evtx").String()
+
+	parse_ntfs_carve = parse.Flag("carve", "Also carve chunks from unallocated clusters of the NTFS image.").
+				Bool()
 )
 
 type parsingContext struct {
 	resolver evtx.MessageResolver
+
+	// Accounts learned from all the sources, if SIDs are resolved.
+	sids *evtx.SIDResolver
+
+	// Protects the output and the fields below.
+	mu sync.Mutex
+
+	count int
+
+	// Set when enough records were printed.
+	done bool
+
+	// Number of sources which failed to parse.
+	errors int
+}
+
+// State for parsing a single source. Many sources may be parsed
+// concurrently.
+type sourceContext struct {
+	*parsingContext
+
+	// Name of the source used in error messages.
+	name string
+
+	// If set, events are tagged with where they came from.
+	source *ordereddict.Dict
+
+	// The resolver for this source's events.
+	resolver evtx.MessageResolver
+
+	// Set when the events are only read to learn accounts.
+	learning bool
+}
+
+func (self *parsingContext) isDone() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.done
+}
+
+// Errors are reported but do not stop the other sources from being
+// parsed.
+func (self *parsingContext) reportError(name string, err error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.errors++
+	fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", name, err)
+}
+
+// Errors are only reported once, when the events are printed.
+func (self *sourceContext) reportError(name string, err error) {
+	if !self.learning {
+		self.parsingContext.reportError(name, err)
+	}
 }
 
 func (self *parsingContext) Parse() {
-	chunks, err := evtx.GetChunks(*parse_file)
-	kingpin.FatalIfError(err, "Getting chunks")
+	jobs, err := expandSources(*parse_files)
+	kingpin.FatalIfError(err, "Finding files")
 
-	count := 0
-	for _, chunk := range chunks {
-		records, err := chunk.Parse(*start_record_id)
-		kingpin.FatalIfError(err, "Parsing chunk")
-
-		for _, i := range records {
-			event_map, ok := i.Event.(*ordereddict.Dict)
-			if ok {
-				event, ok := ordereddict.GetMap(event_map, "Event")
-				if !ok {
-					continue
-				}
+	// Accounts are learned from all the sources before any events
+	// are printed, so the names do not depend on the order the
+	// sources are parsed in.
+	if self.sids != nil {
+		for _, job := range jobs {
+			if job.stdin {
+				kingpin.Fatalf("--resolve_sids reads the sources twice " +
+					"so can not be used with stdin")
+			}
+		}
+		self.runJobs(jobs, true)
+	}
 
-				// Filter by event id
-				if *event_id_filter > 0 {
-					event_id, _ := ordereddict.GetInt(event, "System.EventID.Value")
-					if event_id != *event_id_filter {
-						continue
-					}
-				}
+	self.runJobs(jobs, false)
+
+	if self.errors > 0 {
+		fmt.Fprintf(os.Stderr, "%v of %v sources could not be parsed completely\n",
+			self.errors, len(jobs))
+	}
+}
+
+func (self *parsingContext) runJobs(jobs []*parseJob, learning bool) {
+	job_chan := make(chan *parseJob)
+	wg := &sync.WaitGroup{}
+
+	workers := *parse_workers
+	if workers < 1 {
+		workers = 1
+	}
 
-				if self.resolver != nil {
-					event.Set("Message", evtx.ExpandMessage(event, self.resolver))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for job := range job_chan {
+				ctx := &sourceContext{
+					parsingContext: self,
+					name:           job.name,
+					source:         job.source,
+					resolver:       self.resolver,
+					learning:       learning,
 				}
 
-				// Quit after printing this many records.
-				count++
-				if count > *number_of_records {
-					return
+				err := job.run(ctx)
+				if err != nil {
+					ctx.reportError(job.name, err)
 				}
-				serialized, _ := json.MarshalIndent(event, " ", " ")
-				if *parse_output_file == nil {
-					fmt.Println(string(serialized))
-				} else {
-					(*parse_output_file).Write(serialized)
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		if self.isDone() {
+			break
+		}
+		job_chan <- job
+	}
+	close(job_chan)
+	wg.Wait()
+}
+
+func (self *sourceContext) ParseFile(filename string) error {
+	err := self.addLocaleMetaData(filename)
+	if err != nil {
+		self.reportError(filename, err)
+	}
+
+	fd, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if *parse_mmap {
+		mmap_reader, err := evtx.NewMmapReader(fd)
+		if err == nil {
+			defer mmap_reader.Close()
+			return self.ParseReader(mmap_reader)
+		}
+	}
+
+	return self.ParseReader(fd)
+}
+
+// Logs saved from Event Viewer with display information have their
+// providers' metadata in a LocaleMetaData folder. It was rendered on
+// the machine which wrote the log, so it is preferred over the other
+// message sources.
+func (self *sourceContext) addLocaleMetaData(filename string) error {
+	if *parse_enrich.disable_messages {
+		return nil
+	}
+
+	mta_files, err := evtx.FindLocaleMetaData(filename)
+	if err != nil || len(mta_files) == 0 {
+		return err
+	}
+
+	resolver, err := evtx.NewLocaleMetaDataResolver(mta_files...)
+	if err != nil {
+		return err
+	}
+	resolver.SetLanguage(*parse_enrich.language)
+
+	self.resolver = evtx.NewChainResolver(resolver, self.parsingContext.resolver)
+	return nil
+}
+
+// Parse all the matching members of the archive, tagging each event
+// with the member it came from.
+func (self *sourceContext) ParseArchive(archive, glob string) error {
+	return evtx.WalkArchive(archive, glob,
+		func(member *evtx.ArchiveMember, reader io.Reader) error {
+			if self.isDone() {
+				return nil
+			}
+
+			self.source = ordereddict.NewDict().
+				Set("Path", member.Path()).
+				Set("Archive", member.Archive).
+				Set("Member", member.Member)
+
+			err := self.ParseReader(reader)
+			if err != nil {
+				self.reportError(member.Path(), err)
+			}
+			return nil
+		})
+}
+
+// Parse the event logs found in the NTFS image and optionally carve
+// the unallocated space for more chunks.
+func (self *sourceContext) ParseNTFSImage(filename string) error {
+	image, err := evtx.OpenNTFSImage(filename, *parse_ntfs_offset)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	files, err := image.ListFiles(*parse_ntfs_directory, *parse_ntfs_glob)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if self.isDone() {
+			return nil
+		}
+
+		self.source = ordereddict.NewDict().
+			Set("Path", filename+evtx.ARCHIVE_SEPARATOR+file.Path).
+			Set("Image", filename).
+			Set("Member", file.Path)
+
+		err = self.ParseReader(file.Open())
+		if err != nil {
+			self.reportError(filename+evtx.ARCHIVE_SEPARATOR+file.Path, err)
+		}
+	}
+
+	if !*parse_ntfs_carve {
+		return nil
+	}
+
+	return image.CarveUnallocated(func(chunk *evtx.Chunk) error {
+		if self.isDone() {
+			return nil
+		}
+
+		self.source = ordereddict.NewDict().
+			Set("Path", filename).
+			Set("Image", filename).
+			Set("Carved", true).
+			Set("Offset", chunk.Offset)
+
+		return self.ParseChunk(chunk)
+	})
+}
+
+// Parse the reader using random access if it is seekable, otherwise
+// as a stream.
+func (self *sourceContext) ParseReader(reader io.Reader) error {
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		return self.ParseStream(reader)
+	}
+
+	// Compressed files can only be read as a stream.
+	magic := make([]byte, len(evtx.EVTX_HEADER_MAGIC))
+	_, err := io.ReadFull(seeker, magic)
+	if err != nil {
+		return err
+	}
+
+	_, err = seeker.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	if string(magic) != evtx.EVTX_HEADER_MAGIC {
+		return self.ParseStream(seeker)
+	}
+
+	chunks, err := evtx.GetChunks(seeker)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if self.isDone() {
+			break
+		}
+
+		// A corrupt chunk does not stop us from parsing the
+		// rest of the file.
+		err = self.ParseChunk(chunk)
+		if err != nil {
+			self.reportError(self.name, fmt.Errorf(
+				"Chunk at offset %#x: %w", chunk.Offset, err))
+		}
+	}
+	return nil
+}
+
+// Parse chunks sequentially from a non-seekable reader, decompressing
+// it if needed.
+func (self *sourceContext) ParseStream(fd io.Reader) error {
+	reader, err := evtx.NewDecompressingReader(fd)
+	if err != nil {
+		return err
+	}
+
+	stream, err := evtx.NewChunkStream(reader)
+	if err != nil {
+		return err
+	}
+
+	for !self.isDone() {
+		chunk, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = self.ParseChunk(chunk)
+		if err != nil {
+			self.reportError(self.name, fmt.Errorf(
+				"Chunk at offset %#x: %w", chunk.Offset, err))
+		}
+	}
+	return nil
+}
+
+// Print all the records in the chunk.
+func (self *sourceContext) ParseChunk(chunk *evtx.Chunk) error {
+	if self.learning {
+		return self.learnChunk(chunk)
+	}
+
+	// Some records may still be returned with an error.
+	records, err := chunk.Parse(*start_record_id)
+
+	for _, i := range records {
+		event_map, ok := i.Event.(*ordereddict.Dict)
+		if ok {
+			event, ok := ordereddict.GetMap(event_map, "Event")
+			if !ok {
+				continue
+			}
+
+			// Filter by event id
+			if *event_id_filter > 0 {
+				event_id, _ := ordereddict.GetInt(event, "System.EventID.Value")
+				if event_id != *event_id_filter {
+					continue
 				}
 			}
+
+			parse_enrich.enrichEvent(event, i.MessageArgs, self.resolver, self.sids)
+
+			if self.source != nil {
+				event.Set("Source", self.source)
+			}
+
+			serialized, _ := json.MarshalIndent(event, " ", " ")
+			if !self.write(serialized) {
+				return nil
+			}
 		}
 	}
+	return err
 }
 
-func NewParsingContext() *parsingContext {
-	if *parse_file_disable_message {
-		return &parsingContext{evtx.NullResolver{}}
+// Learn the accounts named in all the records of the chunk.
+func (self *sourceContext) learnChunk(chunk *evtx.Chunk) error {
+	records, err := chunk.Parse(0)
+	for _, record := range records {
+		event_map, ok := record.Event.(*ordereddict.Dict)
+		if !ok {
+			continue
+		}
+
+		event, ok := ordereddict.GetMap(event_map, "Event")
+		if !ok {
+			continue
+		}
+
+		if self.resolver != nil {
+			evtx.NameEventData(event, self.resolver)
+		}
+		self.sids.Learn(event)
 	}
+	return err
+}
 
-	if *parse_file_message_file != "" {
-		resolver, err := evtx.NewDBResolver(*parse_file_message_file)
-		kingpin.FatalIfError(err, " %v", err)
-		return &parsingContext{resolver}
+// Write the serialized event. Returns false when enough events were
+// written.
+func (self *parsingContext) write(serialized []byte) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	// Quit after printing this many records.
+	if self.done {
+		return false
 	}
 
-	// Otherwise use the native resolver
-	resolver, err := evtx.GetNativeResolver()
+	self.count++
+	if self.count > *number_of_records {
+		self.done = true
+		return false
+	}
+
+	if *parse_output_file == nil {
+		fmt.Println(string(serialized))
+	} else {
+		(*parse_output_file).Write(serialized)
+	}
+	return true
+}
+
+func NewParsingContext() *parsingContext {
+	resolver, err := parse_enrich.newResolver()
 	kingpin.FatalIfError(err, " %v", err)
 
-	return &parsingContext{resolver}
+	result := &parsingContext{resolver: resolver}
+	if *parse_enrich.resolve_sids {
+		result.sids = evtx.NewSIDResolver()
+	}
+	return result
+}
+
+// Build the message resolver from the command line flags. The
+// message sources are tried in order, then the native resolver and
+// finally the messages built into the library.
+func newResolver(message_files []string, disable bool,
+	language string) (evtx.MessageResolver, error) {
+	if disable {
+		return evtx.NullResolver{}, nil
+	}
+
+	if language != "" {
+		evtx.MUILanguages = []string{language, "en-US"}
+	}
+
+	chain := evtx.NewChainResolver()
+	for _, message_file := range message_files {
+		resolver, err := openMessageSource(message_file, language)
+		if err != nil {
+			chain.Close()
+			return nil, fmt.Errorf("%v: %w", message_file, err)
+		}
+		chain.Add(resolver)
+	}
+
+	native, err := evtx.GetNativeResolver()
+	if err != nil {
+		chain.Close()
+		return nil, err
+	}
+	chain.Add(native)
+
+	default_resolver := evtx.GetDefaultResolver()
+	if default_resolver != nil {
+		chain.Add(default_resolver)
+	}
+
+	// Database lookups are slow so remember them.
+	return evtx.NewCachingResolver(chain, 10000)
+}
+
+// Message databases, bundles, MTA files and YAML or JSON message maps
+// are detected by their contents or extension.
+func openMessageSource(filename, language string) (evtx.MessageResolver, error) {
+	format, err := evtx.DetectMessageSourceFormat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case evtx.MESSAGE_SOURCE_MAP:
+		return evtx.LoadMapResolver(filename)
+
+	case evtx.MESSAGE_SOURCE_BUNDLE, evtx.MESSAGE_SOURCE_BUNDLE_JSON:
+		resolver, err := evtx.LoadBundleResolver(filename)
+		if err != nil {
+			return nil, err
+		}
+		resolver.SetLanguage(language)
+		return resolver, nil
+
+	case evtx.MESSAGE_SOURCE_MTA:
+		resolver, err := evtx.NewLocaleMetaDataResolver(filename)
+		if err != nil {
+			return nil, err
+		}
+		resolver.SetLanguage(language)
+		return resolver, nil
+
+	}
+
+	resolver, err := evtx.NewDBResolver(filename)
+	if err != nil {
+		return nil, err
+	}
+	resolver.SetLanguage(language)
+	return resolver, nil
 }
 
 func doParse() {
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/evtx"
+)
+
+// A single source of events to parse.
+type parseJob struct {
+	name   string
+	source *ordereddict.Dict
+	run    func(ctx *sourceContext) error
+
+	// Stdin can only be read once.
+	stdin bool
+}
+
+// Expand the command line arguments into a list of sources. Directories
+// are searched recursively for files matching --glob and globs are
+// expanded. When more than one file is parsed, events are tagged with
+// their source.
+func expandSources(args []string) ([]*parseJob, error) {
+	result := []*parseJob{}
+	files := []string{}
+	seen := make(map[string]bool)
+	tag_files := len(args) > 1
+
+	add_file := func(filename string) {
+		if !seen[filename] {
+			seen[filename] = true
+			files = append(files, filename)
+		}
+	}
+
+	for _, arg := range args {
+		arg := arg
+
+		if arg == STDIN_PATH {
+			result = append(result, &parseJob{
+				name:  "stdin",
+				stdin: true,
+				run: func(ctx *sourceContext) error {
+					return ctx.ParseStream(os.Stdin)
+				},
+			})
+			continue
+		}
+
+		if *parse_ntfs {
+			result = append(result, &parseJob{
+				name: arg,
+				run: func(ctx *sourceContext) error {
+					return ctx.ParseNTFSImage(arg)
+				},
+			})
+			continue
+		}
+
+		archive, glob, ok := evtx.SplitArchivePath(arg)
+		if ok {
+			result = append(result, &parseJob{
+				name: arg,
+				run: func(ctx *sourceContext) error {
+					return ctx.ParseArchive(archive, glob)
+				},
+			})
+			continue
+		}
+
+		found, expanded, err := expandPath(arg, *parse_glob)
+		if err != nil {
+			return nil, err
+		}
+		for _, filename := range found {
+			add_file(filename)
+		}
+		if expanded {
+			tag_files = true
+		}
+	}
+
+	for _, filename := range files {
+		filename := filename
+		job := &parseJob{
+			name: filename,
+			run: func(ctx *sourceContext) error {
+				return ctx.ParseFile(filename)
+			},
+		}
+
+		if tag_files {
+			job.source = ordereddict.NewDict().Set("Path", filename)
+		}
+		result = append(result, job)
+	}
+
+	return result, nil
+}
+
+// Expand a directory (searched recursively for files matching the
+// glob) or a glob into the files it contains. Other paths are
+// returned as is, so missing files are reported when we try to open
+// them.
+func expandPath(arg string, glob string) ([]string, bool, error) {
+	stat, err := os.Stat(arg)
+	if err == nil && stat.IsDir() {
+		found, err := evtx.FindFiles(arg, "**/"+glob)
+		return found, true, err
+	}
+
+	if err != nil && strings.ContainsAny(arg, "*?[") {
+		found, err := evtx.ExpandGlob(arg)
+		return found, true, err
+	}
+
+	return []string{arg}, false, nil
+}
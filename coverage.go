@@ -0,0 +1,224 @@
+package evtx
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+// MessageCoverage tells how the message of a single event was
+// resolved.
+type MessageCoverage struct {
+	Provider string
+	EventId  int
+
+	Resolved bool
+
+	// The resolver which found the message, or why none did.
+	Source string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+
+	// The highest insert (%N) the message uses and the number of
+	// inserts the event has. The message can not be fully expanded
+	// if it uses more inserts than the event has.
+	MessageInserts int
+	EventInserts   int
+
+	// %%NNNN references in the event's data which have no parameter
+	// string.
+	UnresolvedParameters []int `json:",omitempty"`
+}
+
+func (self *MessageCoverage) InsertMismatch() bool {
+	return self.Resolved && self.MessageInserts > self.EventInserts
+}
+
+// CheckMessageCoverage resolves the message of the event like
+// ExpandMessageWithContext() and reports how well it went.
+func CheckMessageCoverage(ctx context.Context, event *ordereddict.Dict,
+	args []interface{}, resolver MessageResolver,
+	language string) *MessageCoverage {
+	provider, _ := ordereddict.GetString(event, "System.Provider.Name")
+	provider_guid, _ := ordereddict.GetString(event, "System.Provider.Guid")
+	channel, _ := ordereddict.GetString(event, "System.Channel")
+	event_id, _ := ordereddict.GetInt(event, "System.EventID.Value")
+
+	result := &MessageCoverage{
+		Provider: provider,
+		EventId:  event_id,
+	}
+	if provider == "" {
+		result.Provider = provider_guid
+	}
+
+	data := flatten(getMessageData(event))
+	result.EventInserts = len(args)
+	if args == nil {
+		result.EventInserts = len(data)
+	}
+
+	message, _, err := lookupEventMessage(ctx, event, resolver, language,
+		result.EventInserts, false)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Resolved = true
+		result.Source = message.Source
+
+		// Rendered messages are already expanded.
+		if message.Source != RENDERING_INFO_SOURCE {
+			result.MessageInserts = MessageInsertCount(message.Message)
+		}
+	}
+
+	seen := make(map[int]bool)
+	for _, item := range data {
+		value, ok := item.(string)
+		if !ok {
+			continue
+		}
+
+		for _, match := range parameter_reference_re.FindAllStringSubmatch(value, -1) {
+			param_id, err := strconv.Atoi(match[1])
+			if err != nil || seen[param_id] {
+				continue
+			}
+			seen[param_id] = true
+
+			if lookupEventParameter(resolver, provider_guid, provider,
+				channel, param_id) == "" {
+				result.UnresolvedParameters = append(
+					result.UnresolvedParameters, param_id)
+			}
+		}
+	}
+	sort.Ints(result.UnresolvedParameters)
+
+	return result
+}
+
+// MessageInsertCount returns the highest insert (%1 .. %99) the
+// message template uses.
+func MessageInsertCount(template string) int {
+	result := 0
+	for i := 0; i+1 < len(template); i++ {
+		if template[i] != '%' {
+			continue
+		}
+
+		// Skip the escaped character, e.g. %% or %n.
+		i++
+		if template[i] < '1' || template[i] > '9' {
+			continue
+		}
+
+		end := i + 1
+		if end < len(template) && isDigit(template[end]) {
+			end++
+		}
+		number, _ := strconv.Atoi(template[i:end])
+		if number > result {
+			result = number
+		}
+		i = end - 1
+	}
+	return result
+}
+
+// CoverageEntry summarizes the coverage of the events with the same
+// provider and event id.
+type CoverageEntry struct {
+	Provider string
+	EventId  int
+
+	Events   int
+	Resolved int
+
+	// How many messages each resolver found.
+	Sources map[string]int `json:",omitempty"`
+
+	// The last reason a message was not found.
+	Error string `json:",omitempty"`
+
+	MessageInserts   int
+	EventInserts     int
+	InsertMismatches int
+
+	UnresolvedParameters []int `json:",omitempty"`
+}
+
+type coverageKey struct {
+	provider string
+	event_id int
+}
+
+// CoverageReport collects the coverage of many events.
+type CoverageReport struct {
+	entries map[coverageKey]*CoverageEntry
+}
+
+func NewCoverageReport() *CoverageReport {
+	return &CoverageReport{
+		entries: make(map[coverageKey]*CoverageEntry),
+	}
+}
+
+func (self *CoverageReport) Add(coverage *MessageCoverage) {
+	key := coverageKey{coverage.Provider, coverage.EventId}
+	entry, pres := self.entries[key]
+	if !pres {
+		entry = &CoverageEntry{
+			Provider: coverage.Provider,
+			EventId:  coverage.EventId,
+			Sources:  make(map[string]int),
+		}
+		self.entries[key] = entry
+	}
+
+	entry.Events++
+	if coverage.Resolved {
+		entry.Resolved++
+		entry.Sources[coverage.Source]++
+	} else {
+		entry.Error = coverage.Error
+	}
+
+	if coverage.MessageInserts > entry.MessageInserts {
+		entry.MessageInserts = coverage.MessageInserts
+	}
+	if coverage.EventInserts > entry.EventInserts {
+		entry.EventInserts = coverage.EventInserts
+	}
+	if coverage.InsertMismatch() {
+		entry.InsertMismatches++
+	}
+
+	for _, param_id := range coverage.UnresolvedParameters {
+		idx := sort.SearchInts(entry.UnresolvedParameters, param_id)
+		if idx < len(entry.UnresolvedParameters) &&
+			entry.UnresolvedParameters[idx] == param_id {
+			continue
+		}
+		entry.UnresolvedParameters = append(entry.UnresolvedParameters, 0)
+		copy(entry.UnresolvedParameters[idx+1:], entry.UnresolvedParameters[idx:])
+		entry.UnresolvedParameters[idx] = param_id
+	}
+}
+
+// Entries returns the entries sorted by provider and event id.
+func (self *CoverageReport) Entries() []*CoverageEntry {
+	result := make([]*CoverageEntry, 0, len(self.entries))
+	for _, entry := range self.entries {
+		result = append(result, entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Provider != result[j].Provider {
+			return result[i].Provider < result[j].Provider
+		}
+		return result[i].EventId < result[j].EventId
+	})
+	return result
+}
@@ -0,0 +1,66 @@
+package evtx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageInsertCount(t *testing.T) {
+	for template, expected := range map[string]int{
+		"No inserts.":                   0,
+		"The %1 service entered %2.":    2,
+		"%12!08x! and %3":               12,
+		"100%% done, %n%t%1":            1,
+		"Escaped %%1 is not an insert.": 0,
+		"Trailing %":                    0,
+	} {
+		assert.Equal(t, expected, MessageInsertCount(template), template)
+	}
+}
+
+func TestMessageCoverage(t *testing.T) {
+	ctx := context.Background()
+	set := &MessageSet{
+		Messages:   make(map[int]string),
+		Parameters: map[int]string{1833: "Impersonation"},
+	}
+	set.AddMessage(7036, "The %1 service entered the %2 state.")
+	resolver := &messageSetResolver{set: set}
+
+	report := NewCoverageReport()
+
+	coverage := CheckMessageCoverage(ctx, testMessageEvent(
+		"Service Control Manager", 7036), nil, resolver, "")
+	assert.True(t, coverage.Resolved)
+	assert.Equal(t, UNNAMED_MESSAGE_SOURCE, coverage.Source)
+	assert.Equal(t, 2, coverage.MessageInserts)
+	assert.Equal(t, 1, coverage.EventInserts)
+	assert.True(t, coverage.InsertMismatch())
+	report.Add(coverage)
+
+	// Parameters which are not known are reported.
+	event := testMessageEvent("Service Control Manager", 1)
+	event.Set("EventData", ordereddict.NewDict().
+		Set("Known", "%%1833").
+		Set("Unknown", "%%1842"))
+	coverage = CheckMessageCoverage(ctx, event, nil, resolver, "")
+	assert.False(t, coverage.Resolved)
+	assert.NotEqual(t, "", coverage.Error)
+	assert.Equal(t, []int{1842}, coverage.UnresolvedParameters)
+	report.Add(coverage)
+	report.Add(coverage)
+
+	entries := report.Entries()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, 1, entries[0].EventId)
+	assert.Equal(t, 2, entries[0].Events)
+	assert.Equal(t, 0, entries[0].Resolved)
+	assert.Equal(t, []int{1842}, entries[0].UnresolvedParameters)
+
+	assert.Equal(t, 7036, entries[1].EventId)
+	assert.Equal(t, map[string]int{UNNAMED_MESSAGE_SOURCE: 1}, entries[1].Sources)
+	assert.Equal(t, 1, entries[1].InsertMismatches)
+}
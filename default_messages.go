@@ -0,0 +1,16 @@
+//go:build !no_default_messages
+// +build !no_default_messages
+
+package evtx
+
+import (
+	_ "embed"
+)
+
+//go:generate go run -tags no_default_messages ./cmd bundle defaults/messages.yaml defaults/messages.bundle
+
+// The messages of common providers. Build with the
+// no_default_messages tag to leave them out.
+//
+//go:embed defaults/messages.bundle
+var default_message_bundle []byte
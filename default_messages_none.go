@@ -0,0 +1,6 @@
+//go:build no_default_messages
+// +build no_default_messages
+
+package evtx
+
+var default_message_bundle []byte
@@ -0,0 +1,109 @@
+package evtx
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	errors "github.com/pkg/errors"
+)
+
+// GetDefaultResolver returns the resolver of the message bundle
+// built into the library (see defaults/messages.yaml), or nil when
+// built with the no_default_messages tag.
+func GetDefaultResolver() MessageResolver {
+	if len(default_message_bundle) == 0 {
+		return nil
+	}
+	return NewLazyBundleResolver(default_message_bundle, "default")
+}
+
+// LazyBundleResolver decompresses a message bundle on the first
+// lookup, so a bundle which is not needed costs nothing.
+type LazyBundleResolver struct {
+	once sync.Once
+	data []byte
+	name string
+
+	resolver *BundleResolver
+	err      error
+}
+
+func NewLazyBundleResolver(data []byte, name string) *LazyBundleResolver {
+	return &LazyBundleResolver{data: data, name: name}
+}
+
+func (self *LazyBundleResolver) load() (*BundleResolver, error) {
+	self.once.Do(func() {
+		bundle, err := ReadMessageBundle(bytes.NewReader(self.data))
+		if err != nil {
+			self.err = errors.Wrap(err, self.name)
+			return
+		}
+
+		self.resolver = NewBundleResolver(bundle)
+		self.resolver.source = "bundle:" + self.name
+	})
+	return self.resolver, self.err
+}
+
+func (self *LazyBundleResolver) GetMessage(provider, channel string,
+	event_id, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id, -1,
+		number_of_expansions)
+}
+
+func (self *LazyBundleResolver) GetVersionedMessage(provider, channel string,
+	event_id, version, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id,
+		version, number_of_expansions)
+}
+
+func (self *LazyBundleResolver) GetQualifiedMessage(provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	return getMessageV2(self, provider, channel, qualifiers, event_id,
+		version, number_of_expansions)
+}
+
+func (self *LazyBundleResolver) GetParameter(provider, channel string,
+	parameter_id int) string {
+	return getParameterV2(self, provider, channel, parameter_id)
+}
+
+func (self *LazyBundleResolver) LookupMessage(
+	ctx context.Context, request *MessageRequest) (*MessageResult, error) {
+	resolver, err := self.load()
+	if err != nil {
+		return nil, err
+	}
+	return resolver.LookupMessage(ctx, request)
+}
+
+func (self *LazyBundleResolver) LookupParameter(
+	ctx context.Context, request *ParameterRequest) (*MessageResult, error) {
+	resolver, err := self.load()
+	if err != nil {
+		return nil, err
+	}
+	return resolver.LookupParameter(ctx, request)
+}
+
+func (self *LazyBundleResolver) GetEventFields(provider, channel string,
+	event_id, version int) []string {
+	resolver, err := self.load()
+	if err != nil {
+		return nil
+	}
+	return resolver.GetEventFields(provider, channel, event_id, version)
+}
+
+func (self *LazyBundleResolver) GetProviderValueName(provider, channel, kind string,
+	value uint64, task int) string {
+	resolver, err := self.load()
+	if err != nil {
+		return ""
+	}
+	return resolver.GetProviderValueName(provider, channel, kind, value, task)
+}
+
+func (self *LazyBundleResolver) Close() {}
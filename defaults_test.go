@@ -0,0 +1,62 @@
+package evtx
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultMessages(t *testing.T) {
+	resolver := GetDefaultResolver()
+	if resolver == nil {
+		t.Skip("Built without default messages")
+	}
+
+	// The embedded bundle must be regenerated after editing the
+	// messages.
+	messages, err := LoadMapResolver("defaults/messages.yaml")
+	assert.NoError(t, err)
+
+	embedded, err := ReadMessageBundle(bytes.NewReader(default_message_bundle))
+	assert.NoError(t, err)
+	assert.Equal(t, MapResolverToBundle(messages), embedded,
+		"defaults/messages.bundle is out of date, run go generate")
+
+	assert.Equal(t, "The %1 service entered the %2 state.", lookupMessage(resolver,
+		"{555908D1-A6D7-4695-8E1E-26931D2012F4}", "System", 0x4000, 7036, -1, 2))
+	assert.Equal(t, "Impersonation\r\n", resolver.GetParameter(
+		"Microsoft-Windows-Security-Auditing", "Security", 1833))
+
+	result, err := AsMessageResolverV2(resolver).LookupMessage(context.Background(),
+		&MessageRequest{Provider: "Microsoft-Windows-Sysmon", EventId: 1,
+			Qualifiers: -1, Version: -1})
+	assert.NoError(t, err)
+	assert.Equal(t, "bundle:default", result.Source)
+}
+
+func TestLazyBundleResolver(t *testing.T) {
+	bundle := NewMessageBundle()
+	bundle.Providers = append(bundle.Providers, &BundleProvider{
+		Name: "Provider",
+		Messages: []*BundleMessage{{
+			Id: 1, EventId: 1, EventVersion: -1, Message: "Message"}},
+	})
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, WriteMessageBundle(buffer, bundle))
+
+	resolver := NewLazyBundleResolver(buffer.Bytes(), "test")
+	assert.Nil(t, resolver.resolver)
+
+	assert.Equal(t, "Message", resolver.GetMessage("Provider", "", 1, 0))
+	assert.NotNil(t, resolver.resolver)
+
+	// A broken bundle fails the lookups rather than not finding the
+	// message.
+	resolver = NewLazyBundleResolver([]byte("broken"), "test")
+	_, err := resolver.LookupMessage(context.Background(),
+		&MessageRequest{Provider: "Provider", EventId: 1})
+	assert.Error(t, err)
+	assert.False(t, IsNotFound(err))
+}
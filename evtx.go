@@ -83,13 +83,20 @@ type EventRecordHeader struct {
 type EventRecord struct {
 	Header EventRecordHeader
 	Event  interface{}
+
+	// The substitution values of the event's EventData or UserData
+	// in template order. Message inserts %1, %2 ... refer to
+	// these. This is nil if the event has no such substitutions.
+	MessageArgs []interface{}
 }
 
 func (self *EventRecord) Parse(ctx *ParseContext) {
 	template := ctx.NewTemplate(0)
+	ctx.message_args = nil
 	ParseBinXML(ctx, !TemplateContext)
 
 	self.Event = template.Expand(nil)
+	self.MessageArgs = ctx.message_args
 }
 
 func NewEventRecord(ctx *ParseContext, chunk *Chunk) (*EventRecord, error) {
@@ -125,10 +132,23 @@ type Chunk struct {
 	Header ChunkHeader
 	Offset int64
 	Fd     io.ReadSeeker
+
+	// Chunks read from a stream already hold their data.
+	data []byte
 }
 
-func (self *Chunk) Parse(start_record_id int) ([]*EventRecord, error) {
-	result := []*EventRecord{}
+// Read the entire chunk into memory. Readers that can expose their
+// data directly (e.g. memory mapped files) avoid the copy.
+func (self *Chunk) readData() ([]byte, error) {
+	if self.data != nil {
+		return self.data, nil
+	}
+
+	slicer, ok := self.Fd.(chunkSlicer)
+	if ok {
+		return slicer.Slice(self.Offset, EVTX_CHUNK_SIZE)
+	}
+
 	buf := make([]byte, EVTX_CHUNK_SIZE)
 	_, err := self.Fd.Seek(self.Offset, os.SEEK_SET)
 	if err != nil {
@@ -139,6 +159,30 @@ func (self *Chunk) Parse(start_record_id int) ([]*EventRecord, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "ReadAtLeast")
 	}
+	return buf, nil
+}
+
+func (self *Chunk) Parse(start_record_id int) (result []*EventRecord, err error) {
+	// When parsing straight out of a mapped file, the file may be
+	// truncated while we parse it.
+	_, ok := self.Fd.(chunkSlicer)
+	if ok {
+		err = withFaultProtection(func() error {
+			result, err = self.parse(start_record_id)
+			return err
+		})
+		return result, err
+	}
+
+	return self.parse(start_record_id)
+}
+
+func (self *Chunk) parse(start_record_id int) ([]*EventRecord, error) {
+	result := []*EventRecord{}
+	buf, err := self.readData()
+	if err != nil {
+		return nil, err
+	}
 
 	// The entire chunk is captured in this context.
 	ctx := NewParseContext(self)
@@ -187,6 +231,9 @@ type TemplateNode struct {
 	NestedDict  *ordereddict.Dict //map[string]*TemplateNode
 
 	CurrentKey string
+
+	// Set when the node is replaced by the substitution Id.
+	substitution bool
 }
 
 func (self *TemplateNode) Expand(args map[int]interface{}) interface{} {
@@ -256,7 +303,64 @@ func (self *TemplateNode) SetExpansion(key string, id, type_id uint32) {
 		self.NestedDict = ordereddict.NewDict() //make(map[string]*TemplateNode)
 	}
 
-	self.NestedDict.Set(key, &TemplateNode{Id: id, Type: type_id})
+	self.NestedDict.Set(key, &TemplateNode{
+		Id: id, Type: type_id, substitution: true})
+}
+
+// Collect the ids of the substitutions making up the text of the
+// elements under this node, in document order. Substitutions in
+// attributes are not message inserts.
+func (self *TemplateNode) substitutionIds(result []uint32) []uint32 {
+	if self.substitution {
+		return append(result, self.Id)
+	}
+
+	for _, item := range self.NestedArray {
+		result = item.substitutionIds(result)
+	}
+
+	if self.NestedDict != nil {
+		for _, k := range self.NestedDict.Keys() {
+			v, _ := self.NestedDict.Get(k)
+			node := v.(*TemplateNode)
+			if node.substitution && k != "" {
+				continue
+			}
+			result = node.substitutionIds(result)
+		}
+	}
+	return result
+}
+
+// Find the substitutions which make up the message inserts of the
+// event: the content of EventData or UserData. Some providers place
+// EventData in a separate template which is substituted into the
+// Event template.
+func (self *TemplateNode) messageSubstitutionIds() []uint32 {
+	event, pres := self.getNested("Event")
+	if !pres {
+		event = self
+	}
+
+	for _, section := range []string{"EventData", "UserData"} {
+		data, pres := event.getNested(section)
+		if pres {
+			return data.substitutionIds(nil)
+		}
+	}
+	return nil
+}
+
+func (self *TemplateNode) getNested(key string) (*TemplateNode, bool) {
+	if self.NestedDict == nil {
+		return nil, false
+	}
+
+	v, pres := self.NestedDict.Get(key)
+	if !pres {
+		return nil, false
+	}
+	return v.(*TemplateNode), true
 }
 
 func (self *TemplateNode) SetNested(key string, nested *TemplateNode) {
@@ -311,6 +415,9 @@ type ParseContext struct {
 	// chunk. Further events in the chunk will reuse the same
 	// templates by id.
 	knownIDs map[int]*TemplateNode
+
+	// The message inserts of the record being parsed.
+	message_args []interface{}
 }
 
 func (self *ParseContext) CurrentKey() string {
@@ -818,7 +925,10 @@ func ParseTemplateInstance(ctx *ParseContext) bool {
 			}
 			arg_values[idx] = value
 		case 0xe: // binary
-			arg_values[idx] = ctx.ConsumeBytes(arg.argLen)
+			// Take a copy because the chunk buffer may be a
+			// memory mapping which goes away when the file is
+			// closed.
+			arg_values[idx] = append([]byte{}, ctx.ConsumeBytes(arg.argLen)...)
 		case 0x0f: // GUID
 			guid := EvtxGUID{}
 			readStructFromFile(
@@ -870,6 +980,11 @@ func ParseTemplateInstance(ctx *ParseContext) bool {
 			ParseBinXML(new_ctx, !TemplateContext)
 			ctx.SkipBytes(arg.argLen)
 
+			// The fragment may hold the EventData template.
+			if new_ctx.message_args != nil {
+				ctx.message_args = new_ctx.message_args
+			}
+
 			arg_values[idx] = new_ctx.CurrentTemplate().Expand(nil)
 
 		case 0x27, 0x28:
@@ -902,6 +1017,15 @@ func ParseTemplateInstance(ctx *ParseContext) bool {
 	debug("ParseTemplateInstance Exit %x\n", ctx.offset)
 	expanded := template.Expand(arg_values)
 
+	ids := template.messageSubstitutionIds()
+	if ids != nil {
+		arg_types := make(map[int]uint16)
+		for idx, arg := range args {
+			arg_types[idx] = arg.argType
+		}
+		ctx.message_args = messageArgs(ids, arg_values, arg_types)
+	}
+
 	NormalizeEventData(expanded)
 
 	ctx.CurrentTemplate().SetLiteral(ctx.CurrentKey(), expanded)
@@ -909,6 +1033,34 @@ func ParseTemplateInstance(ctx *ParseContext) bool {
 	return true
 }
 
+// Build the message inserts from the substitution values. Inserts
+// which hold an XML fragment contribute each of their values. Hex
+// integers are rendered in hex like Windows does.
+func messageArgs(ids []uint32, arg_values map[int]interface{},
+	arg_types map[int]uint16) []interface{} {
+	result := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		value, pres := arg_values[int(id)]
+		if !pres {
+			// Null substitutions are empty.
+			value = ""
+		}
+
+		switch arg_types[int(id)] {
+		case 0x14, 0x15:
+			value = fmt.Sprintf("0x%X", value)
+		}
+
+		dict, ok := value.(*ordereddict.Dict)
+		if ok {
+			result = append(result, flatten(dict)...)
+			continue
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
 func ParseOptionalSubstitution(ctx *ParseContext) bool {
 	debug("ParseOptionalSubstitution Enter %x\n", ctx.Offset())
 	substitutionID := ctx.ConsumeUint16()
@@ -1056,8 +1208,6 @@ func ParseFile(fd io.ReadSeeker) (*ordereddict.Dict, error) {
 		}
 		offset += EVTX_CHUNK_SIZE
 	}
-
-	return nil, nil
 }
 
 func readStructFromFile(fd io.ReadSeeker, offset int64, obj interface{}) error {
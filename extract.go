@@ -0,0 +1,211 @@
+package evtx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	errors "github.com/pkg/errors"
+	"www.velocidex.com/golang/binparsergen/reader"
+	pe "www.velocidex.com/golang/go-pe"
+)
+
+// ProviderRegistration is a provider registered in the registry,
+// either as a legacy EventLog source of a channel or as a manifest
+// based publisher. The message file paths are as they appear in the
+// registry.
+type ProviderRegistration struct {
+	Name    string
+	Guid    string
+	Channel string
+
+	MessageFiles   string
+	ParameterFiles string
+}
+
+// ReadMessageTable reads the message table resource of a PE file.
+func ReadMessageTable(filename string) ([]*pe.Message, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	reader, err := reader.NewPagedReader(fd, 4096, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	pe_file, err := pe.NewPEFile(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := pe_file.GetMessages()
+	if len(messages) > 10000 {
+		return nil, errors.New("Too many messages in dll")
+	}
+	return messages, nil
+}
+
+// MessageFileLanguage returns the language of the messages in a MUI
+// file, which is the name of its directory. Other files have no
+// particular language.
+func MessageFileLanguage(filename string) string {
+	if !strings.HasSuffix(strings.ToLower(filename), ".mui") {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(filename))
+}
+
+// ExtractProvider adds the provider and the messages and parameters
+// from its message files to the database. The expand callback maps a
+// registry message file value to the files to read.
+func ExtractProvider(writer *MessageDBWriter, provider *ProviderRegistration,
+	expand func(message_file string) []string,
+	logger func(format string, args ...interface{})) error {
+	provider_id, err := writer.AddProvider(
+		provider.Name, provider.Guid, provider.Channel)
+	if err != nil {
+		return err
+	}
+
+	// Messages by language and id, for the events of the manifest.
+	message_tables := make(map[string]map[int64]string)
+	manifests := []*WEVTManifest{}
+
+	for _, message_file := range expand(provider.MessageFiles) {
+		language := MessageFileLanguage(message_file)
+
+		// The manifest is in the DLL itself, not in MUI files.
+		if language == "" {
+			manifest, err := ReadWEVTTemplate(message_file)
+			if err != nil {
+				logger("Err: %v reading manifest of %v\n", err, message_file)
+			} else if manifest != nil {
+				manifests = append(manifests, manifest)
+			}
+		}
+
+		messages, err := ReadMessageTable(message_file)
+		if err != nil {
+			continue
+		}
+
+		table, pres := message_tables[language]
+		if !pres {
+			table = make(map[int64]string)
+			message_tables[language] = table
+		}
+
+		for _, msg := range messages {
+			message := strings.TrimSpace(msg.Message)
+			table[msg.Id] = message
+
+			err := writer.AddLocalizedMessage(provider_id, msg.Id, msg.EventId,
+				-1, language, message)
+			if err != nil {
+				logger("Err: %v %v %v\n", err, msg.EventId, provider_id)
+			}
+		}
+		if len(messages) > 0 {
+			logger("Got %v messages for provider %v (%v) in %v\n",
+				len(messages), provider.Name, provider_id, message_file)
+		}
+	}
+
+	for _, manifest := range manifests {
+		for _, manifest_provider := range manifest.Providers {
+			// A DLL may contain the manifests of several
+			// providers.
+			if provider.Guid != "" &&
+				NormalizeGUID(provider.Guid) != manifest_provider.Guid {
+				continue
+			}
+
+			err := addManifestProvider(writer, provider_id,
+				manifest_provider, message_tables)
+			if err != nil {
+				logger("Err: %v adding manifest of %v\n", err, provider.Name)
+				continue
+			}
+			logger("Got %v event definitions for provider %v (%v)\n",
+				len(manifest_provider.Events), provider.Name, provider_id)
+		}
+	}
+
+	if provider.ParameterFiles == "" {
+		return nil
+	}
+
+	for _, parameter_file := range expand(provider.ParameterFiles) {
+		messages, err := ReadMessageTable(parameter_file)
+		if err != nil {
+			continue
+		}
+
+		language := MessageFileLanguage(parameter_file)
+		for _, msg := range messages {
+			err := writer.AddLocalizedParameter(provider_id, msg.Id,
+				language, strings.TrimSpace(msg.Message))
+			if err != nil {
+				logger("Err: %v %v %v\n", err, msg.Id, provider_id)
+			}
+		}
+		if len(messages) > 0 {
+			logger("Got %v parameters for provider %v (%v) in %v\n",
+				len(messages), provider.Name, provider_id, parameter_file)
+		}
+	}
+	return nil
+}
+
+// Add the event definitions of the manifest. The messages of the
+// events are added for their particular version.
+func addManifestProvider(writer *MessageDBWriter, provider_id int64,
+	provider *WEVTProvider, message_tables map[string]map[int64]string) error {
+	for _, event := range provider.Events {
+		err := writer.AddEvent(provider_id,
+			provider.ChannelName(event.Channel), event)
+		if err != nil {
+			return err
+		}
+
+		if event.MessageId < 0 {
+			continue
+		}
+
+		for language, table := range message_tables {
+			message, pres := table[event.MessageId]
+			if !pres {
+				continue
+			}
+
+			err := writer.AddLocalizedMessage(provider_id, event.MessageId,
+				event.Id, event.Version, language, message)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, values := range []struct {
+		kind   string
+		values []*WEVTValue
+	}{
+		{"channel", provider.Channels},
+		{"level", provider.Levels},
+		{"task", provider.Tasks},
+		{"opcode", provider.Opcodes},
+		{"keyword", provider.Keywords},
+	} {
+		for _, value := range values.values {
+			err := writer.AddProviderValue(provider_id, values.kind, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
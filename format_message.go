@@ -0,0 +1,273 @@
+package evtx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FormatMessage renders a message template the way the Windows
+// FormatMessage() API does with an argument array:
+//
+//	%1 .. %99        Insert an argument, formatted with !s!.
+//	%1!fmt!          Insert an argument using a printf format spec
+//	                 (e.g. %1!08x!, %2!S!, %3!-10.4s!). A * width or
+//	                 precision is taken from the following arguments.
+//	%0               End the message without a trailing line break.
+//	%n %r %t %b      Line break, carriage return (dropped), tab, space.
+//	%% %. %! %space  A literal %, ., ! or space.
+//
+// Any other character following a % is copied without the %. Line
+// breaks are rendered as \n. Inserts without an argument are left in
+// the output as is.
+func FormatMessage(template string, args []interface{}) string {
+	result := strings.Builder{}
+
+	for i := 0; i < len(template); {
+		c := template[i]
+		if c != '%' || i+1 >= len(template) {
+			result.WriteByte(c)
+			i++
+			continue
+		}
+
+		next := template[i+1]
+		switch {
+		case next == '0':
+			return result.String()
+
+		case next >= '1' && next <= '9':
+			end := i + 2
+			if end < len(template) && isDigit(template[end]) {
+				end++
+			}
+			number, _ := strconv.Atoi(template[i+1 : end])
+
+			// Parse the optional !format! spec.
+			spec := "s"
+			if end < len(template) && template[end] == '!' {
+				closing := strings.IndexByte(template[end+1:], '!')
+				if closing >= 0 {
+					spec = template[end+1 : end+1+closing]
+					end += closing + 2
+				}
+			}
+
+			formatted, ok := formatInsert(spec, number, args)
+			if !ok {
+				formatted = template[i:end]
+			}
+			result.WriteString(formatted)
+			i = end
+			continue
+
+		case next == 'n':
+			result.WriteByte('\n')
+		case next == 'r':
+		case next == 't':
+			result.WriteByte('\t')
+		case next == 'b':
+			result.WriteByte(' ')
+		default:
+			result.WriteByte(next)
+		}
+		i += 2
+	}
+
+	return result.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Format the insert with a printf style spec: flags, width,
+// precision, an optional size prefix and the conversion character.
+func formatInsert(spec string, number int, args []interface{}) (string, bool) {
+	// Inserts start at 1
+	idx := number - 1
+
+	get_arg := func() (interface{}, bool) {
+		if idx < 0 || idx >= len(args) {
+			return nil, false
+		}
+		idx++
+		return args[idx-1], true
+	}
+
+	// Rebuild the spec as a Go format string.
+	format := strings.Builder{}
+	format.WriteByte('%')
+
+	i := 0
+	for i < len(spec) && strings.IndexByte("-+ #0", spec[i]) >= 0 {
+		format.WriteByte(spec[i])
+		i++
+	}
+
+	// Width and precision are either digits or a * which takes
+	// the value from the next argument.
+	for _, prefix := range []string{"", "."} {
+		if prefix != "" {
+			if i >= len(spec) || spec[i] != '.' {
+				break
+			}
+			format.WriteByte('.')
+			i++
+		}
+
+		if i < len(spec) && spec[i] == '*' {
+			value, ok := get_arg()
+			if !ok {
+				return "", false
+			}
+			width, _ := toInt64(value)
+			format.WriteString(strconv.FormatInt(width, 10))
+			i++
+			continue
+		}
+
+		for i < len(spec) && isDigit(spec[i]) {
+			format.WriteByte(spec[i])
+			i++
+		}
+	}
+
+	// Size prefixes: h, l, ll, w, I, I32, I64.
+	bits := 32
+	for _, prefix := range []string{"I64", "I32", "ll", "h", "l", "w", "I"} {
+		if strings.HasPrefix(spec[i:], prefix) {
+			switch prefix {
+			case "I64", "ll", "I":
+				bits = 64
+			case "h":
+				bits = 16
+			}
+			i += len(prefix)
+			break
+		}
+	}
+
+	verb := byte('s')
+	if i < len(spec) {
+		verb = spec[i]
+	}
+
+	value, ok := get_arg()
+	if !ok {
+		return "", false
+	}
+
+	switch verb {
+	case 'd', 'i':
+		number, ok := toInt64(value)
+		if ok {
+			format.WriteByte('d')
+			return fmt.Sprintf(format.String(), number), true
+		}
+
+	case 'u', 'x', 'X', 'o':
+		number, ok := toInt64(value)
+		if ok {
+			if verb == 'u' {
+				verb = 'd'
+			}
+			format.WriteByte(verb)
+			return fmt.Sprintf(format.String(), truncateUnsigned(number, bits)), true
+		}
+
+	case 'p':
+		number, ok := toInt64(value)
+		if ok {
+			return fmt.Sprintf("%016X", uint64(number)), true
+		}
+
+	case 'c', 'C':
+		number, ok := toInt64(value)
+		if ok {
+			format.WriteByte('c')
+			return fmt.Sprintf(format.String(), rune(number)), true
+		}
+
+	case 'e', 'E', 'f', 'g', 'G':
+		number, ok := toFloat64(value)
+		if ok {
+			format.WriteByte(verb)
+			return fmt.Sprintf(format.String(), number), true
+		}
+	}
+
+	// Strings (s, S) and anything we can not convert.
+	format.WriteByte('s')
+	return fmt.Sprintf(format.String(), fmt.Sprintf("%v", value)), true
+}
+
+func truncateUnsigned(number int64, bits int) uint64 {
+	switch bits {
+	case 16:
+		return uint64(uint16(number))
+	case 32:
+		// Values which do not fit in 32 bits are shown in full.
+		if number >= -(1<<31) && number < (1<<32) {
+			return uint64(uint32(number))
+		}
+	}
+	return uint64(number)
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch t := value.(type) {
+	case int:
+		return int64(t), true
+	case int8:
+		return int64(t), true
+	case int16:
+		return int64(t), true
+	case int32:
+		return int64(t), true
+	case int64:
+		return t, true
+	case uint:
+		return int64(t), true
+	case uint8:
+		return int64(t), true
+	case uint16:
+		return int64(t), true
+	case uint32:
+		return int64(t), true
+	case uint64:
+		return int64(t), true
+	case float64:
+		return int64(t), true
+	case bool:
+		if t {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		number, err := strconv.ParseInt(strings.TrimSpace(t), 0, 64)
+		if err == nil {
+			return number, true
+		}
+		unsigned, err := strconv.ParseUint(strings.TrimSpace(t), 0, 64)
+		if err == nil {
+			return int64(unsigned), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch t := value.(type) {
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		return number, err == nil
+	}
+
+	number, ok := toInt64(value)
+	return float64(number), ok
+}
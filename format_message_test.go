@@ -0,0 +1,41 @@
+package evtx
+
+import "testing"
+
+func TestFormatMessage(t *testing.T) {
+	args := []interface{}{"hello", 255, "0x1f", int64(-1), 10, 3, "abcdef", 1.5}
+
+	for _, test := range []struct {
+		template string
+		expected string
+	}{
+		{"Plain text", "Plain text"},
+		{"%1 world", "hello world"},
+		{"%1!s! %2!d!", "hello 255"},
+		{"%2!08x! %2!X! %2!#x! %2!o!", "000000ff FF 0xff 377"},
+		{"%3!d!", "31"},
+		{"%4!u! %4!x! %4!I64x! %4!hx!", "4294967295 ffffffff ffffffffffffffff ffff"},
+		{"[%1!-8s!] [%1!8s!] [%7!.3s!]", "[hello   ] [   hello] [abc]"},
+		{"[%5!*s!]", "[         3]"},
+		{"[%5!*.*s!]", "[       abc]"},
+		{"%2!lu! %2!ld! %2!I32u!", "255 255 255"},
+		{"%8!.2f! %8!e!", "1.50 1.500000e+00"},
+		{"%2!c!", "ÿ"},
+		{"%1!S! %1!ws!", "hello hello"},
+		{"100%% done%. %!", "100% done. !"},
+		{"a%nb%tc%bd%r", "a\nb\tc d"},
+		{"% space", " space"},
+		{"Stop here%0 not here", "Stop here"},
+		{"Missing %9 and %12!d!", "Missing %9 and %12!d!"},
+		{"%10", "%10"},
+		{"%1%2", "hello255"},
+		{"Trailing %", "Trailing %"},
+		{"%1!x!", "hello"},
+	} {
+		actual := FormatMessage(test.template, args)
+		if actual != test.expected {
+			t.Errorf("FormatMessage(%q): expected %q, got %q",
+				test.template, test.expected, actual)
+		}
+	}
+}
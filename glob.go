@@ -0,0 +1,131 @@
+package evtx
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MatchGlob matches a slash separated path against a glob
+// pattern. In addition to the usual path.Match() syntax, a "**"
+// component matches any number of directories. Matching is case
+// insensitive and backslashes are treated as separators since the
+// paths usually come from Windows systems.
+func MatchGlob(pattern, name string) bool {
+	return matchComponents(splitGlobPath(pattern), splitGlobPath(name))
+}
+
+func splitGlobPath(p string) []string {
+	p = strings.ToLower(strings.ReplaceAll(p, "\\", "/"))
+	result := []string{}
+	for _, component := range strings.Split(p, "/") {
+		if component != "" {
+			result = append(result, component)
+		}
+	}
+	return result
+}
+
+func matchComponents(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive ** components.
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+
+			for i := 0; i <= len(name); i++ {
+				if matchComponents(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		matched, err := path.Match(pattern[0], name[0])
+		if err != nil || !matched {
+			return false
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
+
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// ExpandGlob returns the regular files matching the pattern. In
+// addition to the filepath.Glob() syntax, "**" matches any number of
+// directories.
+func ExpandGlob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		result := []string{}
+		for _, filename := range matches {
+			stat, err := os.Stat(filename)
+			if err == nil && stat.Mode().IsRegular() {
+				result = append(result, filename)
+			}
+		}
+		return result, nil
+	}
+
+	// Walk from the deepest directory without glob characters.
+	components := strings.Split(filepath.ToSlash(pattern), "/")
+	root_components := []string{}
+	for len(components) > 0 && !hasGlobMeta(components[0]) {
+		root_components = append(root_components, components[0])
+		components = components[1:]
+	}
+
+	root := strings.Join(root_components, "/")
+	if root == "" && strings.HasPrefix(pattern, "/") {
+		root = "/"
+	} else if root == "" {
+		root = "."
+	}
+
+	return FindFiles(filepath.FromSlash(root), strings.Join(components, "/"))
+}
+
+// FindFiles recursively lists the regular files under the root
+// directory whose path relative to the root matches the glob.
+func FindFiles(root, glob string) ([]string, error) {
+	result := []string{}
+	err := filepath.WalkDir(root, func(
+		filename string, entry fs.DirEntry, err error) error {
+		// Skip directories we can not read.
+		if err != nil {
+			return nil
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		relative, err := filepath.Rel(root, filename)
+		if err == nil && MatchGlob(glob, filepath.ToSlash(relative)) {
+			result = append(result, filename)
+		}
+		return nil
+	})
+
+	return result, err
+}
@@ -0,0 +1,89 @@
+package evtx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"**/*.evtx", "Security.evtx", true},
+		{"**/*.evtx", "C/Windows/System32/winevt/Logs/Security.evtx", true},
+		{"**/Windows/System32/winevt/Logs/*.evtx",
+			"uploads/auto/C%3A/Windows/System32/winevt/Logs/System.evtx", true},
+		{"**/winevt/logs/*.evtx", `C\Windows\System32\winevt\Logs\System.evtx`, true},
+		{"**/winevt/Logs/*.evtx", "C/Windows/System32/winevt/Logs/sub/System.evtx", false},
+		{"*.evtx", "C/Security.evtx", false},
+		{"C/**/Security.evtx", "C/Security.evtx", true},
+		{"**", "anything/at/all", true},
+	} {
+		assert.Equal(t, tc.match, MatchGlob(tc.pattern, tc.name),
+			"%v %v", tc.pattern, tc.name)
+	}
+}
+
+func TestFindFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{
+		"Security.evtx",
+		"C/Windows/System32/winevt/Logs/System.evtx",
+		"C/Windows/System32/winevt/Logs/notes.txt",
+		"D/Logs/Application.EVTX",
+	} {
+		path = filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, nil, 0600))
+	}
+
+	// Directories are not files even if they match.
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dir.evtx"), 0700))
+
+	join := func(paths ...string) []string {
+		result := []string{}
+		for _, path := range paths {
+			result = append(result, filepath.Join(root, path))
+		}
+		return result
+	}
+
+	for _, tc := range []struct {
+		glob  string
+		files []string
+	}{
+		{"**/*.evtx", join("C/Windows/System32/winevt/Logs/System.evtx",
+			"D/Logs/Application.EVTX", "Security.evtx")},
+		{"*.evtx", join("Security.evtx")},
+		{"**/winevt/logs/*", join("C/Windows/System32/winevt/Logs/System.evtx",
+			"C/Windows/System32/winevt/Logs/notes.txt")},
+		{"**/*.dll", join()},
+	} {
+		files, err := FindFiles(root, tc.glob)
+		assert.NoError(t, err, tc.glob)
+		assert.Equal(t, tc.files, files, tc.glob)
+	}
+
+	for _, tc := range []struct {
+		pattern string
+		files   []string
+	}{
+		// ** matches any number of directories, including none.
+		{"**/*.evtx", join("C/Windows/System32/winevt/Logs/System.evtx",
+			"D/Logs/Application.EVTX", "Security.evtx")},
+		{"C/**/Logs/*.evtx", join("C/Windows/System32/winevt/Logs/System.evtx")},
+
+		// Other patterns are expanded by filepath.Glob().
+		{"*/Logs/*", join("D/Logs/Application.EVTX")},
+		{"*.evtx", join("Security.evtx")},
+	} {
+		files, err := ExpandGlob(filepath.Join(root, tc.pattern))
+		assert.NoError(t, err, tc.pattern)
+		assert.Equal(t, tc.files, files, tc.pattern)
+	}
+}
@@ -0,0 +1,295 @@
+package evtx
+
+import (
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	errors "github.com/pkg/errors"
+)
+
+// When a log is saved from Event Viewer with display information,
+// the rendered metadata of its providers is written to this folder
+// next to the log as <log name>_<LCID>.MTA files.
+const LOCALE_METADATA_DIRECTORY = "LocaleMetaData"
+
+// Languages of the LCIDs in the names of MTA files. The Culture of
+// the events in the file is preferred when it is set.
+var lcid_languages = map[int]string{
+	1025: "ar-SA",
+	1028: "zh-TW",
+	1029: "cs-CZ",
+	1030: "da-DK",
+	1031: "de-DE",
+	1032: "el-GR",
+	1033: "en-US",
+	1035: "fi-FI",
+	1036: "fr-FR",
+	1037: "he-IL",
+	1038: "hu-HU",
+	1040: "it-IT",
+	1041: "ja-JP",
+	1042: "ko-KR",
+	1043: "nl-NL",
+	1044: "nb-NO",
+	1045: "pl-PL",
+	1046: "pt-BR",
+	1049: "ru-RU",
+	1053: "sv-SE",
+	1055: "tr-TR",
+	2052: "zh-CN",
+	2057: "en-GB",
+	2070: "pt-PT",
+	3082: "es-ES",
+}
+
+// FindLocaleMetaData returns the MTA files saved with the log file,
+// or nothing if there are none.
+func FindLocaleMetaData(filename string) ([]string, error) {
+	directory := filepath.Join(filepath.Dir(filename), LOCALE_METADATA_DIRECTORY)
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	base := filepath.Base(filename)
+	prefix := strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base)) + "_")
+
+	result := []string{}
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) ||
+			filepath.Ext(name) != ".mta" {
+			continue
+		}
+		result = append(result, filepath.Join(directory, entry.Name()))
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// The language of an MTA file from the LCID in its name,
+// e.g. Security_1033.MTA.
+func localeMetaDataLanguage(filename string) string {
+	base := filepath.Base(filename)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	idx := strings.LastIndex(base, "_")
+	if idx < 0 {
+		return ""
+	}
+
+	lcid, err := strconv.Atoi(base[idx+1:])
+	if err != nil {
+		return ""
+	}
+	return lcid_languages[lcid]
+}
+
+// LoadLocaleMetaData reads MTA files into a message bundle.
+func LoadLocaleMetaData(filenames ...string) (*MessageBundle, error) {
+	bundle := NewMessageBundle()
+	for _, filename := range filenames {
+		fd, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ReadLocaleMetaData(fd, bundle, localeMetaDataLanguage(filename))
+		fd.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, filename)
+		}
+	}
+
+	// Events of the same kind repeat the same metadata.
+	merged, _ := MergeMessageBundles(bundle)
+	return merged, nil
+}
+
+// NewLocaleMetaDataResolver resolves messages from the MTA files.
+func NewLocaleMetaDataResolver(filenames ...string) (*BundleResolver, error) {
+	bundle, err := LoadLocaleMetaData(filenames...)
+	if err != nil {
+		return nil, err
+	}
+
+	self := NewBundleResolver(bundle)
+	if len(filenames) > 0 {
+		self.source = "mta:" + filenames[0]
+	}
+	return self, nil
+}
+
+// ReadLocaleMetaData adds the metadata of the MTA file to the
+// bundle. MTA files are event logs whose events carry their rendered
+// message and names in a RenderingInfo element. Damaged chunks are
+// skipped. The language is used if the events do not give their
+// culture.
+func ReadLocaleMetaData(fd io.ReadSeeker, bundle *MessageBundle, language string) error {
+	chunks, err := GetChunks(fd)
+	if err != nil {
+		return err
+	}
+
+	providers := make(map[string]*BundleProvider)
+	for _, provider := range bundle.Providers {
+		providers[providerKey(provider.Name, provider.Guid, provider.Channel)] = provider
+	}
+
+	for _, chunk := range chunks {
+		records, _ := chunk.Parse(0)
+		for _, record := range records {
+			event_map, ok := record.Event.(*ordereddict.Dict)
+			if !ok {
+				continue
+			}
+
+			event, ok := ordereddict.GetMap(event_map, "Event")
+			if ok {
+				addLocaleMetaDataEvent(bundle, providers, event, language)
+			}
+		}
+	}
+	return nil
+}
+
+func addLocaleMetaDataEvent(bundle *MessageBundle,
+	providers map[string]*BundleProvider, event *ordereddict.Dict, language string) {
+	rendering := GetRenderingInfo(event)
+	if rendering == nil {
+		return
+	}
+
+	name, _ := ordereddict.GetString(event, "System.Provider.Name")
+	guid, _ := ordereddict.GetString(event, "System.Provider.Guid")
+	channel, _ := ordereddict.GetString(event, "System.Channel")
+	if name == "" && guid == "" {
+		return
+	}
+
+	if rendering.Culture != "" {
+		language = rendering.Culture
+	}
+
+	key := providerKey(name, guid, channel)
+	provider, pres := providers[key]
+	if !pres {
+		provider = &BundleProvider{
+			Name:    name,
+			Guid:    NormalizeGUID(guid),
+			Channel: channel,
+		}
+		providers[key] = provider
+		bundle.Providers = append(bundle.Providers, provider)
+	}
+
+	system, _ := ordereddict.GetMap(event, "System")
+	system_value := func(name string) (uint64, bool) {
+		if system == nil {
+			return 0, false
+		}
+		value, pres := system.Get(name)
+		if !pres {
+			return 0, false
+		}
+		number, ok := toInt64(value)
+		return uint64(number), ok
+	}
+
+	if rendering.Message != "" {
+		event_id, pres := ordereddict.GetInt(event, "System.EventID.Value")
+		if !pres {
+			event_id, pres = ordereddict.GetInt(event, "System.EventID")
+		}
+
+		version, version_pres := ordereddict.GetInt(event, "System.Version")
+		if !version_pres {
+			version = -1
+		}
+
+		// Classic events are looked up by the full message id.
+		id := int64(event_id)
+		qualifiers, qualifiers_pres := ordereddict.GetInt(event, "System.EventID.Qualifiers")
+		if qualifiers_pres {
+			id = QualifiedMessageId(qualifiers, event_id)
+		}
+
+		if pres {
+			provider.Messages = append(provider.Messages, &BundleMessage{
+				Id:           id,
+				EventId:      event_id,
+				EventVersion: version,
+				Language:     language,
+				Message:      rendering.Message,
+			})
+		}
+	}
+
+	add_value := func(kind string, value uint64, task int, name string) {
+		if name == "" {
+			return
+		}
+		provider.Values = append(provider.Values, &BundleValue{
+			Kind:      kind,
+			Value:     value,
+			Task:      task,
+			Name:      name,
+			MessageId: -1,
+		})
+	}
+
+	level, pres := system_value("Level")
+	if pres {
+		add_value("level", level, 0, rendering.Level)
+	}
+
+	// Task 0 means the event has no task.
+	task, pres := system_value("Task")
+	if pres && task != 0 {
+		add_value("task", task, 0, rendering.Task)
+	}
+
+	opcode, pres := system_value("Opcode")
+	if pres {
+		add_value("opcode", opcode, int(task), rendering.Opcode)
+	}
+
+	keywords, pres := system_value("Keywords")
+	if pres {
+		for mask, name := range localeMetaDataKeywords(keywords, rendering.Keywords) {
+			add_value("keyword", mask, 0, name)
+		}
+	}
+}
+
+// The keywords are only rendered as a list of names, so a name can
+// only be tied to its bit when the event has a single keyword of its
+// provider. The top 16 bits are reserved for the standard keywords.
+func localeMetaDataKeywords(keywords uint64, names []string) map[uint64]string {
+	standard_names := make(map[string]bool)
+	for _, name := range winmeta_keywords {
+		standard_names[name] = true
+	}
+
+	remaining := []string{}
+	for _, name := range names {
+		if !standard_names[name] {
+			remaining = append(remaining, name)
+		}
+	}
+
+	keywords &= 0x0000FFFFFFFFFFFF
+	if len(remaining) != 1 || bits.OnesCount64(keywords) != 1 {
+		return nil
+	}
+	return map[uint64]string{keywords: remaining[0]}
+}
@@ -0,0 +1,178 @@
+package evtx
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An event as it appears in an MTA file.
+func localeMetaDataEvent(event_id int, message string) *ordereddict.Dict {
+	return ordereddict.NewDict().
+		Set("System", ordereddict.NewDict().
+			Set("Provider", ordereddict.NewDict().
+				Set("Name", "Microsoft-Windows-Security-Auditing").
+				Set("Guid", "{54849625-5478-4994-a5ba-3e3b0328c30d}")).
+			Set("EventID", ordereddict.NewDict().Set("Value", event_id)).
+			Set("Version", 2).
+			Set("Level", 0).
+			Set("Task", 12544).
+			Set("Opcode", 0).
+			Set("Keywords", uint64(0x8020000000000000)).
+			Set("Channel", "Security")).
+		Set("RenderingInfo", ordereddict.NewDict().
+			Set("Culture", "en-US").
+			Set("Message", message).
+			Set("Level", "Information").
+			Set("Task", "Logon").
+			Set("Opcode", "Info").
+			Set("Keywords", ordereddict.NewDict().
+				Set("Keyword", "Audit Success")))
+}
+
+func TestLocaleMetaData(t *testing.T) {
+	raw := NewMessageBundle()
+	providers := make(map[string]*BundleProvider)
+	for _, event := range []*ordereddict.Dict{
+		localeMetaDataEvent(4624, "An account was successfully logged on."),
+		localeMetaDataEvent(4624, "An account was successfully logged on."),
+		localeMetaDataEvent(4634, "An account was logged off."),
+
+		// Events without rendering information are ignored.
+		ordereddict.NewDict().Set("System", ordereddict.NewDict()),
+	} {
+		addLocaleMetaDataEvent(raw, providers, event, "de-DE")
+	}
+
+	bundle, _ := MergeMessageBundles(raw)
+	assert.Equal(t, 1, len(bundle.Providers))
+	assert.Equal(t, 2, len(bundle.Providers[0].Messages))
+	assert.Equal(t, "en-US", bundle.Providers[0].Messages[0].Language)
+
+	resolver := NewBundleResolver(bundle)
+	result, err := resolver.LookupMessage(context.Background(), &MessageRequest{
+		Provider: "54849625-5478-4994-A5BA-3E3B0328C30D", Channel: "Security",
+		EventId: 4634, Qualifiers: -1, Version: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "An account was logged off.", result.Message)
+
+	assert.Equal(t, "Logon", resolver.GetProviderValueName(
+		"Microsoft-Windows-Security-Auditing", "Security", "task", 12544, 0))
+
+	// Only the standard keyword is named so no provider keyword is
+	// known.
+	assert.Equal(t, "", resolver.GetProviderValueName(
+		"Microsoft-Windows-Security-Auditing", "Security", "keyword",
+		0x0020000000000000, 0))
+}
+
+func TestLocaleMetaDataKeywords(t *testing.T) {
+	names := []string{"Audit Success", "Logon"}
+
+	assert.Equal(t, map[uint64]string{0x10: "Logon"},
+		localeMetaDataKeywords(0x8020000000000010, names))
+
+	// Two keywords of the provider can not be told apart.
+	assert.Nil(t, localeMetaDataKeywords(0x8020000000000011, names))
+}
+
+func TestFindLocaleMetaData(t *testing.T) {
+	dir := t.TempDir()
+	metadata_dir := filepath.Join(dir, LOCALE_METADATA_DIRECTORY)
+	assert.NoError(t, os.Mkdir(metadata_dir, 0700))
+
+	for _, name := range []string{
+		"Security_1033.MTA", "Security_1031.mta", "System_1033.MTA", "Security.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(metadata_dir, name), nil, 0600))
+	}
+
+	found, err := FindLocaleMetaData(filepath.Join(dir, "Security.evtx"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(metadata_dir, "Security_1031.mta"),
+		filepath.Join(metadata_dir, "Security_1033.MTA"),
+	}, found)
+
+	assert.Equal(t, "de-DE", localeMetaDataLanguage(found[0]))
+	assert.Equal(t, "en-US", localeMetaDataLanguage(found[1]))
+
+	found, err = FindLocaleMetaData(filepath.Join(metadata_dir, "Other.evtx"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(found))
+
+	// MTA files are event logs.
+	format, err := DetectMessageSourceFormat("testdata/Security_1_record.evtx")
+	assert.NoError(t, err)
+	assert.Equal(t, MESSAGE_SOURCE_MTA, format)
+}
+
+// testdata/mta/LocaleMetaData holds the display information Event
+// Viewer saved with testdata/Security_1_record.evtx: the MTA file is
+// an event log whose events carry the German messages and names of
+// the Eventlog provider in their RenderingInfo. Save the log next to
+// it in a temporary directory and return the log's path.
+func saveWithLocaleMetaData(t *testing.T) string {
+	dir := t.TempDir()
+	mta_file := filepath.Join(LOCALE_METADATA_DIRECTORY, "Security_1031.MTA")
+	for src, dest := range map[string]string{
+		filepath.Join("testdata", "Security_1_record.evtx"): "Security.evtx",
+		filepath.Join("testdata", "mta", mta_file):          mta_file,
+	} {
+		data, err := os.ReadFile(src)
+		require.NoError(t, err)
+
+		dest = filepath.Join(dir, dest)
+		require.NoError(t, os.MkdirAll(filepath.Dir(dest), 0700))
+		require.NoError(t, os.WriteFile(dest, data, 0600))
+	}
+	return filepath.Join(dir, "Security.evtx")
+}
+
+func TestLocaleMetaDataFile(t *testing.T) {
+	log_file := saveWithLocaleMetaData(t)
+	mta_file := filepath.Join(filepath.Dir(log_file), LOCALE_METADATA_DIRECTORY, "Security_1031.MTA")
+
+	found, err := FindLocaleMetaData(log_file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{mta_file}, found)
+
+	format, err := DetectMessageSourceFormat(mta_file)
+	assert.NoError(t, err)
+	assert.Equal(t, MESSAGE_SOURCE_MTA, format)
+
+	resolver, err := NewLocaleMetaDataResolver(found...)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		provider string
+		event_id int
+		message  string
+	}{
+		{"Microsoft-Windows-Eventlog", 1102, "Das Überwachungsprotokoll wurde gelöscht."},
+		{"{fc65ddd8-d6ef-4962-83d5-6e5cfe9ce148}", 1100,
+			"Der Ereignisprotokollierungsdienst wurde beendet."},
+	} {
+		result, err := resolver.LookupMessage(context.Background(), &MessageRequest{
+			Provider: tc.provider, Channel: "Security",
+			EventId: tc.event_id, Qualifiers: -1, Version: 0})
+		require.NoError(t, err, tc.provider)
+		assert.Equal(t, tc.message, result.Message)
+		assert.Equal(t, "mta:"+mta_file, result.Source)
+	}
+
+	assert.Equal(t, "Protokoll löschen", resolver.GetProviderValueName(
+		"Microsoft-Windows-Eventlog", "Security", "task", 104, 0))
+	assert.Equal(t, "Informationen", resolver.GetProviderValueName(
+		"Microsoft-Windows-Eventlog", "Security", "level", 4, 0))
+
+	// Messages which are not in the file are not found.
+	_, err = resolver.LookupMessage(context.Background(), &MessageRequest{
+		Provider: "Microsoft-Windows-Eventlog", Channel: "Security",
+		EventId: 1104, Qualifiers: -1, Version: 0})
+	assert.Error(t, err)
+}
@@ -0,0 +1,284 @@
+package evtx
+
+import (
+	"container/heap"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/Velocidex/ordereddict"
+	errors "github.com/pkg/errors"
+)
+
+// ChunkIterator produces the chunks of a log one at a time. Next()
+// returns io.EOF when there are no more chunks. ChunkStream is a
+// ChunkIterator.
+type ChunkIterator interface {
+	Next() (*Chunk, error)
+}
+
+type chunkList struct {
+	chunks []*Chunk
+}
+
+func (self *chunkList) Next() (*Chunk, error) {
+	if len(self.chunks) == 0 {
+		return nil, io.EOF
+	}
+
+	chunk := self.chunks[0]
+	self.chunks = self.chunks[1:]
+	return chunk, nil
+}
+
+// NewChunkIterator iterates over chunks returned by GetChunks(). The
+// chunk data is only read when the chunk is reached. Chunks are
+// visited in record id order since the oldest chunk of a circular log
+// may be in the middle of the file.
+func NewChunkIterator(chunks []*Chunk) ChunkIterator {
+	sorted := append([]*Chunk{}, chunks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Header.FirstEventRecID < sorted[j].Header.FirstEventRecID
+	})
+	return &chunkList{chunks: sorted}
+}
+
+// fileChunkIterator only keeps the file open while a chunk is read,
+// so many logs can be merged without running out of file handles.
+type fileChunkIterator struct {
+	filename string
+	chunks   ChunkIterator
+}
+
+// NewFileChunkIterator iterates over the chunks of an EVTX file like
+// NewChunkIterator() but reopens the file for each chunk.
+func NewFileChunkIterator(filename string) (ChunkIterator, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	chunks, err := GetChunks(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileChunkIterator{
+		filename: filename,
+		chunks:   NewChunkIterator(chunks),
+	}, nil
+}
+
+func (self *fileChunkIterator) Next() (*Chunk, error) {
+	chunk, err := self.chunks.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.Open(self.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	chunk.Fd = fd
+	chunk.data, err = chunk.readData()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Chunk at offset %#x", chunk.Offset)
+	}
+	return chunk, nil
+}
+
+// MergeSource is one of the logs to merge.
+type MergeSource struct {
+	// Used to break ties between events from different sources.
+	Name   string
+	Chunks ChunkIterator
+
+	// If set, closed by the Merger once all the chunks are read.
+	Closer io.Closer
+}
+
+func (self *MergeSource) close() {
+	if self.Closer != nil {
+		self.Closer.Close()
+		self.Closer = nil
+	}
+}
+
+// MergedRecord is an event produced by the Merger.
+type MergedRecord struct {
+	Source *MergeSource
+	Record *EventRecord
+
+	// TimeCreated in seconds since the epoch.
+	Time float64
+}
+
+// GetTimeCreated returns the System.TimeCreated time of the record in
+// seconds since the epoch. If the event does not have one we fall
+// back to the time the record was written.
+func GetTimeCreated(record *EventRecord) float64 {
+	event_map, ok := record.Event.(*ordereddict.Dict)
+	if ok {
+		event, ok := ordereddict.GetMap(event_map, "Event")
+		if ok {
+			time := getEventTime(event)
+			if time != 0 {
+				return time
+			}
+		}
+	}
+
+	return filetimeToUnixtime(record.Header.FileTime)
+}
+
+// The System.TimeCreated of the event in seconds since the epoch, or
+// 0 if it has none.
+func getEventTime(event *ordereddict.Dict) float64 {
+	value, _ := ordereddict.GetAny(event, "System.TimeCreated.SystemTime")
+	switch t := value.(type) {
+	case float64:
+		return t
+	case uint64:
+		return filetimeToUnixtime(t)
+	}
+	return 0
+}
+
+// A source being merged along with the sorted records of its current
+// chunk.
+type mergeCursor struct {
+	source  *MergeSource
+	records []*MergedRecord
+}
+
+// Load the next chunk which has any records.
+func (self *mergeCursor) fill(on_error func(source string, err error)) bool {
+	for len(self.records) == 0 {
+		chunk, err := self.source.Chunks.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				on_error(self.source.Name, err)
+			}
+			return false
+		}
+
+		// Some records may still be returned with an error.
+		records, err := chunk.Parse(0)
+		if err != nil {
+			on_error(self.source.Name, errors.Wrapf(err,
+				"Chunk at offset %#x", chunk.Offset))
+		}
+
+		for _, record := range records {
+			self.records = append(self.records, &MergedRecord{
+				Source: self.source,
+				Record: record,
+				Time:   GetTimeCreated(record),
+			})
+		}
+
+		// Records are mostly in order within a chunk, but not
+		// always.
+		sort.SliceStable(self.records, func(i, j int) bool {
+			return lessMergedRecord(self.records[i], self.records[j])
+		})
+	}
+
+	return true
+}
+
+func lessMergedRecord(a, b *MergedRecord) bool {
+	if a.Time != b.Time {
+		return a.Time < b.Time
+	}
+	if a.Source.Name != b.Source.Name {
+		return a.Source.Name < b.Source.Name
+	}
+	return a.Record.Header.RecordID < b.Record.Header.RecordID
+}
+
+type mergeHeap []*mergeCursor
+
+func (self mergeHeap) Len() int { return len(self) }
+func (self mergeHeap) Less(i, j int) bool {
+	return lessMergedRecord(self[i].records[0], self[j].records[0])
+}
+func (self mergeHeap) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+
+func (self *mergeHeap) Push(x interface{}) {
+	*self = append(*self, x.(*mergeCursor))
+}
+
+func (self *mergeHeap) Pop() interface{} {
+	old := *self
+	item := old[len(old)-1]
+	*self = old[:len(old)-1]
+	return item
+}
+
+// Merger does a k-way merge of many logs into a single stream of
+// events ordered by TimeCreated. Ties are broken by the source name
+// and then the record id. Only one chunk of each source is held in
+// memory at a time, so events which are out of order across chunks
+// of the same log are emitted in the order of their chunks.
+type Merger struct {
+	cursors mergeHeap
+
+	// Called for errors reading a source. The remaining sources
+	// are still merged.
+	OnError func(source string, err error)
+
+	started bool
+	sources []*MergeSource
+}
+
+func NewMerger(sources []*MergeSource) *Merger {
+	return &Merger{
+		sources: sources,
+		OnError: func(source string, err error) {},
+	}
+}
+
+// Next returns the next event in time order, or io.EOF when all the
+// sources are exhausted.
+func (self *Merger) Next() (*MergedRecord, error) {
+	if !self.started {
+		self.started = true
+		for _, source := range self.sources {
+			cursor := &mergeCursor{source: source}
+			if cursor.fill(self.OnError) {
+				self.cursors = append(self.cursors, cursor)
+			} else {
+				source.close()
+			}
+		}
+		heap.Init(&self.cursors)
+	}
+
+	if len(self.cursors) == 0 {
+		return nil, io.EOF
+	}
+
+	cursor := self.cursors[0]
+	result := cursor.records[0]
+	cursor.records = cursor.records[1:]
+
+	if cursor.fill(self.OnError) {
+		heap.Fix(&self.cursors, 0)
+	} else {
+		heap.Pop(&self.cursors)
+		cursor.source.close()
+	}
+
+	return result, nil
+}
+
+// Close closes the sources which were not read to the end.
+func (self *Merger) Close() {
+	for _, source := range self.sources {
+		source.close()
+	}
+}
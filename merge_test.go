@@ -0,0 +1,114 @@
+package evtx
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openMergeSource(t *testing.T, name, filename string) *MergeSource {
+	fd, err := os.Open(filename)
+	require.NoError(t, err)
+	t.Cleanup(func() { fd.Close() })
+
+	chunks, err := GetChunks(fd)
+	require.NoError(t, err)
+
+	return &MergeSource{Name: name, Chunks: NewChunkIterator(chunks)}
+}
+
+func TestMerger(t *testing.T) {
+	// Merge a log with itself so every event has a tie.
+	merger := NewMerger([]*MergeSource{
+		openMergeSource(t, "b", "testdata/Security.evtx"),
+		openMergeSource(t, "c", "testdata/Microsoft-Windows-CAPI2_Operational_EventID70.evtx"),
+		openMergeSource(t, "a", "testdata/Security.evtx"),
+	})
+	merger.OnError = func(source string, err error) {
+		assert.NoError(t, err, source)
+	}
+
+	var last *MergedRecord
+	count := 0
+	for {
+		record, err := merger.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		// Security.evtx has a chunk where the clock goes back,
+		// so only check the order across sources.
+		if last != nil && record.Source.Name != last.Source.Name {
+			require.False(t, lessMergedRecord(record, last),
+				"Record %v from %v out of order",
+				record.Record.Header.RecordID, record.Source.Name)
+		}
+
+		// The copy named "a" always wins the tie.
+		if last != nil && record.Source.Name == "b" &&
+			record.Time == last.Time &&
+			record.Record.Header.RecordID == last.Record.Header.RecordID {
+			require.Equal(t, "a", last.Source.Name,
+				"Record %v not merged after its copy",
+				record.Record.Header.RecordID)
+		}
+		last = record
+		count++
+	}
+
+	assert.Equal(t, 739*2+1, count)
+}
+
+type countingCloser struct {
+	closed int
+}
+
+func (self *countingCloser) Close() error {
+	self.closed++
+	return nil
+}
+
+func TestMergerClosesSources(t *testing.T) {
+	chunks, err := NewFileChunkIterator("testdata/Security.evtx")
+	require.NoError(t, err)
+
+	short := &countingCloser{}
+	long := &countingCloser{}
+	merger := NewMerger([]*MergeSource{
+		{Name: "short", Chunks: NewChunkIterator(nil), Closer: short},
+		{Name: "long", Chunks: chunks, Closer: long},
+	})
+	merger.OnError = func(source string, err error) {
+		assert.NoError(t, err, source)
+	}
+
+	// Sources are closed as soon as they run out.
+	record, err := merger.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "long", record.Source.Name)
+	assert.Equal(t, 1, short.closed)
+	assert.Equal(t, 0, long.closed)
+
+	count := 1
+	for {
+		_, err := merger.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		count++
+	}
+
+	// The file is reopened for each chunk.
+	assert.Equal(t, 739, count)
+	assert.Equal(t, 1, long.closed)
+
+	merger.Close()
+	assert.Equal(t, 1, short.closed)
+	assert.Equal(t, 1, long.closed)
+}
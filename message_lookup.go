@@ -0,0 +1,343 @@
+package evtx
+
+import (
+	"context"
+
+	"github.com/Velocidex/ordereddict"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	// The provider is known but has no message for the event.
+	ErrMessageNotFound = errors.New("Message not found")
+
+	// The resolver knows nothing about the provider.
+	ErrProviderNotFound = errors.New("Provider not found")
+)
+
+// IsNotFound returns true if the error only means the resolver has
+// no answer, as opposed to failing.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrMessageNotFound) ||
+		errors.Is(err, ErrProviderNotFound)
+}
+
+// MessageRequest describes the message of an event to look up.
+type MessageRequest struct {
+	// The provider's name or GUID.
+	Provider string
+	Channel  string
+	EventId  int
+
+	// The qualifiers of classic events and the version of the
+	// event, -1 if not known.
+	Qualifiers int
+	Version    int
+
+	// The preferred language, empty for the resolver's default.
+	Language string
+
+	NumberOfExpansions int
+}
+
+// ParameterRequest describes a %%NNNN parameter string to look up.
+type ParameterRequest struct {
+	Provider    string
+	Channel     string
+	ParameterId int
+	Language    string
+}
+
+// MessageResult is a resolved message. The message may legitimately
+// be empty.
+type MessageResult struct {
+	Message string
+
+	// Describes the resolver which found the message, e.g.
+	// "db:<path>", "bundle:<path>", "bundle:default", "map",
+	// "mta:<path>", "native" or "RenderingInfo".
+	Source string
+}
+
+const (
+	// The messages of the providers installed on this machine.
+	NATIVE_MESSAGE_SOURCE = "native"
+
+	// Resolvers which do not name themselves.
+	UNNAMED_MESSAGE_SOURCE = "resolver"
+)
+
+// MessageSourceNamer is implemented by MessageResolvers which are not
+// MessageResolverV2 to name themselves in MessageResult.Source.
+type MessageSourceNamer interface {
+	MessageSource() string
+}
+
+func getMessageSourceName(resolver MessageResolver) string {
+	namer, ok := resolver.(MessageSourceNamer)
+	if ok {
+		return namer.MessageSource()
+	}
+	return UNNAMED_MESSAGE_SOURCE
+}
+
+// MessageResolverV2 distinguishes messages which are not known
+// (ErrMessageNotFound or ErrProviderNotFound) from resolvers which
+// fail, and can be cancelled through the context. Use
+// AsMessageResolverV2() to use a MessageResolver through this
+// interface.
+type MessageResolverV2 interface {
+	LookupMessage(ctx context.Context, request *MessageRequest) (*MessageResult, error)
+	LookupParameter(ctx context.Context, request *ParameterRequest) (*MessageResult, error)
+	Close()
+}
+
+// Adapts a MessageResolver to the MessageResolverV2 interface. An
+// empty message means it was not found.
+type messageResolverAdapter struct {
+	resolver MessageResolver
+}
+
+func (self *messageResolverAdapter) LookupMessage(
+	ctx context.Context, request *MessageRequest) (*MessageResult, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	message := lookupMessage(self.resolver, request.Provider, request.Channel,
+		request.Qualifiers, request.EventId, request.Version,
+		request.NumberOfExpansions)
+	if message == "" {
+		return nil, ErrMessageNotFound
+	}
+
+	return &MessageResult{
+		Message: message,
+		Source:  getMessageSourceName(self.resolver),
+	}, nil
+}
+
+func (self *messageResolverAdapter) LookupParameter(
+	ctx context.Context, request *ParameterRequest) (*MessageResult, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	parameter := self.resolver.GetParameter(
+		request.Provider, request.Channel, request.ParameterId)
+	if parameter == "" {
+		return nil, ErrMessageNotFound
+	}
+
+	return &MessageResult{
+		Message: parameter,
+		Source:  getMessageSourceName(self.resolver),
+	}, nil
+}
+
+func (self *messageResolverAdapter) Close() {
+	self.resolver.Close()
+}
+
+// AsMessageResolverV2 returns the resolver itself if it implements
+// MessageResolverV2, otherwise an adapter.
+func AsMessageResolverV2(resolver MessageResolver) MessageResolverV2 {
+	v2, ok := resolver.(MessageResolverV2)
+	if ok {
+		return v2
+	}
+
+	adapter, ok := resolver.(*messageResolverV2Adapter)
+	if ok {
+		return adapter.resolver
+	}
+
+	return &messageResolverAdapter{resolver: resolver}
+}
+
+// Adapts a MessageResolverV2 to the MessageResolver interface. Errors
+// turn into empty messages.
+type messageResolverV2Adapter struct {
+	resolver MessageResolverV2
+}
+
+func (self *messageResolverV2Adapter) GetMessage(provider, channel string,
+	event_id, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id, -1,
+		number_of_expansions)
+}
+
+func (self *messageResolverV2Adapter) GetVersionedMessage(provider, channel string,
+	event_id, version, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id,
+		version, number_of_expansions)
+}
+
+func (self *messageResolverV2Adapter) GetQualifiedMessage(provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	return getMessageV2(self.resolver, provider, channel,
+		qualifiers, event_id, version, number_of_expansions)
+}
+
+func (self *messageResolverV2Adapter) GetParameter(provider, channel string,
+	parameter_id int) string {
+	return getParameterV2(self.resolver, provider, channel, parameter_id)
+}
+
+func (self *messageResolverV2Adapter) Close() {
+	self.resolver.Close()
+}
+
+// Implements the MessageResolver methods for resolvers which
+// implement MessageResolverV2.
+func getMessageV2(resolver MessageResolverV2, provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	result, err := resolver.LookupMessage(context.Background(),
+		&MessageRequest{
+			Provider:           provider,
+			Channel:            channel,
+			EventId:            event_id,
+			Qualifiers:         qualifiers,
+			Version:            version,
+			NumberOfExpansions: number_of_expansions,
+		})
+	if err != nil {
+		return ""
+	}
+	return result.Message
+}
+
+func getParameterV2(resolver MessageResolverV2,
+	provider, channel string, parameter_id int) string {
+	result, err := resolver.LookupParameter(context.Background(),
+		&ParameterRequest{
+			Provider:    provider,
+			Channel:     channel,
+			ParameterId: parameter_id,
+		})
+	if err != nil {
+		return ""
+	}
+	return result.Message
+}
+
+// AsMessageResolver returns the resolver itself if it implements
+// MessageResolver, otherwise an adapter.
+func AsMessageResolver(resolver MessageResolverV2) MessageResolver {
+	v1, ok := resolver.(MessageResolver)
+	if ok {
+		return v1
+	}
+
+	adapter, ok := resolver.(*messageResolverAdapter)
+	if ok {
+		return adapter.resolver
+	}
+
+	return &messageResolverV2Adapter{resolver: resolver}
+}
+
+// ExpandMessageWithContext is like ExpandMessageWithArgs() but
+// reports why the message could not be resolved and where it came
+// from. The language may be empty for the resolver's default. If
+// prefer_rendering_info is set, the message the event carries in its
+// RenderingInfo is used before the resolver's.
+func ExpandMessageWithContext(ctx context.Context, event *ordereddict.Dict,
+	args []interface{}, resolver MessageResolver,
+	language string, prefer_rendering_info bool) (*MessageResult, error) {
+	expansions := args
+	if expansions == nil {
+		expansions = flatten(getMessageData(event))
+	}
+
+	result, provider, err := lookupEventMessage(
+		ctx, event, resolver, language, len(expansions), prefer_rendering_info)
+	if err != nil {
+		return nil, err
+	}
+
+	// Messages rendered into the event are already expanded.
+	if result.Source == RENDERING_INFO_SOURCE {
+		return result, nil
+	}
+
+	channel, _ := ordereddict.GetString(event, "System.Channel")
+	inserts := make([]interface{}, 0, len(expansions))
+	for _, item := range expansions {
+		inserts = append(inserts, maybeExpandObjects(
+			provider, channel, item, resolver))
+	}
+
+	// Replace expansions in the message with the user data.
+	return &MessageResult{
+		Message: FormatMessage(result.Message, inserts),
+		Source:  result.Source,
+	}, nil
+}
+
+// Look up the raw message of the event. Also returns the provider
+// name or GUID the message was found for.
+func lookupEventMessage(ctx context.Context, event *ordereddict.Dict,
+	resolver MessageResolver, language string,
+	number_of_expansions int,
+	prefer_rendering_info bool) (*MessageResult, string, error) {
+	provider, _ := ordereddict.GetString(event, "System.Provider.Name")
+	provider_guid, _ := ordereddict.GetString(event, "System.Provider.Guid")
+	channel, _ := ordereddict.GetString(event, "System.Channel")
+	event_id, _ := ordereddict.GetInt(event, "System.EventID.Value")
+	version, pres := ordereddict.GetInt(event, "System.Version")
+	if !pres {
+		version = -1
+	}
+
+	qualifiers, pres := ordereddict.GetInt(event, "System.EventID.Qualifiers")
+	if !pres {
+		qualifiers = -1
+	}
+
+	// The message Windows rendered into the event is used when the
+	// resolver has none.
+	rendered := renderedMessage(event)
+	if rendered != nil && prefer_rendering_info {
+		return rendered, provider, nil
+	}
+
+	if provider == "" && provider_guid == "" {
+		if rendered != nil {
+			return rendered, provider, nil
+		}
+		return nil, "", errors.Wrap(ErrProviderNotFound, "Event has no provider")
+	}
+
+	v2 := AsMessageResolverV2(resolver)
+	request := &MessageRequest{
+		Channel:            channel,
+		EventId:            event_id,
+		Qualifiers:         qualifiers,
+		Version:            version,
+		Language:           language,
+		NumberOfExpansions: number_of_expansions,
+	}
+
+	// First try using the GUID then using the name if possible.
+	var err error
+	for _, name := range []string{provider_guid, provider} {
+		if name == "" {
+			continue
+		}
+
+		request.Provider = name
+		result, lookup_err := v2.LookupMessage(ctx, request)
+		if lookup_err == nil {
+			return result, name, nil
+		}
+		err = chainError(err, lookup_err)
+	}
+
+	if rendered != nil {
+		return rendered, provider, nil
+	}
+	return nil, "", errors.Wrapf(err, "%v event %v", request.Provider, event_id)
+}
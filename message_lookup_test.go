@@ -0,0 +1,121 @@
+package evtx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	errors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// A resolver which always fails.
+type failingResolver struct {
+	NullResolver
+}
+
+func (self failingResolver) LookupMessage(
+	ctx context.Context, request *MessageRequest) (*MessageResult, error) {
+	return nil, errors.New("Database is locked")
+}
+
+func (self failingResolver) LookupParameter(
+	ctx context.Context, request *ParameterRequest) (*MessageResult, error) {
+	return nil, errors.New("Database is locked")
+}
+
+func testMessageEvent(provider string, event_id int) *ordereddict.Dict {
+	return ordereddict.NewDict().
+		Set("System", ordereddict.NewDict().
+			Set("Provider", ordereddict.NewDict().Set("Name", provider)).
+			Set("EventID", ordereddict.NewDict().Set("Value", event_id)).
+			Set("Channel", "System")).
+		Set("EventData", ordereddict.NewDict().Set("Name", "Spooler"))
+}
+
+type namedResolver struct {
+	*messageSetResolver
+}
+
+func (self *namedResolver) MessageSource() string {
+	return "named"
+}
+
+func TestMessageResolverAdapters(t *testing.T) {
+	ctx := context.Background()
+	set := &MessageSet{
+		Messages:   make(map[int]string),
+		Parameters: map[int]string{1833: "Impersonation"},
+	}
+	set.AddMessage(7036, "The %1 service entered the %2 state.")
+	legacy := &messageSetResolver{set: set}
+
+	v2 := AsMessageResolverV2(legacy)
+	result, err := v2.LookupMessage(ctx, &MessageRequest{
+		Provider: "Provider", EventId: 7036, Qualifiers: -1, Version: -1,
+		NumberOfExpansions: 1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "The %1 service entered the %2 state.", result.Message)
+	assert.Equal(t, UNNAMED_MESSAGE_SOURCE, result.Source)
+
+	_, err = v2.LookupMessage(ctx, &MessageRequest{
+		Provider: "Provider", EventId: 1, Qualifiers: -1, Version: -1,
+	})
+	assert.True(t, errors.Is(err, ErrMessageNotFound))
+
+	result, err = v2.LookupParameter(ctx, &ParameterRequest{ParameterId: 1833})
+	assert.NoError(t, err)
+	assert.Equal(t, "Impersonation", result.Message)
+
+	// Resolvers may name themselves.
+	result, err = AsMessageResolverV2(&namedResolver{legacy}).LookupParameter(
+		ctx, &ParameterRequest{ParameterId: 1833})
+	assert.NoError(t, err)
+	assert.Equal(t, "named", result.Source)
+
+	// Converting back returns the original resolver.
+	assert.Equal(t, MessageResolver(legacy), AsMessageResolver(v2))
+
+	// A cancelled context stops the lookup.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = v2.LookupMessage(cancelled, &MessageRequest{
+		Provider: "Provider", EventId: 7036, Qualifiers: -1, Version: -1,
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, IsNotFound(err))
+}
+
+func TestChainResolverErrors(t *testing.T) {
+	ctx := context.Background()
+	request := &MessageRequest{
+		Provider: "Provider", EventId: 2, Qualifiers: -1, Version: -1,
+	}
+
+	known := NewMapResolver()
+	known.AddProvider(&MapProvider{
+		Name:     "Provider",
+		Messages: map[int]string{1: "Known"},
+	})
+
+	// No resolver knows the provider.
+	_, err := NewChainResolver(NewMapResolver()).LookupMessage(ctx, request)
+	assert.True(t, errors.Is(err, ErrProviderNotFound))
+
+	// The provider is known but not the message.
+	_, err = NewChainResolver(NewMapResolver(), known).LookupMessage(ctx, request)
+	assert.True(t, errors.Is(err, ErrMessageNotFound))
+
+	// Failures are reported rather than not finding the message.
+	_, err = NewChainResolver(known, failingResolver{}).LookupMessage(ctx, request)
+	assert.Error(t, err)
+	assert.False(t, IsNotFound(err))
+
+	// But a message found later in the chain wins.
+	request.EventId = 1
+	result, err := NewChainResolver(failingResolver{}, known).LookupMessage(ctx, request)
+	assert.NoError(t, err)
+	assert.Equal(t, "Known", result.Message)
+	assert.Equal(t, "map", result.Source)
+}
@@ -16,6 +16,10 @@ type MessageSet struct {
 	Channel    string
 	Messages   map[int]string
 	Parameters map[int]string
+
+	// Messages by their full 32 bit message id, including the
+	// qualifiers of classic events.
+	MessagesById map[int64]string
 }
 
 func (self *MessageSet) AddMessage(event_id int, message string) {
@@ -28,6 +32,20 @@ func (self *MessageSet) AddMessage(event_id int, message string) {
 	self.Messages[key] = message
 }
 
+// AddMessageWithId adds a message by its full message id. The event
+// id is the low 16 bits of the message id.
+func (self *MessageSet) AddMessageWithId(id int64, message string) {
+	self.AddMessage(int(id&0xffff), message)
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.MessagesById == nil {
+		self.MessagesById = make(map[int64]string)
+	}
+	self.MessagesById[id] = message
+}
+
 func (self *MessageSet) AddParameter(event_id int, message string) {
 	self.mu.Lock()
 	defer self.mu.Unlock()
@@ -77,3 +95,21 @@ func (self *MessageSet) GetBestMessage(
 	}
 	return ""
 }
+
+// GetQualifiedMessage prefers the message with the full message id
+// made of the qualifiers (-1 if not known) and the event id. Other
+// messages for the event id are used otherwise.
+func (self *MessageSet) GetQualifiedMessage(
+	qualifiers, event_id, number_of_expansions int) string {
+	if qualifiers >= 0 {
+		self.mu.Lock()
+		res, pres := self.MessagesById[QualifiedMessageId(qualifiers, event_id)]
+		self.mu.Unlock()
+
+		if pres {
+			return res
+		}
+	}
+
+	return self.GetBestMessage(event_id, number_of_expansions)
+}
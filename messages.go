@@ -1,7 +1,7 @@
 package evtx
 
 import (
-	"fmt"
+	"context"
 	"regexp"
 	"strconv"
 
@@ -9,7 +9,6 @@ import (
 )
 
 var (
-	expansion_re    = regexp.MustCompile(`\%[0-9ntr]+`)
 	parameter_re    = regexp.MustCompile(`^\%\%([0-9]+)`)
 	system_root_re  = regexp.MustCompile("(?i)%?SystemRoot%?")
 	windir_re       = regexp.MustCompile("(?i)%windir%")
@@ -23,6 +22,53 @@ type MessageResolver interface {
 	Close()
 }
 
+// VersionedMessageResolver is implemented by resolvers which can pick
+// the message text for a particular version of the event. The
+// version is -1 when it is not known.
+type VersionedMessageResolver interface {
+	MessageResolver
+	GetVersionedMessage(provider, channel string,
+		event_id, version, number_of_expansions int) string
+}
+
+// QualifiedMessageResolver is implemented by resolvers which can look
+// up the messages of classic events by their full 32 bit message id,
+// including the severity, customer and facility bits found in
+// System.EventID.Qualifiers. The qualifiers are -1 when not known.
+type QualifiedMessageResolver interface {
+	MessageResolver
+	GetQualifiedMessage(provider, channel string,
+		qualifiers, event_id, version, number_of_expansions int) string
+}
+
+// QualifiedMessageId returns the message id of a classic event: the
+// qualifiers are the high 16 bits of the id.
+func QualifiedMessageId(qualifiers, event_id int) int64 {
+	return int64(uint32(qualifiers)<<16 | uint32(event_id)&0xffff)
+}
+
+// The languages of the MUI files searched for messages, in order of
+// preference.
+var MUILanguages = []string{"en-US"}
+
+// Look up the message using the most specific method the resolver
+// supports.
+func lookupMessage(resolver MessageResolver, provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	qualified, ok := resolver.(QualifiedMessageResolver)
+	if ok {
+		return qualified.GetQualifiedMessage(provider, channel,
+			qualifiers, event_id, version, number_of_expansions)
+	}
+
+	versioned, ok := resolver.(VersionedMessageResolver)
+	if ok {
+		return versioned.GetVersionedMessage(
+			provider, channel, event_id, version, number_of_expansions)
+	}
+	return resolver.GetMessage(provider, channel, event_id, number_of_expansions)
+}
+
 type NullResolver struct{}
 
 func (self NullResolver) GetMessage(provider, channel string, event_id, number_of_expansions int) string {
@@ -60,74 +106,54 @@ func flatten(dict *ordereddict.Dict) []interface{} {
 	return result
 }
 
+// Arguments like %%1234 refer to parameter strings of the provider.
 func maybeExpandObjects(provider, channel string,
-	item interface{}, resolver MessageResolver) string {
+	item interface{}, resolver MessageResolver) interface{} {
 	item_str, ok := item.(string)
 	if !ok {
-		return fmt.Sprintf("%v", item)
+		return item
 	}
 
 	matches := parameter_re.FindStringSubmatch(item_str)
 	if len(matches) < 2 {
-		return fmt.Sprintf("%v", item)
+		return item
 	}
 
 	param_id, err := strconv.Atoi(matches[1])
 	if err != nil {
-		return fmt.Sprintf("%v", item)
+		return item
 	}
 
 	return resolver.GetParameter(provider, channel, param_id)
 }
 
+// ExpandMessage formats the event's message using the flattened
+// UserData or EventData as the inserts.
 func ExpandMessage(
 	event *ordereddict.Dict, resolver MessageResolver) string {
+	return ExpandMessageWithArgs(event, nil, resolver)
+}
 
-	// Now get and flatten the user data or event data
+// ExpandMessageWithArgs formats the event's message using the
+// record's substitution values (EventRecord.MessageArgs) as the
+// inserts. If there are none we fall back to the flattened UserData
+// or EventData. Use ExpandMessageWithContext() to find out why a
+// message could not be resolved.
+func ExpandMessageWithArgs(event *ordereddict.Dict,
+	args []interface{}, resolver MessageResolver) string {
+	result, err := ExpandMessageWithContext(
+		context.Background(), event, args, resolver, "", false)
+	if err != nil {
+		return ""
+	}
+	return result.Message
+}
+
+// The user data or event data which is flattened into inserts.
+func getMessageData(event *ordereddict.Dict) *ordereddict.Dict {
 	data, pres := ordereddict.GetMap(event, "UserData")
 	if !pres {
 		data, _ = ordereddict.GetMap(event, "EventData")
 	}
-	expansions := flatten(data)
-
-	provider, _ := ordereddict.GetString(event, "System.Provider.Name")
-	provider_guid, _ := ordereddict.GetString(event, "System.Provider.Guid")
-	channel, _ := ordereddict.GetString(event, "System.Channel")
-	event_id, _ := ordereddict.GetInt(event, "System.EventID.Value")
-
-	// Get the raw message. First try using the GUID then using the
-	// name if possible.
-	message := resolver.GetMessage(provider_guid, channel, event_id, len(expansions))
-	if message == "" {
-		message = resolver.GetMessage(provider, channel, event_id, len(expansions))
-		if message == "" {
-			// No raw message string, just return.
-			return message
-		}
-
-	} else {
-		provider = provider_guid
-	}
-
-	// Replace expansions in the message with the user data.
-	return expansion_re.ReplaceAllStringFunc(message, func(match string) string {
-		switch match {
-		case "%n":
-			return "\n"
-		case "%r":
-			return ""
-		case "%t":
-			return "\t"
-		}
-
-		number, _ := strconv.Atoi(match[1:])
-
-		// Regex expansions start at 1
-		number -= 1
-		if number >= 0 && number < len(expansions) {
-			return maybeExpandObjects(
-				provider, channel, expansions[number], resolver)
-		}
-		return match
-	})
+	return data
 }
@@ -1,56 +1,817 @@
 package evtx
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	errors "github.com/pkg/errors"
+)
+
+const (
+	// The schema version of the message database, stored in
+	// PRAGMA user_version. Version 0 databases only have provider
+	// names and messages.
+	MESSAGE_DB_VERSION = 3
 )
 
+// Each migration brings the database from the previous version to
+// the next.
+var message_db_migrations = []string{
+	// Version 1: provider GUIDs, channels and parameter messages.
+	`
+    CREATE TABLE IF NOT EXISTS providers (
+         id INTEGER PRIMARY KEY,
+         name TEXT);
+
+    CREATE TABLE IF NOT EXISTS messages (
+         id INTEGER NOT NULL,
+         event_id INTEGER NOT NULL,
+         provider_id INTEGER NOT NULL,
+         message TEXT
+    );
+
+    CREATE INDEX IF NOT EXISTS message_idx
+    ON messages (event_id, provider_id);
+
+    ALTER TABLE providers ADD COLUMN guid TEXT NOT NULL DEFAULT '';
+    ALTER TABLE providers ADD COLUMN channel TEXT NOT NULL DEFAULT '';
+
+    CREATE INDEX IF NOT EXISTS provider_name_idx
+    ON providers (name COLLATE NOCASE);
+
+    CREATE INDEX IF NOT EXISTS provider_guid_idx
+    ON providers (guid);
+
+    CREATE TABLE IF NOT EXISTS parameters (
+         id INTEGER NOT NULL,
+         provider_id INTEGER NOT NULL,
+         message TEXT
+    );
+
+    CREATE INDEX IF NOT EXISTS parameter_idx
+    ON parameters (id, provider_id);
+`,
+
+	// Version 2: the language of the message (empty if not known),
+	// the event version it applies to (NULL for all versions) and
+	// the Windows build number it was extracted from (0 if not
+	// known).
+	`
+    ALTER TABLE messages ADD COLUMN language TEXT NOT NULL DEFAULT '';
+    ALTER TABLE messages ADD COLUMN event_version INTEGER;
+    ALTER TABLE messages ADD COLUMN build INTEGER NOT NULL DEFAULT 0;
+
+    ALTER TABLE parameters ADD COLUMN language TEXT NOT NULL DEFAULT '';
+    ALTER TABLE parameters ADD COLUMN build INTEGER NOT NULL DEFAULT 0;
+`,
+
+	// Version 3: the event definitions of provider manifests, the
+	// names of their data fields and the names of the provider's
+	// channels, levels, tasks, opcodes and keywords.
+	`
+    CREATE TABLE IF NOT EXISTS events (
+         provider_id INTEGER NOT NULL,
+         event_id INTEGER NOT NULL,
+         version INTEGER NOT NULL,
+         channel TEXT NOT NULL DEFAULT '',
+         level INTEGER NOT NULL DEFAULT 0,
+         task INTEGER NOT NULL DEFAULT 0,
+         opcode INTEGER NOT NULL DEFAULT 0,
+         keywords INTEGER NOT NULL DEFAULT 0,
+         message_id INTEGER,
+         build INTEGER NOT NULL DEFAULT 0
+    );
+
+    CREATE INDEX IF NOT EXISTS event_idx
+    ON events (event_id, provider_id);
+
+    CREATE TABLE IF NOT EXISTS event_fields (
+         provider_id INTEGER NOT NULL,
+         event_id INTEGER NOT NULL,
+         version INTEGER NOT NULL,
+         position INTEGER NOT NULL,
+         name TEXT NOT NULL,
+         in_type INTEGER NOT NULL DEFAULT 0,
+         out_type INTEGER NOT NULL DEFAULT 0,
+         build INTEGER NOT NULL DEFAULT 0
+    );
+
+    CREATE INDEX IF NOT EXISTS event_field_idx
+    ON event_fields (event_id, provider_id);
+
+    CREATE TABLE IF NOT EXISTS provider_values (
+         provider_id INTEGER NOT NULL,
+         kind TEXT NOT NULL,
+         value INTEGER NOT NULL,
+         task INTEGER NOT NULL DEFAULT 0,
+         name TEXT NOT NULL DEFAULT '',
+         message_id INTEGER,
+         build INTEGER NOT NULL DEFAULT 0
+    );
+
+    CREATE INDEX IF NOT EXISTS provider_value_idx
+    ON provider_values (provider_id, kind, value);
+`,
+}
+
+// CheckSQLiteSupport returns an error explaining how to convert the
+// message database if this build can not read it.
+func CheckSQLiteSupport(filename string) error {
+	if SQLITE_SUPPORTED {
+		return nil
+	}
+	return errors.Errorf("%v is a SQLite message database which this build "+
+		"(without cgo) can not read. Convert it to a message bundle with "+
+		"`dumpevtx bundle %v messages.bundle` using a build with cgo",
+		filename, filename)
+}
+
+// OpenMessageDB opens a message database for writing, creating it
+// if needed. Databases in an older schema are not changed: they must
+// be upgraded with MigrateMessageDB() (the migrate command) first.
+func OpenMessageDB(filename string) (*sql.DB, error) {
+	err := CheckSQLiteSupport(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// A new database gets the current schema.
+	tables := 0
+	err = database.QueryRow("SELECT count(*) FROM sqlite_master").Scan(&tables)
+	if err == nil {
+		if tables == 0 {
+			err = MigrateMessageDB(database)
+		} else {
+			err = checkMessageDBVersion(database, filename)
+		}
+	}
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return database, nil
+}
+
+// OpenMessageDBReadOnly opens an existing message database without
+// ever writing to it, so databases on read-only mounts can be used.
+func OpenMessageDBReadOnly(filename string) (*sql.DB, error) {
+	err := CheckSQLiteSupport(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite would otherwise create an empty database.
+	_, err = os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// Characters with a meaning in SQLite URIs must be escaped.
+	path := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").
+		Replace(filepath.ToSlash(filename))
+	database, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkMessageDBVersion(database, filename)
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return database, nil
+}
+
+func checkMessageDBVersion(database *sql.DB, filename string) error {
+	version, err := GetMessageDBVersion(database)
+	if err != nil {
+		return errors.Wrap(err, filename)
+	}
+
+	if version > MESSAGE_DB_VERSION {
+		return errors.Errorf(
+			"Message database %v version %v is newer than supported (%v)",
+			filename, version, MESSAGE_DB_VERSION)
+	}
+
+	if version < MESSAGE_DB_VERSION {
+		return errors.Errorf(
+			"Message database %v is at schema version %v, upgrade it to "+
+				"version %v with `dumpevtx migrate %v`",
+			filename, version, MESSAGE_DB_VERSION, filename)
+	}
+	return nil
+}
+
+// GetMessageDBVersion returns the schema version of the database.
+func GetMessageDBVersion(database *sql.DB) (int, error) {
+	version := 0
+	err := database.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// MigrateMessageDB brings the database up to the current schema.
+func MigrateMessageDB(database *sql.DB) error {
+	version, err := GetMessageDBVersion(database)
+	if err != nil {
+		return err
+	}
+
+	if version > MESSAGE_DB_VERSION {
+		return errors.Errorf(
+			"Message database version %v is newer than supported (%v)",
+			version, MESSAGE_DB_VERSION)
+	}
+
+	for ; version < MESSAGE_DB_VERSION; version++ {
+		tx, err := database.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(message_db_migrations[version])
+		if err == nil {
+			// PRAGMA does not take parameters.
+			_, err = tx.Exec(fmt.Sprintf(
+				"PRAGMA user_version = %d", version+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "Migrating message database to version %v",
+				version+1)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Provider GUIDs are stored in upper case without braces.
+func NormalizeGUID(guid string) string {
+	return strings.ToUpper(strings.Trim(guid, "{}"))
+}
+
 type DBResolver struct {
-	db    *sql.DB
-	query *sql.Stmt
+	filename string
+
+	db              *sql.DB
+	query           *sql.Stmt
+	parameter_query *sql.Stmt
+	fields_query    *sql.Stmt
+	value_query     *sql.Stmt
+	provider_query  *sql.Stmt
+
+	language string
+	build    int
+}
+
+// SetLanguage sets the preferred language of messages (e.g. de-DE).
+// Messages in the same primary language (de) are preferred next,
+// then English ones.
+func (self *DBResolver) SetLanguage(language string) {
+	self.language = language
+}
+
+// SetBuild prefers messages extracted from this Windows build. By
+// default messages from the newest build are used.
+func (self *DBResolver) SetBuild(build int) {
+	self.build = build
+}
+
+// The language to use for the request.
+func (self *DBResolver) getLanguage(language string) string {
+	if language != "" {
+		return language
+	}
+	return self.language
+}
+
+func primaryLanguage(language string) string {
+	primary := strings.SplitN(language, "-", 2)[0]
+	if primary == "" {
+		return "en"
+	}
+	return primary
+}
+
+// The provider may be given by name or GUID. A GUID also matches
+// providers registered under the same name without a GUID (e.g. a
+// legacy EventLog source).
+const provider_match = `
+    (providers.name = ?1 COLLATE NOCASE OR providers.guid = ?2 OR
+     providers.name IN (SELECT name FROM providers WHERE guid = ?2 AND guid != ''))
+`
+
+// Providers registered for the event's channel are preferred, then
+// the chosen language, the same primary language, English and
+// messages of unknown language. Finally the chosen build or the
+// newest one.
+func messageOrder(table string) string {
+	return `
+    providers.channel = ?3 COLLATE NOCASE DESC,
+    ` + table + `.language = ?5 COLLATE NOCASE DESC,
+    (` + table + `.language = ?6 COLLATE NOCASE OR
+     ` + table + `.language LIKE ?6 || '-%') DESC,
+    ` + table + `.language LIKE 'en-%' DESC,
+    ` + table + `.language = '' DESC,
+    ` + table + `.build = ?7 DESC,
+    ` + table + `.build DESC
+`
 }
 
-// TODO: What is happening with the channel here?
 func (self *DBResolver) GetMessage(
 	provider, channel string, event_id, number_of_expansions int) string {
-	rows, err := self.query.Query(provider, event_id)
+	return self.GetVersionedMessage(
+		provider, channel, event_id, -1, number_of_expansions)
+}
+
+// GetVersionedMessage prefers messages for this version of the
+// event, then messages for all versions and then messages for older
+// versions.
+func (self *DBResolver) GetVersionedMessage(provider, channel string,
+	event_id, version, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id,
+		version, number_of_expansions)
+}
+
+// GetQualifiedMessage prefers the message whose full message id
+// matches the qualifiers of a classic event. Message tables may hold
+// several messages for the same event id with different severity or
+// facility bits.
+func (self *DBResolver) GetQualifiedMessage(provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	return getMessageV2(self, provider, channel, qualifiers, event_id,
+		version, number_of_expansions)
+}
+
+// Returns the first column of the first row. The first two
+// arguments are the provider's name and GUID.
+func (self *DBResolver) queryMessage(ctx context.Context,
+	stmt *sql.Stmt, args ...interface{}) (*MessageResult, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		return ""
+		return nil, errors.Wrap(err, self.filename)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		err = rows.Err()
+		if err != nil {
+			return nil, errors.Wrap(err, self.filename)
+		}
+		return nil, self.notFound(ctx, args[0], args[1])
+	}
+
+	var message sql.NullString
+	err = rows.Scan(&message)
+	if err != nil {
+		return nil, errors.Wrap(err, self.filename)
 	}
 
+	return &MessageResult{
+		Message: message.String,
+		Source:  "db:" + self.filename,
+	}, nil
+}
+
+// Tells if the provider or only the message is missing.
+func (self *DBResolver) notFound(ctx context.Context, name, guid interface{}) error {
+	var found int
+	err := self.provider_query.QueryRowContext(ctx, name, guid).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProviderNotFound
+	}
+	if err != nil {
+		return errors.Wrap(err, self.filename)
+	}
+	return ErrMessageNotFound
+}
+
+func (self *DBResolver) LookupMessage(
+	ctx context.Context, request *MessageRequest) (*MessageResult, error) {
+	message_id := int64(-1)
+	if request.Qualifiers >= 0 {
+		message_id = QualifiedMessageId(request.Qualifiers, request.EventId)
+	}
+
+	language := self.getLanguage(request.Language)
+	return self.queryMessage(ctx, self.query, request.Provider,
+		NormalizeGUID(request.Provider), request.Channel, request.EventId,
+		language, primaryLanguage(language), self.build, request.Version,
+		message_id)
+}
+
+func (self *DBResolver) GetParameter(provider, channel string, parameter_id int) string {
+	return getParameterV2(self, provider, channel, parameter_id)
+}
+
+func (self *DBResolver) LookupParameter(
+	ctx context.Context, request *ParameterRequest) (*MessageResult, error) {
+	language := self.getLanguage(request.Language)
+	return self.queryMessage(ctx, self.parameter_query, request.Provider,
+		NormalizeGUID(request.Provider), request.Channel, request.ParameterId,
+		language, primaryLanguage(language), self.build)
+}
+
+// GetEventFields returns the names of the event's data fields from
+// the provider's manifest. Definitions of the same version, or else
+// the newest older version, from the chosen or newest build are
+// preferred.
+func (self *DBResolver) GetEventFields(provider, channel string,
+	event_id, version int) []string {
+	rows, err := self.fields_query.Query(provider, NormalizeGUID(provider),
+		channel, event_id, version, self.build)
+	if err != nil {
+		return nil
+	}
 	defer rows.Close()
 
+	result := []string{}
+	first := true
+	var chosen_version, chosen_build, chosen_provider int64
 	for rows.Next() {
-		var message string
-		err = rows.Scan(&message)
-		if err == nil {
-			return message
+		var name string
+		var row_version, build, provider_id, position int64
+		err = rows.Scan(&name, &row_version, &build, &provider_id, &position)
+		if err != nil {
+			return nil
+		}
+
+		// Only use the fields of the best definition.
+		if first {
+			chosen_version, chosen_build, chosen_provider =
+				row_version, build, provider_id
+			first = false
+		}
+
+		if row_version != chosen_version || build != chosen_build ||
+			provider_id != chosen_provider {
+			break
 		}
+		result = append(result, name)
 	}
-	return ""
+
+	if len(result) == 0 {
+		return nil
+	}
+	return result
 }
 
-func (self *DBResolver) GetParameter(provider, channel string, parameter_id int) string {
+// GetProviderValueName returns the localized name of the provider's
+// level, task, opcode or keyword, or its name in the manifest if
+// there is no message.
+func (self *DBResolver) GetProviderValueName(provider, channel, kind string,
+	value uint64, task int) string {
+	rows, err := self.value_query.Query(provider, NormalizeGUID(provider),
+		channel, kind, self.language, primaryLanguage(self.language), self.build,
+		int64(value), task)
+	if err != nil {
+		return ""
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err == nil && name != "" {
+			return name
+		}
+	}
 	return ""
 }
 
 func (self *DBResolver) Close() {
+	self.query.Close()
+	self.parameter_query.Close()
+	self.fields_query.Close()
+	self.value_query.Close()
+	self.provider_query.Close()
 	self.db.Close()
 }
 
+// NewDBResolver opens a message database produced by the extract
+// command read-only. Older databases must be migrated first.
 func NewDBResolver(message_file string) (*DBResolver, error) {
-	database, err := sql.Open("sqlite3", message_file)
+	database, err := OpenMessageDBReadOnly(message_file)
 	if err != nil {
 		return nil, err
 	}
+
 	query, err := database.Prepare(`
           SELECT message
           FROM messages left join providers ON messages.provider_id = providers.id
-          WHERE providers.name = ? and messages.event_id = ?
+          WHERE ` + provider_match + ` AND messages.event_id = ?4
+          ORDER BY messages.id = ?9 DESC,
+                   messages.event_version = ?8 DESC,
+                   messages.event_version IS NULL DESC,
+                   messages.event_version < ?8 DESC,
+                   ` + messageOrder("messages") + `,
+                   messages.event_version DESC
+               `)
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	parameter_query, err := database.Prepare(`
+          SELECT message
+          FROM parameters left join providers ON parameters.provider_id = providers.id
+          WHERE ` + provider_match + ` AND parameters.id = ?4
+          ORDER BY ` + messageOrder("parameters") + `
+               `)
+	if err != nil {
+		query.Close()
+		database.Close()
+		return nil, err
+	}
+
+	fields_query, err := database.Prepare(`
+          SELECT event_fields.name, event_fields.version, event_fields.build,
+                 event_fields.provider_id, event_fields.position
+          FROM event_fields left join providers ON event_fields.provider_id = providers.id
+          WHERE ` + provider_match + ` AND event_fields.event_id = ?4
+          ORDER BY event_fields.version = ?5 DESC,
+                   event_fields.version < ?5 DESC,
+                   event_fields.version DESC,
+                   providers.channel = ?3 COLLATE NOCASE DESC,
+                   event_fields.build = ?6 DESC,
+                   event_fields.build DESC,
+                   event_fields.provider_id,
+                   event_fields.position
+               `)
+	if err != nil {
+		query.Close()
+		parameter_query.Close()
+		database.Close()
+		return nil, err
+	}
+
+	value_query, err := database.Prepare(`
+          SELECT COALESCE(messages.message, provider_values.name)
+          FROM provider_values left join providers ON provider_values.provider_id = providers.id
+          LEFT JOIN messages ON messages.provider_id = provider_values.provider_id AND
+                                messages.id = provider_values.message_id
+          WHERE ` + provider_match + ` AND provider_values.kind = ?4 AND
+                provider_values.value = ?8 AND provider_values.task IN (0, ?9)
+          ORDER BY provider_values.task = ?9 DESC,
+                   messages.message IS NOT NULL DESC,
+                   ` + messageOrder("messages") + `,
+                   provider_values.build = ?7 DESC,
+                   provider_values.build DESC
                `)
+	if err != nil {
+		query.Close()
+		parameter_query.Close()
+		fields_query.Close()
+		database.Close()
+		return nil, err
+	}
+
+	provider_query, err := database.Prepare(`
+          SELECT 1 FROM providers WHERE ` + provider_match + ` LIMIT 1`)
+	if err != nil {
+		query.Close()
+		parameter_query.Close()
+		fields_query.Close()
+		value_query.Close()
+		database.Close()
+		return nil, err
+	}
+
+	return &DBResolver{
+		filename:        message_file,
+		db:              database,
+		query:           query,
+		parameter_query: parameter_query,
+		fields_query:    fields_query,
+		value_query:     value_query,
+		provider_query:  provider_query,
+	}, nil
+}
+
+// MessageDBWriter adds providers and their messages to a message
+// database. All the writes happen in a single transaction which is
+// committed on Close().
+type MessageDBWriter struct {
+	db *sql.DB
+	tx *sql.Tx
+
+	// Provider ids by name, GUID and channel.
+	providers map[string]int64
+
+	// The Windows build number the messages come from (0 if not
+	// known).
+	Build int
+
+	insert_provider       *sql.Stmt
+	insert_message        *sql.Stmt
+	insert_parameter      *sql.Stmt
+	insert_event          *sql.Stmt
+	insert_event_field    *sql.Stmt
+	insert_provider_value *sql.Stmt
+}
+
+func NewMessageDBWriter(filename string) (*MessageDBWriter, error) {
+	database, err := OpenMessageDB(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	self := &MessageDBWriter{
+		db:        database,
+		providers: make(map[string]int64),
+	}
 
+	self.tx, err = database.Begin()
 	if err != nil {
+		database.Close()
 		return nil, err
 	}
 
-	return &DBResolver{db: database, query: query}, nil
+	// Reuse the providers already in the database.
+	rows, err := self.tx.Query("SELECT id, name, guid, channel FROM providers")
+	if err != nil {
+		self.Abort()
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var name, guid, channel string
+		if rows.Scan(&id, &name, &guid, &channel) == nil {
+			self.providers[providerKey(name, guid, channel)] = id
+		}
+	}
+	rows.Close()
+
+	for _, stmt := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&self.insert_provider, "INSERT INTO providers (name, guid, channel) VALUES (?, ?, ?)"},
+		{&self.insert_message, `INSERT INTO messages (id, event_id, provider_id, message,
+              language, event_version, build) VALUES (?, ?, ?, ?, ?, ?, ?)`},
+		{&self.insert_parameter, `INSERT INTO parameters (id, provider_id, message,
+              language, build) VALUES (?, ?, ?, ?, ?)`},
+		{&self.insert_event, `INSERT INTO events (provider_id, event_id, version,
+              channel, level, task, opcode, keywords, message_id, build)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&self.insert_event_field, `INSERT INTO event_fields (provider_id, event_id,
+              version, position, name, in_type, out_type, build)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&self.insert_provider_value, `INSERT INTO provider_values (provider_id,
+              kind, value, task, name, message_id, build)
+              VALUES (?, ?, ?, ?, ?, ?, ?)`},
+	} {
+		*stmt.stmt, err = self.tx.Prepare(stmt.query)
+		if err != nil {
+			self.Abort()
+			return nil, err
+		}
+	}
+
+	return self, nil
+}
+
+func providerKey(name, guid, channel string) string {
+	return strings.ToLower(name) + "|" + NormalizeGUID(guid) + "|" +
+		strings.ToLower(channel)
+}
+
+// AddProvider returns the id of the provider, adding it if
+// needed. The GUID and channel may be empty.
+func (self *MessageDBWriter) AddProvider(name, guid, channel string) (int64, error) {
+	key := providerKey(name, guid, channel)
+	id, pres := self.providers[key]
+	if pres {
+		return id, nil
+	}
+
+	res, err := self.insert_provider.Exec(name, NormalizeGUID(guid), channel)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err = res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	self.providers[key] = id
+	return id, nil
+}
+
+// AddMessage adds a message of unknown language for all versions of
+// the event.
+func (self *MessageDBWriter) AddMessage(
+	provider_id int64, id int64, event_id int, message string) error {
+	return self.AddLocalizedMessage(provider_id, id, event_id, -1, "", message)
+}
+
+// AddLocalizedMessage adds a message in the language (e.g. en-US)
+// for a version of the event. A version of -1 applies to all
+// versions.
+func (self *MessageDBWriter) AddLocalizedMessage(
+	provider_id int64, id int64, event_id, version int,
+	language, message string) error {
+	event_version := sql.NullInt64{Int64: int64(version), Valid: version >= 0}
+	_, err := self.insert_message.Exec(id, event_id, provider_id, message,
+		language, event_version, self.Build)
+	return err
+}
+
+func (self *MessageDBWriter) AddParameter(
+	provider_id int64, id int64, message string) error {
+	return self.AddLocalizedParameter(provider_id, id, "", message)
+}
+
+func (self *MessageDBWriter) AddLocalizedParameter(
+	provider_id int64, id int64, language, message string) error {
+	_, err := self.insert_parameter.Exec(id, provider_id, message,
+		language, self.Build)
+	return err
+}
+
+func nullMessageId(message_id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: message_id, Valid: message_id >= 0}
+}
+
+// AddEvent adds the definition of an event from the provider's
+// manifest, including the names of its data fields.
+func (self *MessageDBWriter) AddEvent(
+	provider_id int64, channel string, event *WEVTEvent) error {
+	_, err := self.insert_event.Exec(provider_id, event.Id, event.Version,
+		channel, event.Level, event.Task, event.Opcode,
+		int64(event.Keywords), nullMessageId(event.MessageId), self.Build)
+	if err != nil {
+		return err
+	}
+
+	if event.Template == nil {
+		return nil
+	}
+
+	for position, field := range event.Template.Fields {
+		_, err := self.insert_event_field.Exec(provider_id, event.Id,
+			event.Version, position, field.Name, field.InType,
+			field.OutType, self.Build)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddProviderValue adds a named channel, level, task, opcode or
+// keyword of the provider. The kind is the manifest element (e.g.
+// "level").
+func (self *MessageDBWriter) AddProviderValue(
+	provider_id int64, kind string, value *WEVTValue) error {
+	_, err := self.insert_provider_value.Exec(provider_id, kind,
+		int64(value.Value), int64(value.Task), value.Name,
+		nullMessageId(value.MessageId), self.Build)
+	return err
+}
+
+// Close commits the writes and closes the database.
+func (self *MessageDBWriter) Close() error {
+	self.closeStatements()
+
+	err := self.tx.Commit()
+	self.db.Close()
+	return err
+}
+
+// Abort rolls back the writes and closes the database.
+func (self *MessageDBWriter) Abort() error {
+	self.closeStatements()
+
+	err := self.tx.Rollback()
+	self.db.Close()
+	return err
+}
+
+func (self *MessageDBWriter) closeStatements() {
+	for _, stmt := range []*sql.Stmt{
+		self.insert_provider, self.insert_message, self.insert_parameter,
+		self.insert_event, self.insert_event_field,
+		self.insert_provider_value} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
 }
@@ -0,0 +1,532 @@
+//go:build cgo
+// +build cgo
+
+// Message databases need the cgo sqlite driver. Tests of builds
+// without cgo use message bundles.
+
+package evtx
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	_ "github.com/mattn/go-sqlite3"
+	errors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageDBMigration(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "messages.db")
+
+	// A database in the original schema.
+	database, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.Exec(`
+    CREATE TABLE providers (id INTEGER PRIMARY KEY, name TEXT);
+    CREATE TABLE messages (id INTEGER NOT NULL, event_id INTEGER NOT NULL,
+         provider_id INTEGER NOT NULL, message TEXT);
+    CREATE INDEX message_idx ON messages (event_id, provider_id);
+    INSERT INTO providers (id, name) VALUES (1, 'Microsoft-Windows-Security-Auditing');
+    INSERT INTO messages VALUES (4624, 4624, 1, 'An account was logged on.');
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading or writing an old database does not change it.
+	_, err = NewDBResolver(filename)
+	assert.ErrorContains(t, err, "dumpevtx migrate")
+	_, err = MessageDBToBundle(filename)
+	assert.ErrorContains(t, err, "dumpevtx migrate")
+	_, err = NewMessageDBWriter(filename)
+	assert.ErrorContains(t, err, "dumpevtx migrate")
+
+	version, err := GetMessageDBVersion(database)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	require.NoError(t, MigrateMessageDB(database))
+	database.Close()
+
+	writer, err := NewMessageDBWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The publisher registration aliases the name to the GUID.
+	id, _ := writer.AddProvider("Microsoft-Windows-Security-Auditing",
+		"{54849625-5478-4994-a5ba-3e3b0328c30d}", "")
+	writer.AddParameter(id, 1833, "Impersonation\r\n")
+
+	// The same source registered in two channels.
+	id, _ = writer.AddProvider("Service", "", "Application")
+	writer.AddMessage(id, 1, 1, "Application message")
+	id, _ = writer.AddProvider("Service", "", "System")
+	writer.AddMessage(id, 1, 1, "System message")
+
+	// Messages in several languages and event versions.
+	id, _ = writer.AddProvider("Localized", "", "")
+	writer.AddLocalizedMessage(id, 1, 1, -1, "en-US", "English")
+	writer.AddLocalizedMessage(id, 1, 1, -1, "de-DE", "Deutsch")
+	writer.AddLocalizedMessage(id, 1, 1, -1, "ja-JP", "Japanese")
+	writer.AddLocalizedMessage(id, 2, 2, 0, "en-US", "Version 0")
+	writer.AddLocalizedMessage(id, 2, 2, 1, "en-US", "Version 1")
+	writer.AddLocalizedMessage(id, 2, 2, 3, "en-US", "Version 3")
+
+	writer.Build = 19041
+	writer.AddLocalizedMessage(id, 3, 3, -1, "en-US", "Newer build")
+	writer.Build = 9600
+	writer.AddLocalizedMessage(id, 3, 3, -1, "en-US", "Older build")
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := NewDBResolver(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resolver.Close()
+
+	version, _ = GetMessageDBVersion(resolver.db)
+	if version != MESSAGE_DB_VERSION {
+		t.Fatalf("Database not migrated: version %v", version)
+	}
+
+	for _, test := range []struct {
+		actual, expected string
+	}{
+		{resolver.GetMessage("54849625-5478-4994-A5BA-3E3B0328C30D",
+			"Security", 4624, 0), "An account was logged on."},
+		{resolver.GetParameter("54849625-5478-4994-A5BA-3E3B0328C30D",
+			"Security", 1833), "Impersonation\r\n"},
+		{resolver.GetParameter("Microsoft-Windows-Security-Auditing",
+			"Security", 1833), "Impersonation\r\n"},
+		{resolver.GetMessage("Service", "System", 1, 0), "System message"},
+		{resolver.GetMessage("service", "Application", 1, 0), "Application message"},
+		{resolver.GetMessage("Unknown", "System", 1, 0), ""},
+		{resolver.GetMessage("Localized", "", 1, 0), "English"},
+		{resolver.GetVersionedMessage("Localized", "", 2, 1, 0), "Version 1"},
+		{resolver.GetVersionedMessage("Localized", "", 2, 2, 0), "Version 1"},
+		{resolver.GetVersionedMessage("Localized", "", 2, 5, 0), "Version 3"},
+		{resolver.GetMessage("Localized", "", 2, 0), "Version 3"},
+		{resolver.GetMessage("Localized", "", 3, 0), "Newer build"},
+	} {
+		if test.actual != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, test.actual)
+		}
+	}
+
+	resolver.SetBuild(9600)
+	resolver.SetLanguage("de-AT")
+	for _, test := range []struct {
+		actual, expected string
+	}{
+		{resolver.GetMessage("Localized", "", 1, 0), "Deutsch"},
+		{resolver.GetMessage("Localized", "", 3, 0), "Older build"},
+	} {
+		if test.actual != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, test.actual)
+		}
+	}
+}
+
+func TestQualifiedDBMessages(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "messages.db")
+	writer, err := NewMessageDBWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := writer.AddProvider("Service Control Manager", "", "System")
+	writer.AddMessage(id, 0xC0001B7C, 7036, "The %1 service failed: %2")
+	writer.AddMessage(id, 0x40001B7C, 7036, "The %1 service entered the %2 state.")
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := NewDBResolver(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resolver.Close()
+
+	for _, test := range []struct {
+		qualifiers int
+		expected   string
+	}{
+		{0x4000, "The %1 service entered the %2 state."},
+		{0xC000, "The %1 service failed: %2"},
+	} {
+		message := resolver.GetQualifiedMessage("Service Control Manager",
+			"System", test.qualifiers, 7036, -1, 2)
+		if message != test.expected {
+			t.Fatalf("Qualifiers %#x: expected %q, got %q",
+				test.qualifiers, test.expected, message)
+		}
+	}
+
+	// Without qualifiers any message for the event id is used.
+	message := resolver.GetMessage("Service Control Manager", "System", 7036, 2)
+	if message == "" {
+		t.Fatalf("No message without qualifiers")
+	}
+}
+
+func writeTestMessageDB(t *testing.T, filename string) {
+	writer, err := NewMessageDBWriter(filename)
+	require.NoError(t, err)
+
+	id, _ := writer.AddProvider("Microsoft-Windows-Security-Auditing",
+		"{54849625-5478-4994-A5BA-3E3B0328C30D}", "")
+	writer.AddParameter(id, 1833, "Impersonation")
+
+	manifest, err := ParseWEVTTemplate(buildTestManifest())
+	require.NoError(t, err)
+	require.NoError(t, addManifestProvider(writer, id, manifest.Providers[0], nil))
+	writer.AddLocalizedMessage(id, 0x50000004, 4, -1, "de-DE", "Informationen")
+
+	// A legacy registration of the same provider name.
+	id, _ = writer.AddProvider("Microsoft-Windows-Security-Auditing", "", "Security")
+	writer.AddMessage(id, 4624, 4624, "An account was logged on.")
+
+	id, _ = writer.AddProvider("Service", "", "Application")
+	writer.AddMessage(id, 1, 1, "Application message")
+	id, _ = writer.AddProvider("Service", "", "System")
+	writer.AddMessage(id, 1, 1, "System message")
+	writer.AddMessage(id, 0xC0001B7C, 7036, "The %1 service failed: %2")
+	writer.AddMessage(id, 0x40001B7C, 7036, "The %1 service entered the %2 state.")
+
+	id, _ = writer.AddProvider("Localized", "", "")
+	writer.AddLocalizedMessage(id, 1, 1, -1, "en-US", "English")
+	writer.AddLocalizedMessage(id, 1, 1, -1, "de-DE", "Deutsch")
+	writer.AddLocalizedMessage(id, 2, 2, 0, "en-US", "Version 0")
+	writer.AddLocalizedMessage(id, 2, 2, 1, "en-US", "Version 1")
+	writer.AddLocalizedMessage(id, 2, 2, 3, "en-US", "Version 3")
+	writer.AddLocalizedParameter(id, 10, "en-US", "Parameter")
+	writer.AddLocalizedParameter(id, 10, "de-DE", "Parameter auf Deutsch")
+
+	writer.Build = 19041
+	writer.AddLocalizedMessage(id, 3, 3, -1, "en-US", "Newer build")
+	writer.Build = 9600
+	writer.AddLocalizedMessage(id, 3, 3, -1, "en-US", "Older build")
+
+	require.NoError(t, writer.Close())
+}
+
+func TestMessageBundle(t *testing.T) {
+	dir := t.TempDir()
+	db_filename := filepath.Join(dir, "messages.db")
+	writeTestMessageDB(t, db_filename)
+
+	bundle, err := MessageDBToBundle(db_filename)
+	require.NoError(t, err)
+
+	bundle_filename := filepath.Join(dir, "messages.bundle")
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, WriteMessageBundle(buffer, bundle))
+	assert.NoError(t, os.WriteFile(bundle_filename, buffer.Bytes(), 0600))
+
+	for filename, expected := range map[string]string{
+		db_filename:     MESSAGE_SOURCE_SQLITE,
+		bundle_filename: MESSAGE_SOURCE_BUNDLE,
+	} {
+		format, err := DetectMessageSourceFormat(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, format)
+	}
+
+	db_resolver, err := NewDBResolver(db_filename)
+	require.NoError(t, err)
+	defer db_resolver.Close()
+
+	bundle_resolver, err := LoadBundleResolver(bundle_filename)
+	require.NoError(t, err)
+
+	guid := "54849625-5478-4994-A5BA-3E3B0328C30D"
+
+	// The bundle must resolve the same messages as the database.
+	compare := func() {
+		for _, resolver := range []interface {
+			QualifiedMessageResolver
+			EventSchemaResolver
+			ProviderNameResolver
+		}{db_resolver, bundle_resolver} {
+			assert.Equal(t, "", resolver.GetMessage("Unknown", "System", 1, 0))
+		}
+
+		for _, test := range []struct {
+			provider, channel             string
+			qualifiers, event_id, version int
+		}{
+			{guid, "Security", -1, 4624, -1},
+			{guid, "Security", -1, 4624, 2},
+			{"Service", "System", -1, 1, -1},
+			{"service", "Application", -1, 1, -1},
+			{"Service", "System", 0x4000, 7036, -1},
+			{"Service", "System", 0xC000, 7036, -1},
+			{"Localized", "", -1, 1, -1},
+			{"Localized", "", -1, 2, 1},
+			{"Localized", "", -1, 2, 2},
+			{"Localized", "", -1, 2, 5},
+			{"Localized", "", -1, 2, -1},
+			{"Localized", "", -1, 3, -1},
+		} {
+			expected := db_resolver.GetQualifiedMessage(test.provider, test.channel,
+				test.qualifiers, test.event_id, test.version, 2)
+			assert.NotEqual(t, "", expected)
+			assert.Equal(t, expected, bundle_resolver.GetQualifiedMessage(
+				test.provider, test.channel, test.qualifiers, test.event_id,
+				test.version, 2), "%v", test)
+		}
+
+		for _, test := range []struct {
+			provider     string
+			parameter_id int
+		}{{guid, 1833}, {"Microsoft-Windows-Security-Auditing", 1833},
+			{"Localized", 10}, {"Localized", 11}} {
+			assert.Equal(t,
+				db_resolver.GetParameter(test.provider, "", test.parameter_id),
+				bundle_resolver.GetParameter(test.provider, "", test.parameter_id))
+		}
+
+		for _, version := range []int{-1, 0, 1, 2} {
+			assert.Equal(t,
+				db_resolver.GetEventFields(guid, "Security", 4624, version),
+				bundle_resolver.GetEventFields(guid, "Security", 4624, version))
+		}
+
+		for _, test := range []struct {
+			kind  string
+			value uint64
+		}{{"level", 4}, {"channel", 16}, {"level", 5}, {"task", 12544}} {
+			assert.Equal(t,
+				db_resolver.GetProviderValueName(guid, "", test.kind, test.value, 0),
+				bundle_resolver.GetProviderValueName(guid, "", test.kind, test.value, 0))
+		}
+	}
+
+	compare()
+
+	for _, resolver := range []interface {
+		SetLanguage(string)
+		SetBuild(int)
+	}{db_resolver, bundle_resolver} {
+		resolver.SetLanguage("de-AT")
+		resolver.SetBuild(9600)
+	}
+	compare()
+	assert.Equal(t, "Deutsch", bundle_resolver.GetMessage("Localized", "", 1, 0))
+	assert.Equal(t, "Older build", bundle_resolver.GetMessage("Localized", "", 3, 0))
+}
+
+func TestMessageBundleExport(t *testing.T) {
+	dir := t.TempDir()
+	db_filename := filepath.Join(dir, "messages.db")
+	writeTestMessageDB(t, db_filename)
+
+	bundle, err := LoadMessageBundle(db_filename)
+	require.NoError(t, err)
+
+	json_filename := filepath.Join(dir, "messages.json")
+	fd, err := os.Create(json_filename)
+	require.NoError(t, err)
+	assert.NoError(t, WriteMessageBundleJSON(fd, bundle))
+	fd.Close()
+
+	format, err := DetectMessageSourceFormat(json_filename)
+	assert.NoError(t, err)
+	assert.Equal(t, MESSAGE_SOURCE_BUNDLE_JSON, format)
+
+	// Importing the export recreates the same database.
+	imported_filename := filepath.Join(dir, "imported.db")
+	exported, err := LoadMessageBundle(json_filename)
+	require.NoError(t, err)
+	assert.NoError(t, MessageBundleToDB(exported, imported_filename))
+
+	imported, err := MessageDBToBundle(imported_filename)
+	require.NoError(t, err)
+	assert.Equal(t, bundle, imported)
+	assert.Equal(t, 0, len(DiffMessageBundles(bundle, imported)))
+}
+
+func TestMessageBundleToDBError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "messages.db")
+	writeTestMessageDB(t, filename)
+
+	before, err := MessageDBToBundle(filename)
+	require.NoError(t, err)
+
+	// Make adding parameters fail, after the provider and its
+	// messages were added.
+	database, err := OpenMessageDB(filename)
+	require.NoError(t, err)
+	_, err = database.Exec(`CREATE TRIGGER fail_parameters BEFORE INSERT ON parameters
+              BEGIN SELECT RAISE(ABORT, 'no parameters'); END`)
+	require.NoError(t, err)
+	database.Close()
+
+	bundle := NewMessageBundle()
+	bundle.Providers = append(bundle.Providers, &BundleProvider{
+		Name:       "Test Provider",
+		Messages:   []*BundleMessage{{Id: 1, EventId: 1, EventVersion: -1, Message: "Message"}},
+		Parameters: []*BundleParameter{{Id: 2, Message: "Parameter"}},
+	})
+
+	err = MessageBundleToDB(bundle, filename)
+	assert.Error(t, err)
+
+	// None of the bundle was added, and the existing database is kept.
+	after, err := MessageDBToBundle(filename)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestExpandMessageWithContext(t *testing.T) {
+	ctx := context.Background()
+
+	filename := filepath.Join(t.TempDir(), "messages.db")
+	writer, err := NewMessageDBWriter(filename)
+	require.NoError(t, err)
+
+	provider_id, err := writer.AddProvider("Service Control Manager", "", "System")
+	assert.NoError(t, err)
+	assert.NoError(t, writer.AddMessage(provider_id, 7036, 7036, "The %1 service started."))
+	assert.NoError(t, writer.Close())
+
+	resolver, err := NewDBResolver(filename)
+	require.NoError(t, err)
+	defer resolver.Close()
+
+	result, err := ExpandMessageWithContext(ctx,
+		testMessageEvent("Service Control Manager", 7036), nil, resolver, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "The Spooler service started.", result.Message)
+	assert.Equal(t, "db:"+filename, result.Source)
+
+	_, err = ExpandMessageWithContext(ctx,
+		testMessageEvent("Service Control Manager", 1), nil, resolver, "", false)
+	assert.True(t, errors.Is(err, ErrMessageNotFound))
+
+	_, err = ExpandMessageWithContext(ctx,
+		testMessageEvent("Unknown Provider", 7036), nil, resolver, "", false)
+	assert.True(t, errors.Is(err, ErrProviderNotFound))
+
+	// The legacy function still returns an empty message.
+	assert.Equal(t, "", ExpandMessage(
+		testMessageEvent("Unknown Provider", 7036), resolver))
+}
+
+func TestProviderValueNames(t *testing.T) {
+	manifest, err := ParseWEVTTemplate(buildTestManifest())
+	require.NoError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "messages.db")
+	writer, err := NewMessageDBWriter(filename)
+	require.NoError(t, err)
+
+	provider_id, err := writer.AddProvider("Microsoft-Windows-Security-Auditing",
+		"54849625-5478-4994-A5BA-3E3B0328C30D", "")
+	assert.NoError(t, err)
+
+	err = addManifestProvider(writer, provider_id, manifest.Providers[0], nil)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.AddLocalizedMessage(
+		provider_id, 0x50000004, 4, -1, "de-DE", "Informationen"))
+	assert.NoError(t, writer.AddLocalizedMessage(
+		provider_id, 0x50000004, 4, -1, "en-US", "Information"))
+	assert.NoError(t, writer.Close())
+
+	resolver, err := NewDBResolver(filename)
+	require.NoError(t, err)
+	defer resolver.Close()
+
+	assert.Equal(t, "Information", resolver.GetProviderValueName(
+		"{54849625-5478-4994-A5BA-3E3B0328C30D}", "", "level", 4, 0))
+
+	resolver.SetLanguage("de-DE")
+	assert.Equal(t, "Informationen", resolver.GetProviderValueName(
+		"Microsoft-Windows-Security-Auditing", "", "level", 4, 0))
+
+	// Without a message the manifest name is used.
+	assert.Equal(t, "Security", resolver.GetProviderValueName(
+		"Microsoft-Windows-Security-Auditing", "", "channel", 16, 0))
+	assert.Equal(t, "", resolver.GetProviderValueName(
+		"Microsoft-Windows-Security-Auditing", "", "level", 5, 0))
+}
+
+func TestEventSchema(t *testing.T) {
+	manifest, err := ParseWEVTTemplate(buildTestManifest())
+	require.NoError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "messages.db")
+	writer, err := NewMessageDBWriter(filename)
+	require.NoError(t, err)
+
+	provider_id, err := writer.AddProvider("Microsoft-Windows-Security-Auditing",
+		"{54849625-5478-4994-A5BA-3E3B0328C30D}", "")
+	assert.NoError(t, err)
+
+	err = addManifestProvider(writer, provider_id, manifest.Providers[0],
+		map[string]map[int64]string{"en-US": {
+			0xB0001211: "Logon version 1",
+			0xB0001212: "Logon version 2",
+		}})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	resolver, err := NewDBResolver(filename)
+	require.NoError(t, err)
+	defer resolver.Close()
+
+	assert.Equal(t, "Logon version 1", resolver.GetVersionedMessage(
+		"Microsoft-Windows-Security-Auditing", "Security", 4624, 1, 0))
+	assert.Equal(t, "Logon version 2", resolver.GetVersionedMessage(
+		"Microsoft-Windows-Security-Auditing", "Security", 4624, 2, 0))
+
+	assert.Equal(t, []string{"SubjectUserSid", "LogonType"},
+		resolver.GetEventFields("Microsoft-Windows-Security-Auditing", "", 4624, 1))
+
+	// Newer versions than known use the newest definition.
+	assert.Equal(t, []string{"SubjectUserSid", "LogonType", "ElevatedToken"},
+		resolver.GetEventFields("54849625-5478-4994-A5BA-3E3B0328C30D", "", 4624, 3))
+	assert.Nil(t, resolver.GetEventFields("Other", "", 4624, 1))
+
+	event := ordereddict.NewDict().
+		Set("System", ordereddict.NewDict().
+			Set("Provider", ordereddict.NewDict().
+				Set("Name", "Microsoft-Windows-Security-Auditing")).
+			Set("EventID", ordereddict.NewDict().Set("Value", 4624)).
+			Set("Version", 1)).
+		Set("EventData", ordereddict.NewDict().
+			Set("Data", []interface{}{"S-1-5-18", 2}).
+			Set("Binary", "00"))
+
+	NameEventData(event, resolver)
+	data, _ := ordereddict.GetMap(event, "EventData")
+	assert.Equal(t, []string{"SubjectUserSid", "LogonType", "Binary"}, data.Keys())
+	assert.Equal(t, []string{
+		"Expected 2 fields but got 3",
+		"Undeclared field Binary",
+	}, CheckEventSchema(event, resolver))
+
+	data.Delete("Binary")
+	assert.Nil(t, CheckEventSchema(event, resolver))
+
+	data.Delete("LogonType")
+	data.Set("TargetUserName", "user")
+	assert.Equal(t, []string{
+		"Missing field LogonType",
+		"Undeclared field TargetUserName",
+	}, CheckEventSchema(event, resolver))
+}
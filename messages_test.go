@@ -0,0 +1,178 @@
+package evtx
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+type testResolver struct {
+	NullResolver
+	message string
+}
+
+func (self testResolver) GetMessage(provider, channel string,
+	event_id, number_of_expansions int) string {
+	return self.message
+}
+
+func (self testResolver) GetParameter(provider, channel string,
+	parameter_id int) string {
+	return "Parameter"
+}
+
+func getTestRecord(t *testing.T, filename string, record_id uint64) *EventRecord {
+	fd, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	chunks, err := GetChunks(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunk := range chunks {
+		records, _ := chunk.Parse(0)
+		for _, record := range records {
+			if record.Header.RecordID == record_id {
+				return record
+			}
+		}
+	}
+
+	t.Fatalf("Record %v not found", record_id)
+	return nil
+}
+
+func TestExpandMessageWithArgs(t *testing.T) {
+	// A 4624 event which keeps its EventData in a nested template.
+	record := getTestRecord(t, "testdata/Security.evtx", 31880)
+	if len(record.MessageArgs) != 27 {
+		t.Fatalf("Expected 27 message args, got %v", len(record.MessageArgs))
+	}
+
+	event, _ := ordereddict.GetMap(record.Event.(*ordereddict.Dict), "Event")
+	resolver := testResolver{message: "%2 logon id %4 type %9 (%21)"}
+
+	expected := "test logon id 0x2995E type 2 (Parameter)"
+	message := ExpandMessageWithArgs(event, record.MessageArgs, resolver)
+	if message != expected {
+		t.Fatalf("Expected %q, got %q", expected, message)
+	}
+
+	// Without the args we fall back to the EventData.
+	expected = "test logon id 170334 type 2 (Parameter)"
+	message = ExpandMessage(event, resolver)
+	if message != expected {
+		t.Fatalf("Expected %q, got %q", expected, message)
+	}
+
+	// UserData is nested in the event template.
+	record = getTestRecord(t,
+		"testdata/Microsoft-Windows-CAPI2_Operational_EventID70.evtx", 1)
+	if len(record.MessageArgs) != 9 ||
+		record.MessageArgs[5] != "RuntimeBroker.exe" {
+		t.Fatalf("Unexpected message args %v", record.MessageArgs)
+	}
+}
+
+func TestResolveParameters(t *testing.T) {
+	record := getTestRecord(t, "testdata/Security.evtx", 31880)
+	event, _ := ordereddict.GetMap(record.Event.(*ordereddict.Dict), "Event")
+	ResolveParameters(event, testResolver{})
+
+	data, _ := ordereddict.GetMap(event, "EventData")
+	keys := data.Keys()
+	for i, k := range keys {
+		if k != "ImpersonationLevel" {
+			continue
+		}
+
+		value, _ := data.GetString(k)
+		if value != "Parameter" {
+			t.Fatalf("ImpersonationLevel not resolved: %v", value)
+		}
+
+		if keys[i+1] != "ImpersonationLevelCode" {
+			t.Fatalf("Expected the code after the field, got %v", keys[i+1])
+		}
+
+		code, _ := data.GetString(keys[i+1])
+		if code != "%%1833" {
+			t.Fatalf("Unexpected code %v", code)
+		}
+		return
+	}
+	t.Fatalf("ImpersonationLevel not found in %v", keys)
+}
+
+// Resolves messages from a single message set.
+type messageSetResolver struct {
+	NullResolver
+	set *MessageSet
+}
+
+func (self messageSetResolver) GetMessage(provider, channel string,
+	event_id, number_of_expansions int) string {
+	return self.set.GetBestMessage(event_id, number_of_expansions)
+}
+
+func (self messageSetResolver) GetQualifiedMessage(provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	return self.set.GetQualifiedMessage(qualifiers, event_id, number_of_expansions)
+}
+
+func (self messageSetResolver) GetParameter(provider, channel string,
+	parameter_id int) string {
+	return self.set.GetParameter(parameter_id)
+}
+
+func TestQualifiedMessages(t *testing.T) {
+	// A message table where the event id is shared by an
+	// informational and an error message.
+	set := &MessageSet{
+		Messages:   make(map[int]string),
+		Parameters: make(map[int]string),
+	}
+	set.AddMessageWithId(0x40001B7C, "The %1 service entered the %2 state.")
+	set.AddMessageWithId(0xC0001B7C, "The %1 service failed: %2")
+	set.AddMessageWithId(0x00000001, "Plain message %1 (%2).")
+
+	newEvent := func(event_id interface{}) *ordereddict.Dict {
+		return ordereddict.NewDict().
+			Set("System", ordereddict.NewDict().
+				Set("Provider", ordereddict.NewDict().
+					Set("Name", "Service Control Manager")).
+				Set("EventID", event_id).
+				Set("Channel", "System")).
+			Set("EventData", ordereddict.NewDict().
+				Set("param1", "Spooler").
+				Set("param2", "running"))
+	}
+
+	resolver := messageSetResolver{set: set}
+	for _, test := range []struct {
+		event_id *ordereddict.Dict
+		expected string
+	}{
+		{ordereddict.NewDict().Set("Qualifiers", 16384).Set("Value", 7036),
+			"The Spooler service entered the running state."},
+		{ordereddict.NewDict().Set("Qualifiers", 49152).Set("Value", 7036),
+			"The Spooler service failed: running"},
+
+		// Events without qualifiers use the event id.
+		{ordereddict.NewDict().Set("Value", 1), "Plain message Spooler (running)."},
+
+		// Unknown qualifiers fall back to the event id.
+		{ordereddict.NewDict().Set("Qualifiers", 0).Set("Value", 1),
+			"Plain message Spooler (running)."},
+	} {
+		message := ExpandMessage(newEvent(test.event_id), resolver)
+		if message != test.expected {
+			t.Fatalf("Expected %q, got %q", test.expected, message)
+		}
+	}
+}
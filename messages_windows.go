@@ -37,6 +37,10 @@ type WindowsMessageResolver struct {
 	mui_cache map[string][]string
 }
 
+func (self *WindowsMessageResolver) MessageSource() string {
+	return NATIVE_MESSAGE_SOURCE
+}
+
 func (self *WindowsMessageResolver) buildSxScache() {
 	self.mui_cache = make(map[string][]string)
 }
@@ -83,6 +87,19 @@ func (self *WindowsMessageResolver) GetMessage(
 	return message_set.GetBestMessage(event_id, number_of_expansions)
 }
 
+func (self *WindowsMessageResolver) GetQualifiedMessage(
+	provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+
+	message_set, err := self.getMessageSets(provider, channel)
+	if err != nil {
+		return ""
+	}
+
+	return message_set.GetQualifiedMessage(
+		qualifiers, event_id, number_of_expansions)
+}
+
 func (self *WindowsMessageResolver) GetParameter(
 	provider, channel string, parameter_id int) string {
 
@@ -105,7 +122,7 @@ func (self *WindowsMessageResolver) Close() {}
 // messages are split across multiple dlls. For example, a generic
 // message table may exist in C:\Windows\System32\XXX.dll but a
 // localized message table also exists in
-// C:\Windows\System32\en-us\XXX.dll.mui
+// C:\Windows\System32\en-us\XXX.dll.mui for each of the MUILanguages.
 func ExpandLocations(message_file string) []string {
 
 	// Expand environment variables in paths.
@@ -164,8 +181,12 @@ func ExpandLocations(message_file string) []string {
 			dll_name := filepath.Base(path)
 			dir_name := filepath.Dir(path)
 
-			result = append(result, filepath.Join(
-				dir_name, "en-US", dll_name+".mui"))
+			// Later files override earlier ones so the
+			// preferred language goes last.
+			for i := len(MUILanguages) - 1; i >= 0; i-- {
+				result = append(result, filepath.Join(
+					dir_name, MUILanguages[i], dll_name+".mui"))
+			}
 		}
 		return result
 	}
@@ -173,7 +194,7 @@ func ExpandLocations(message_file string) []string {
 	// Message file values may be separated by ;
 	res := include_muis(split_system32(replace_env_vars(
 		strings.Split(message_file, ";"))))
-	sort.Slice(res, func(i, j int) bool {
+	sort.SliceStable(res, func(i, j int) bool {
 		return len(res[i]) > len(res[j])
 	})
 	return res
@@ -217,15 +238,19 @@ func expandLocations(
 		Parameters: make(map[int]string),
 	}
 
-	populateMessages(message_files, result.AddMessage)
+	populateMessages(message_files, func(msg *pe.Message) {
+		result.AddMessageWithId(msg.Id, msg.Message)
+	})
 	if parameter_files != "" {
-		populateMessages(parameter_files, result.AddParameter)
+		populateMessages(parameter_files, func(msg *pe.Message) {
+			result.AddParameter(msg.EventId, msg.Message)
+		})
 	}
 
 	return result, nil
 }
 
-func populateMessages(message_files string, adder func(event_id int, message string)) {
+func populateMessages(message_files string, adder func(msg *pe.Message)) {
 	for _, message_file := range ExpandLocations(message_files) {
 		fd, err := os.Open(message_file)
 		if err != nil {
@@ -250,7 +275,7 @@ func populateMessages(message_files string, adder func(event_id int, message str
 		}
 
 		for _, msg := range messages {
-			adder(msg.EventId, msg.Message)
+			adder(msg)
 		}
 	}
 }
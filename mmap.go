@@ -0,0 +1,59 @@
+package evtx
+
+import (
+	"io"
+	"os"
+	runtime_debug "runtime/debug"
+
+	errors "github.com/pkg/errors"
+)
+
+// A chunkSlicer can hand out the raw bytes of a region of the file
+// without copying them into a new buffer (e.g. a memory mapped
+// file). Chunk.Parse() uses this in preference to Seek()/Read().
+type chunkSlicer interface {
+	Slice(offset, length int64) ([]byte, error)
+}
+
+// OpenFile opens an EVTX file for parsing. Where the platform
+// supports it the file is memory mapped, otherwise (or if mapping
+// fails) we fall back to regular reads from the file.
+func OpenFile(filename string) (io.ReadSeekCloser, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := NewMmapReader(fd)
+	if err != nil {
+		return fd, nil
+	}
+
+	return reader, nil
+}
+
+// A memory fault (SIGBUS) happens when a mapped file is truncated
+// underneath us. SetPanicOnFault() turns this into a panic which
+// we recover here and convert to an error. Other panics are
+// propagated normally.
+func recoverFault(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	_, ok := r.(interface{ Addr() uintptr })
+	if !ok {
+		panic(r)
+	}
+
+	*err = errors.Errorf("Memory fault reading mapped file: %v", r)
+}
+
+// Run the callback while converting memory faults into errors.
+func withFaultProtection(cb func() error) (err error) {
+	defer runtime_debug.SetPanicOnFault(runtime_debug.SetPanicOnFault(true))
+	defer recoverFault(&err)
+
+	return cb()
+}
@@ -0,0 +1,129 @@
+//go:build linux
+// +build linux
+
+package evtx
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	errors "github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// MmapReader reads an EVTX file through a read only memory
+// mapping. Chunks are parsed directly out of the mapping without
+// copying them. Reads beyond the end of the mapping (e.g. the file
+// grew since it was mapped) fall back to regular reads from the
+// file.
+type MmapReader struct {
+	mu     sync.Mutex
+	fd     *os.File
+	data   []byte
+	offset int64
+}
+
+func NewMmapReader(fd *os.File) (*MmapReader, error) {
+	stat, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := stat.Size()
+	if size <= 0 || int64(int(size)) != size {
+		return nil, errors.New("File size is not suitable for mmap")
+	}
+
+	data, err := unix.Mmap(int(fd.Fd()), 0, int(size),
+		unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrap(err, "mmap")
+	}
+
+	return &MmapReader{fd: fd, data: data}, nil
+}
+
+// Slice returns the mapped bytes directly. The slice is only valid
+// until the reader is closed.
+func (self *MmapReader) Slice(offset, length int64) ([]byte, error) {
+	self.mu.Lock()
+	data := self.data
+	self.mu.Unlock()
+
+	if offset < 0 || length < 0 {
+		return nil, errors.New("Invalid offset")
+	}
+
+	if data == nil || offset+length > int64(len(data)) {
+		buf := make([]byte, length)
+		_, err := self.fd.ReadAt(buf, offset)
+		if err != nil {
+			return nil, errors.Wrap(err, "ReadAt")
+		}
+		return buf, nil
+	}
+
+	return data[offset : offset+length], nil
+}
+
+func (self *MmapReader) ReadAt(buf []byte, offset int64) (int, error) {
+	self.mu.Lock()
+	data := self.data
+	self.mu.Unlock()
+
+	if offset < 0 {
+		return 0, errors.New("Invalid offset")
+	}
+
+	if data == nil || offset+int64(len(buf)) > int64(len(data)) {
+		return self.fd.ReadAt(buf, offset)
+	}
+
+	n := 0
+	err := withFaultProtection(func() error {
+		n = copy(buf, data[offset:])
+		return nil
+	})
+	return n, err
+}
+
+func (self *MmapReader) Read(buf []byte) (int, error) {
+	n, err := self.ReadAt(buf, self.offset)
+	self.offset += int64(n)
+	return n, err
+}
+
+func (self *MmapReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += self.offset
+	case io.SeekEnd:
+		stat, err := self.fd.Stat()
+		if err != nil {
+			return 0, err
+		}
+		offset += stat.Size()
+	default:
+		return 0, errors.New("Invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("Negative seek")
+	}
+
+	self.offset = offset
+	return offset, nil
+}
+
+func (self *MmapReader) Close() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.data != nil {
+		unix.Munmap(self.data)
+		self.data = nil
+	}
+	return self.fd.Close()
+}
@@ -0,0 +1,43 @@
+//go:build linux
+// +build linux
+
+package evtx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Truncating a mapped file while it is parsed must produce an error
+// and not crash the program.
+func TestMmapTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/Security.evtx")
+	assert.NoError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "Security.evtx")
+	assert.NoError(t, ioutil.WriteFile(filename, data, 0644))
+
+	fd, err := os.Open(filename)
+	assert.NoError(t, err)
+
+	reader, err := NewMmapReader(fd)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	chunks, err := GetChunks(reader)
+	assert.NoError(t, err)
+	assert.True(t, len(chunks) > 0)
+
+	records, err := chunks[0].Parse(0)
+	assert.NoError(t, err)
+	assert.True(t, len(records) > 0)
+
+	assert.NoError(t, os.Truncate(filename, 0))
+
+	_, err = chunks[0].Parse(0)
+	assert.Error(t, err)
+}
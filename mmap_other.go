@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package evtx
+
+import (
+	"io"
+	"os"
+
+	errors "github.com/pkg/errors"
+)
+
+// MmapReader is only supported on Linux. On other platforms
+// NewMmapReader() always fails and callers should read from the file
+// directly (OpenFile() does this automatically).
+type MmapReader struct {
+	io.ReadSeeker
+}
+
+func NewMmapReader(fd *os.File) (*MmapReader, error) {
+	return nil, errors.New("mmap is not supported on this platform")
+}
+
+func (self *MmapReader) Close() error {
+	return nil
+}
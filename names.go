@@ -0,0 +1,230 @@
+package evtx
+
+import (
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+// The standard values defined in winmeta.xml which all providers
+// share.
+var (
+	winmeta_levels = map[uint64]string{
+		0: "Log Always",
+		1: "Critical",
+		2: "Error",
+		3: "Warning",
+		4: "Information",
+		5: "Verbose",
+	}
+
+	winmeta_opcodes = map[uint64]string{
+		0:   "Info",
+		1:   "Start",
+		2:   "Stop",
+		3:   "DCStart",
+		4:   "DCStop",
+		5:   "Extension",
+		6:   "Reply",
+		7:   "Resume",
+		8:   "Suspend",
+		9:   "Send",
+		240: "Receive",
+	}
+
+	winmeta_keywords = map[uint64]string{
+		0x0001000000000000: "Response Time",
+		0x0002000000000000: "WDI Context",
+		0x0004000000000000: "WDI Diag",
+		0x0008000000000000: "SQM",
+		0x0010000000000000: "Audit Failure",
+		0x0020000000000000: "Audit Success",
+		0x0040000000000000: "Correlation Hint",
+		0x0080000000000000: "Classic",
+	}
+)
+
+// ProviderNameResolver is implemented by resolvers which know the
+// names the provider's manifest gives to its levels, tasks, opcodes
+// and keywords. The kind is one of "level", "task", "opcode" or
+// "keyword" (a single bit of the mask). Opcodes may be specific to a
+// task.
+type ProviderNameResolver interface {
+	GetProviderValueName(provider, channel, kind string,
+		value uint64, task int) string
+}
+
+func lookupProviderName(event *ordereddict.Dict, resolver MessageResolver,
+	kind string, value uint64, task int) string {
+	name_resolver, ok := resolver.(ProviderNameResolver)
+	if !ok {
+		return ""
+	}
+
+	provider, _ := ordereddict.GetString(event, "System.Provider.Name")
+	provider_guid, _ := ordereddict.GetString(event, "System.Provider.Guid")
+	channel, _ := ordereddict.GetString(event, "System.Channel")
+
+	// First try using the GUID then using the name.
+	if provider_guid != "" {
+		name := name_resolver.GetProviderValueName(
+			provider_guid, channel, kind, value, task)
+		if name != "" {
+			return strings.TrimSpace(name)
+		}
+	}
+
+	if provider == "" {
+		return ""
+	}
+	return strings.TrimSpace(name_resolver.GetProviderValueName(
+		provider, channel, kind, value, task))
+}
+
+// Names of the bits set in the keywords mask. Bits without a name are
+// skipped.
+func keywordNames(event *ordereddict.Dict, resolver MessageResolver,
+	keywords uint64) []string {
+	result := []string{}
+	for bit := 0; bit < 64; bit++ {
+		mask := uint64(1) << bit
+		if keywords&mask == 0 {
+			continue
+		}
+
+		name := lookupProviderName(event, resolver, "keyword", mask, 0)
+		if name == "" {
+			name = winmeta_keywords[mask]
+		}
+		if name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// ResolveSystemNames adds the names of the event's Level, Task,
+// Opcode and Keywords as the LevelName, TaskName, OpcodeName and
+// KeywordsNames siblings in System. Provider specific names come from
+// the resolver, falling back to the names in the event's
+// RenderingInfo and then the standard winmeta names. If
+// prefer_rendering_info is set, the names in the RenderingInfo are
+// used before the resolver's. Values without a name are left alone.
+func ResolveSystemNames(event *ordereddict.Dict, resolver MessageResolver,
+	prefer_rendering_info bool) {
+	system, pres := ordereddict.GetMap(event, "System")
+	if !pres {
+		return
+	}
+
+	// Names rendered into the event are used when the resolver has
+	// none.
+	rendering := GetRenderingInfo(event)
+	if rendering == nil {
+		rendering = &RenderingInfo{}
+	}
+
+	rendered_name := func(rendered string, lookup func() string) string {
+		if prefer_rendering_info && rendered != "" {
+			return rendered
+		}
+
+		name := lookup()
+		if name == "" {
+			name = rendered
+		}
+		return name
+	}
+
+	task := -1
+	task_value, pres := system.Get("Task")
+	if pres {
+		value, ok := toInt64(task_value)
+		if ok {
+			task = int(value)
+		}
+	}
+
+	rewriteDict(system, func(key string, value interface{}) []dictItem {
+		item := []dictItem{{key, value}}
+
+		number, ok := toInt64(value)
+		if !ok {
+			return item
+		}
+
+		var name_key string
+		var name interface{}
+
+		switch key {
+		case "Level":
+			name_key = "LevelName"
+			level_name := rendered_name(rendering.Level, func() string {
+				return lookupProviderName(
+					event, resolver, "level", uint64(number), 0)
+			})
+			if level_name == "" {
+				level_name = winmeta_levels[uint64(number)]
+			}
+			if level_name != "" {
+				name = level_name
+			}
+
+		case "Task":
+			// Task 0 means the event has no task.
+			name_key = "TaskName"
+			if number != 0 {
+				task_name := rendered_name(rendering.Task, func() string {
+					return lookupProviderName(
+						event, resolver, "task", uint64(number), 0)
+				})
+				if task_name != "" {
+					name = task_name
+				}
+			}
+
+		case "Opcode":
+			name_key = "OpcodeName"
+			opcode_name := rendered_name(rendering.Opcode, func() string {
+				if task > 0 {
+					name := lookupProviderName(
+						event, resolver, "opcode", uint64(number), task)
+					if name != "" {
+						return name
+					}
+				}
+				return lookupProviderName(
+					event, resolver, "opcode", uint64(number), 0)
+			})
+			if opcode_name == "" {
+				opcode_name = winmeta_opcodes[uint64(number)]
+			}
+			if opcode_name != "" {
+				name = opcode_name
+			}
+
+		case "Keywords":
+			name_key = "KeywordsNames"
+			names := rendering.Keywords
+			if !prefer_rendering_info || len(names) == 0 {
+				resolved := keywordNames(event, resolver, uint64(number))
+				if len(resolved) > 0 {
+					names = resolved
+				}
+			}
+			if len(names) > 0 {
+				name = names
+			}
+
+		default:
+			return item
+		}
+
+		// Do not clobber an existing field.
+		_, pres := system.Get(name_key)
+		if name == nil || pres {
+			return item
+		}
+		return append(item, dictItem{name_key, name})
+	})
+}
@@ -0,0 +1,68 @@
+package evtx
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+)
+
+type testNameResolver struct {
+	NullResolver
+	names map[string]string
+}
+
+func (self *testNameResolver) GetProviderValueName(provider, channel, kind string,
+	value uint64, task int) string {
+	return self.names[fmt.Sprintf("%v/%v/%v/%#x/%v", provider, channel, kind, value, task)]
+}
+
+func TestResolveSystemNames(t *testing.T) {
+	event := ordereddict.NewDict().
+		Set("System", ordereddict.NewDict().
+			Set("Provider", ordereddict.NewDict().Set("Name", "Provider")).
+			Set("Level", 4).
+			Set("Task", 12544).
+			Set("Opcode", 10).
+			Set("Keywords", uint64(0x8020000000000001)).
+			Set("Channel", "Security"))
+
+	resolver := &testNameResolver{names: map[string]string{
+		"Provider/Security/task/0x3100/0":                "Logon",
+		"Provider/Security/opcode/0xa/12544":             "Task Specific",
+		"Provider/Security/opcode/0xa/0":                 "Generic",
+		"Provider/Security/keyword/0x1/0":                "Provider Keyword",
+		"Provider/Security/keyword/0x8000000000000000/0": "Security Channel",
+	}}
+
+	ResolveSystemNames(event, resolver, false)
+	system, _ := ordereddict.GetMap(event, "System")
+	assert.Equal(t, []string{"Provider", "Level", "LevelName", "Task", "TaskName",
+		"Opcode", "OpcodeName", "Keywords", "KeywordsNames", "Channel"}, system.Keys())
+
+	level_name, _ := system.GetString("LevelName")
+	assert.Equal(t, "Information", level_name)
+
+	task_name, _ := system.GetString("TaskName")
+	assert.Equal(t, "Logon", task_name)
+
+	opcode_name, _ := system.GetString("OpcodeName")
+	assert.Equal(t, "Task Specific", opcode_name)
+
+	keywords, _ := system.Get("KeywordsNames")
+	assert.Equal(t, []string{"Provider Keyword", "Audit Success", "Security Channel"},
+		keywords)
+
+	// Existing names are not replaced and unknown values are left
+	// alone.
+	event = ordereddict.NewDict().
+		Set("System", ordereddict.NewDict().
+			Set("Level", 42).
+			Set("Task", 0).
+			Set("Opcode", 1).
+			Set("OpcodeName", "Mine"))
+	ResolveSystemNames(event, nil, false)
+	system, _ = ordereddict.GetMap(event, "System")
+	assert.Equal(t, []string{"Level", "Task", "Opcode", "OpcodeName"}, system.Keys())
+}
@@ -0,0 +1,185 @@
+package evtx
+
+import (
+	"io"
+	"strings"
+
+	errors "github.com/pkg/errors"
+	ntfs_parser "www.velocidex.com/golang/go-ntfs/parser"
+)
+
+const (
+	// Where Windows keeps its event logs, relative to the volume
+	// root.
+	EVTX_LOG_DIRECTORY = `Windows\System32\winevt\Logs`
+)
+
+// NTFSFile is a file found in an NTFS image.
+type NTFSFile struct {
+	// Path relative to the volume root.
+	Path string
+	Size int64
+
+	reader io.ReaderAt
+}
+
+// Open returns a seekable reader over the file's data runs, suitable
+// for GetChunks().
+func (self *NTFSFile) Open() io.ReadSeeker {
+	return io.NewSectionReader(self.reader, 0, self.Size)
+}
+
+// NTFSImage gives access to EVTX files inside a raw (or split) image
+// of an NTFS volume without mounting it.
+type NTFSImage struct {
+	image  *SplitReader
+	volume io.ReaderAt
+	ntfs   *ntfs_parser.NTFSContext
+}
+
+// OpenNTFSImage opens the NTFS volume at the offset in the image
+// (e.g. the start of the partition in a full disk image).
+func OpenNTFSImage(filename string, offset int64) (*NTFSImage, error) {
+	image, err := OpenSplitImage(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	volume := &ntfs_parser.OffsetReader{Offset: offset, Reader: image}
+	paged_reader, err := ntfs_parser.NewPagedReader(volume, 0x1000, 10000)
+	if err != nil {
+		image.Close()
+		return nil, err
+	}
+
+	ntfs, err := ntfs_parser.GetNTFSContext(paged_reader, 0)
+	if err != nil {
+		image.Close()
+		return nil, errors.Wrap(err, "Opening NTFS volume")
+	}
+
+	return &NTFSImage{image: image, volume: volume, ntfs: ntfs}, nil
+}
+
+// ListFiles lists the files in the directory (relative to the volume
+// root) which match the glob. Directories are located through the
+// MFT.
+func (self *NTFSImage) ListFiles(directory, glob string) ([]*NTFSFile, error) {
+	root, err := self.ntfs.GetMFT(5)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := root.Open(self.ntfs, directory)
+	if err != nil {
+		return nil, errors.Wrap(err, directory)
+	}
+
+	result := []*NTFSFile{}
+	for _, info := range ntfs_parser.ListDir(self.ntfs, dir) {
+		if info.IsDir || !MatchGlob(glob, info.Name) {
+			continue
+		}
+
+		mft_idx, attr_type, attr_id, stream_name, err :=
+			ntfs_parser.ParseMFTId(info.MFTId)
+		if err != nil {
+			continue
+		}
+
+		mft_entry, err := self.ntfs.GetMFT(mft_idx)
+		if err != nil {
+			continue
+		}
+
+		reader, err := ntfs_parser.OpenStream(self.ntfs, mft_entry,
+			uint64(attr_type), uint16(attr_id), stream_name)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, &NTFSFile{
+			Path:   strings.TrimRight(directory, `\/`) + `\` + info.Name,
+			Size:   info.Size,
+			reader: reader,
+		})
+	}
+
+	return result, nil
+}
+
+// CarveUnallocated runs the chunk carver over all clusters which are
+// marked as free in the volume's $Bitmap. Chunk offsets are relative
+// to the start of the volume.
+func (self *NTFSImage) CarveUnallocated(cb func(chunk *Chunk) error) error {
+	bitmap_entry, err := self.ntfs.GetMFT(6)
+	if err != nil {
+		return err
+	}
+
+	bitmap, err := ntfs_parser.OpenStream(self.ntfs, bitmap_entry,
+		ntfs_parser.ATTR_TYPE_DATA, ntfs_parser.WILDCARD_STREAM_ID,
+		ntfs_parser.WILDCARD_STREAM_NAME)
+	if err != nil {
+		return errors.Wrap(err, "Opening $Bitmap")
+	}
+
+	// The boot sector records the volume size in sectors.
+	cluster_size := self.ntfs.ClusterSize
+	total_clusters := self.ntfs.Boot.VolumeSize() *
+		int64(self.ntfs.Boot.Sector_size()) / cluster_size
+
+	// Carve each run of free clusters.
+	carve_run := func(start, end int64) error {
+		if start < 0 || end <= start {
+			return nil
+		}
+		return CarveChunks(
+			io.NewSectionReader(self.volume, start*cluster_size,
+				(end-start)*cluster_size),
+			(end-start)*cluster_size,
+			func(chunk *Chunk) error {
+				chunk.Offset += start * cluster_size
+				return cb(chunk)
+			})
+	}
+
+	buf := make([]byte, 0x10000)
+	run_start := int64(-1)
+	for offset := int64(0); offset*8 < total_clusters; offset += int64(len(buf)) {
+		n, _ := bitmap.ReadAt(buf, offset)
+		if n == 0 {
+			break
+		}
+
+		for i := 0; i < n*8; i++ {
+			cluster := (offset+int64(i/8))*8 + int64(i%8)
+			if cluster >= total_clusters {
+				break
+			}
+
+			allocated := buf[i/8]&(1<<uint(i%8)) != 0
+			if !allocated && run_start < 0 {
+				run_start = cluster
+
+			} else if allocated && run_start >= 0 {
+				err := carve_run(run_start, cluster)
+				if err != nil {
+					return err
+				}
+				run_start = -1
+			}
+		}
+	}
+
+	if run_start >= 0 {
+		return carve_run(run_start, total_clusters)
+	}
+
+	return nil
+}
+
+func (self *NTFSImage) Close() error {
+	self.ntfs.Close()
+	return self.image.Close()
+}
@@ -0,0 +1,115 @@
+package evtx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/ntfs.dd.gz is a small NTFS volume. The file
+// "Folder A\Folder B\Hello world text document.txt" holds
+// Security_1_record.evtx and a deleted chunk of Security.evtx sits
+// in unallocated space at 0x3c0200.
+const (
+	NTFS_TEST_FILE         = `Folder A\Folder B\Hello world text document.txt`
+	NTFS_TEST_CARVE_OFFSET = 0x3c0200
+)
+
+// Write the test volume into the directory at the offset, split into
+// the number of parts. Returns the name of the first part.
+func writeNTFSTestImage(t *testing.T, dir string, offset int64, parts int) string {
+	fd, err := os.Open("testdata/ntfs.dd.gz")
+	require.NoError(t, err)
+	defer fd.Close()
+
+	reader, err := gzip.NewReader(fd)
+	require.NoError(t, err)
+
+	volume, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+
+	image := append(make([]byte, offset), volume...)
+	part_size := (len(image) + parts - 1) / parts
+	for i := 0; i < parts; i++ {
+		end := (i + 1) * part_size
+		if end > len(image) {
+			end = len(image)
+		}
+
+		filename := filepath.Join(dir, fmt.Sprintf("image.%03d", i+1))
+		require.NoError(t, os.WriteFile(filename, image[i*part_size:end], 0600))
+	}
+
+	return filepath.Join(dir, "image.001")
+}
+
+func TestNTFSImage(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		offset        int64
+		parts         int
+		volume_offset int64
+	}{
+		{"Volume image", 0, 1, 0},
+		{"Partition in a disk image", 0x100000, 1, 0x100000},
+		{"Split image", 0x100000, 3, 0x100000},
+	} {
+		filename := writeNTFSTestImage(t, t.TempDir(), tc.offset, tc.parts)
+
+		image, err := OpenNTFSImage(filename, tc.volume_offset)
+		require.NoError(t, err, tc.name)
+
+		files, err := image.ListFiles(`Folder A\Folder B`, "*document.txt")
+		require.NoError(t, err, tc.name)
+		require.Equal(t, 1, len(files), tc.name)
+		assert.Equal(t, NTFS_TEST_FILE, files[0].Path, tc.name)
+		assert.Equal(t, int64(0x11000), files[0].Size, tc.name)
+
+		chunks, err := GetChunks(files[0].Open())
+		require.NoError(t, err, tc.name)
+		require.Equal(t, 1, len(chunks), tc.name)
+
+		records, err := chunks[0].Parse(0)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, 1, len(records), tc.name)
+
+		// The volume has no event logs.
+		_, err = image.ListFiles(EVTX_LOG_DIRECTORY, "*.evtx")
+		assert.Error(t, err, tc.name)
+
+		// Only the deleted chunk is carved, not the one in the file.
+		offsets := []int64{}
+		err = image.CarveUnallocated(func(chunk *Chunk) error {
+			offsets = append(offsets, chunk.Offset)
+			records, err := chunk.Parse(0)
+			assert.True(t, len(records) > 0, tc.name)
+			return err
+		})
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, []int64{NTFS_TEST_CARVE_OFFSET}, offsets, tc.name)
+
+		image.Close()
+	}
+
+	// There is no volume at the wrong offset.
+	filename := writeNTFSTestImage(t, t.TempDir(), 0x100000, 1)
+	_, err := OpenNTFSImage(filename, 0)
+	assert.Error(t, err)
+
+	// Carving stops at the first error from the callback.
+	image, err := OpenNTFSImage(filename, 0x100000)
+	require.NoError(t, err)
+	defer image.Close()
+
+	err = image.CarveUnallocated(func(chunk *Chunk) error {
+		return bytes.ErrTooLarge
+	})
+	assert.Equal(t, bytes.ErrTooLarge, err)
+}
@@ -0,0 +1,349 @@
+package evtx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	errors "github.com/pkg/errors"
+	"www.velocidex.com/golang/regparser"
+)
+
+var (
+	drive_re              = regexp.MustCompile(`(?i)^[a-z]:`)
+	programfiles_x86_re   = regexp.MustCompile(`(?i)%programfiles\(x86\)%`)
+	commonprogramfiles_re = regexp.MustCompile(`(?i)%commonprogramfiles%`)
+	nt_system_root_re     = regexp.MustCompile(`(?i)^\\systemroot\\`)
+)
+
+// OfflineSystem gives access to the registry and files of a Windows
+// installation mounted (or extracted) at Root, e.g. to build a message
+// database for a suspect machine from its disk image. The root is
+// the system drive.
+type OfflineSystem struct {
+	Root string
+
+	system   *regparser.Registry
+	software *regparser.Registry
+	files    []*os.File
+
+	mu sync.Mutex
+
+	// Directory listings for case insensitive path resolution.
+	listings map[string][]string
+}
+
+// OpenOfflineSystem opens the SYSTEM and SOFTWARE hives of the
+// installation.
+func OpenOfflineSystem(root string) (*OfflineSystem, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	self := &OfflineSystem{
+		Root:     root,
+		listings: make(map[string][]string),
+	}
+
+	for _, hive := range []struct {
+		name     string
+		registry **regparser.Registry
+	}{
+		{"SYSTEM", &self.system},
+		{"SOFTWARE", &self.software},
+	} {
+		path, pres := self.ResolvePath(`C:\Windows\System32\config\` + hive.name)
+		if !pres {
+			self.Close()
+			return nil, errors.Errorf("%v hive not found under %v", hive.name, root)
+		}
+
+		fd, err := os.Open(path)
+		if err != nil {
+			self.Close()
+			return nil, err
+		}
+		self.files = append(self.files, fd)
+
+		*hive.registry, err = regparser.NewRegistry(fd)
+		if err != nil {
+			self.Close()
+			return nil, errors.Wrap(err, path)
+		}
+	}
+
+	return self, nil
+}
+
+func (self *OfflineSystem) Close() {
+	for _, fd := range self.files {
+		fd.Close()
+	}
+}
+
+// ResolvePath finds the file a Windows path refers to under the
+// root. Path components are matched case insensitively. Like on
+// Windows, ".." at the top of the drive stays there. Paths which
+// still end up outside the root, e.g. through symlinks in the image,
+// are not resolved.
+func (self *OfflineSystem) ResolvePath(path string) (string, bool) {
+	path = drive_re.ReplaceAllString(path, "")
+
+	root := filepath.Clean(self.Root)
+	result := root
+	for _, component := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '\\' || r == '/'
+	}) {
+		if component == "." {
+			continue
+		}
+
+		if component == ".." {
+			if result != root {
+				result = filepath.Dir(result)
+			}
+			continue
+		}
+
+		next := filepath.Join(result, component)
+		_, err := os.Lstat(next)
+		if err == nil {
+			result = next
+			continue
+		}
+
+		found := false
+		for _, name := range self.listDirectory(result) {
+			if strings.EqualFold(name, component) {
+				result = filepath.Join(result, name)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return "", false
+		}
+	}
+
+	if !isUnderRoot(root, result) {
+		return "", false
+	}
+	return result, true
+}
+
+// Check that the path is under the root once all symlinks are
+// followed.
+func isUnderRoot(root, path string) bool {
+	real_root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+
+	real_path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	relative, err := filepath.Rel(real_root, real_path)
+	if err != nil {
+		return false
+	}
+
+	return relative != ".." &&
+		!strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+func (self *OfflineSystem) listDirectory(directory string) []string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	names, pres := self.listings[directory]
+	if pres {
+		return names
+	}
+
+	entries, _ := os.ReadDir(directory)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	self.listings[directory] = names
+	return names
+}
+
+// ExpandLocations is the offline version of ExpandLocations(): It
+// expands the environment variables to their default values and
+// returns the files under the root which exist, including the MUI
+// files of the MUILanguages and the SysWOW64 versions.
+func (self *OfflineSystem) ExpandLocations(message_file string) []string {
+	candidates := []string{}
+	for _, path := range strings.Split(message_file, ";") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		path = nt_system_root_re.ReplaceAllLiteralString(path, `C:\Windows\`)
+		path = system_root_re.ReplaceAllLiteralString(path, `C:\Windows`)
+		path = windir_re.ReplaceAllLiteralString(path, `C:\Windows`)
+		path = programfiles_x86_re.ReplaceAllLiteralString(
+			path, `C:\Program Files (x86)`)
+		path = programfiles_re.ReplaceAllLiteralString(path, `C:\Program Files`)
+		path = commonprogramfiles_re.ReplaceAllLiteralString(
+			path, `C:\Program Files\Common Files`)
+
+		// Relative paths are found through the DLL search path.
+		if !drive_re.MatchString(path) && !strings.HasPrefix(path, `\`) {
+			candidates = append(candidates,
+				`C:\Windows\System32\`+path, `C:\Windows\`+path)
+			continue
+		}
+		candidates = append(candidates, path)
+	}
+
+	// Sometimes messages are found in the 32 bit folders.
+	paths := []string{}
+	for _, path := range candidates {
+		paths = append(paths, path)
+		if system32_re.FindString(path) != "" {
+			paths = append(paths, system32_re.ReplaceAllLiteralString(
+				path, `\SysWOW64\`))
+		}
+	}
+
+	result := []string{}
+	seen := make(map[string]bool)
+	add := func(path string) {
+		resolved, pres := self.ResolvePath(path)
+		if pres && !seen[resolved] {
+			seen[resolved] = true
+			result = append(result, resolved)
+		}
+	}
+
+	for _, path := range paths {
+		add(path)
+
+		// The localized messages are in MUI files.
+		parts := strings.Split(path, `\`)
+		dll_name := parts[len(parts)-1]
+		dir_name := strings.Join(parts[:len(parts)-1], `\`)
+		for _, language := range MUILanguages {
+			add(dir_name + `\` + language + `\` + dll_name + ".mui")
+		}
+	}
+
+	return result
+}
+
+// Find a registry value by name (case insensitive). The default value
+// has an empty name.
+func getRegistryString(key *regparser.CM_KEY_NODE, name string) string {
+	for _, value := range key.Values() {
+		if !strings.EqualFold(value.ValueName(), name) {
+			continue
+		}
+
+		data := value.ValueData()
+		switch data.Type {
+		case regparser.REG_SZ, regparser.REG_EXPAND_SZ:
+			return strings.TrimRight(data.String, "\x00")
+		case regparser.REG_MULTI_SZ:
+			return strings.Join(data.MultiSz, ";")
+		case regparser.REG_DWORD, regparser.REG_QWORD:
+			return fmt.Sprintf("%d", data.Uint64)
+		}
+	}
+	return ""
+}
+
+// The control set the system booted with.
+func (self *OfflineSystem) currentControlSet() string {
+	key := self.system.OpenKey(`Select`)
+	if key != nil {
+		current := getRegistryString(key, "Current")
+		if current != "" {
+			return "ControlSet" + fmt.Sprintf("%03s", current)
+		}
+	}
+	return "ControlSet001"
+}
+
+// BuildNumber returns the build of the installation (0 if not known).
+func (self *OfflineSystem) BuildNumber() int {
+	key := self.software.OpenKey(`Microsoft\Windows NT\CurrentVersion`)
+	if key == nil {
+		return 0
+	}
+
+	build := 0
+	fmt.Sscanf(getRegistryString(key, "CurrentBuildNumber"), "%d", &build)
+	return build
+}
+
+// WalkProviders calls the callback with the legacy EventLog sources
+// of each channel and the manifest based publishers.
+func (self *OfflineSystem) WalkProviders(
+	cb func(provider *ProviderRegistration) error) error {
+	eventlog_path := self.currentControlSet() + `\Services\EventLog`
+	channels_key := self.system.OpenKey(eventlog_path)
+	if channels_key == nil {
+		return errors.Errorf("%v not found in SYSTEM hive", eventlog_path)
+	}
+
+	for _, channel_key := range channels_key.Subkeys() {
+		for _, provider_key := range channel_key.Subkeys() {
+			provider := &ProviderRegistration{
+				Name:           provider_key.Name(),
+				Guid:           getRegistryString(provider_key, "ProviderGuid"),
+				Channel:        channel_key.Name(),
+				MessageFiles:   getRegistryString(provider_key, "EventMessageFile"),
+				ParameterFiles: getRegistryString(provider_key, "ParameterMessageFile"),
+			}
+
+			if provider.MessageFiles == "" && provider.ParameterFiles == "" {
+				continue
+			}
+
+			err := cb(provider)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	publishers_key := self.software.OpenKey(
+		`Microsoft\Windows\CurrentVersion\WINEVT\Publishers`)
+	if publishers_key == nil {
+		return nil
+	}
+
+	for _, publisher_key := range publishers_key.Subkeys() {
+		guid := publisher_key.Name()
+		provider := &ProviderRegistration{
+			Name:           getRegistryString(publisher_key, ""),
+			Guid:           guid,
+			MessageFiles:   getRegistryString(publisher_key, "MessageFileName"),
+			ParameterFiles: getRegistryString(publisher_key, "ParameterFileName"),
+		}
+
+		if provider.Name == "" {
+			provider.Name = NormalizeGUID(guid)
+		}
+
+		if provider.MessageFiles == "" && provider.ParameterFiles == "" {
+			continue
+		}
+
+		err := cb(provider)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
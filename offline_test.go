@@ -0,0 +1,94 @@
+package evtx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOfflineExpandLocations(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{
+		"Windows/System32/wevtapi.dll",
+		"Windows/System32/en-US/wevtapi.dll.mui",
+		"Windows/SysWOW64/wevtapi.dll",
+		"Windows/System32/msaudite.dll",
+		"Program Files/App/app.dll",
+	} {
+		path = filepath.Join(root, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		assert.NoError(t, os.WriteFile(path, nil, 0600))
+	}
+
+	system := &OfflineSystem{
+		Root:     root,
+		listings: make(map[string][]string),
+	}
+
+	resolved, pres := system.ResolvePath(`C:\WINDOWS\system32\WevtApi.dll`)
+	assert.True(t, pres)
+	assert.Equal(t, filepath.Join(root, "Windows/System32/wevtapi.dll"), resolved)
+
+	_, pres = system.ResolvePath(`C:\Windows\System32\missing.dll`)
+	assert.False(t, pres)
+
+	// Paths can not escape the root.
+	outside := filepath.Join(filepath.Dir(root), "outside.dll")
+	assert.NoError(t, os.WriteFile(outside, nil, 0600))
+
+	for _, path := range []string{
+		`C:\..\outside.dll`,
+		`C:\Windows\..\..\outside.dll`,
+		`..\..\..\outside.dll`,
+	} {
+		_, pres = system.ResolvePath(path)
+		assert.False(t, pres, path)
+	}
+
+	// Also when the root is not given in its clean form.
+	for _, unclean := range []string{root + "/", root + "/."} {
+		unclean_system := &OfflineSystem{
+			Root:     unclean,
+			listings: make(map[string][]string),
+		}
+
+		_, pres = unclean_system.ResolvePath(`C:\Windows\..\..\outside.dll`)
+		assert.False(t, pres, unclean)
+
+		resolved, pres = unclean_system.ResolvePath(`C:\Windows\System32\wevtapi.dll`)
+		assert.True(t, pres, unclean)
+		assert.Equal(t, filepath.Join(root, "Windows/System32/wevtapi.dll"), resolved)
+	}
+
+	resolved, pres = system.ResolvePath(`C:\..\Windows\SysWOW64\..\System32\wevtapi.dll`)
+	assert.True(t, pres)
+	assert.Equal(t, filepath.Join(root, "Windows/System32/wevtapi.dll"), resolved)
+
+	assert.Equal(t, []string{
+		filepath.Join(root, "Windows/System32/wevtapi.dll"),
+		filepath.Join(root, "Windows/System32/en-US/wevtapi.dll.mui"),
+		filepath.Join(root, "Windows/SysWOW64/wevtapi.dll"),
+		filepath.Join(root, "Windows/System32/msaudite.dll"),
+		filepath.Join(root, "Program Files/App/app.dll"),
+	}, system.ExpandLocations(
+		`%SystemRoot%\system32\wevtapi.dll;msaudite.dll;`+
+			`%ProgramFiles%\App\app.dll;\SystemRoot\System32\missing.dll`))
+
+	// Symlinks in the image can not escape the root either.
+	err := os.Symlink(outside, filepath.Join(root, "Windows/System32/link.dll"))
+	if err != nil {
+		t.Skipf("Can not create symlinks: %v", err)
+	}
+	assert.NoError(t, os.Symlink(filepath.Dir(root),
+		filepath.Join(root, "Windows/link")))
+
+	for _, path := range []string{
+		`C:\Windows\System32\link.dll`,
+		`C:\Windows\link\outside.dll`,
+	} {
+		_, pres = system.ResolvePath(path)
+		assert.False(t, pres, path)
+	}
+}
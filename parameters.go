@@ -0,0 +1,115 @@
+package evtx
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+var (
+	parameter_reference_re = regexp.MustCompile(`%%([0-9]+)`)
+)
+
+type dictItem struct {
+	key   string
+	value interface{}
+}
+
+// Rewrite the dict in place, keeping the order of the keys. The
+// callback returns the items which replace each item.
+func rewriteDict(dict *ordereddict.Dict,
+	cb func(key string, value interface{}) []dictItem) {
+	keys := dict.Keys()
+	items := []dictItem{}
+	for _, k := range keys {
+		v, _ := dict.Get(k)
+		items = append(items, cb(k, v)...)
+	}
+
+	for _, k := range keys {
+		dict.Delete(k)
+	}
+
+	for _, item := range items {
+		dict.Set(item.key, item.value)
+	}
+}
+
+// ResolveParameters replaces %%NNNN parameter references in the
+// event's EventData and UserData with the provider's parameter
+// strings. The original value is kept in a sibling <Key>Code field,
+// e.g. ImpersonationLevel: "Impersonation" and ImpersonationLevelCode:
+// "%%1833". References which can not be resolved are left alone.
+func ResolveParameters(event *ordereddict.Dict, resolver MessageResolver) {
+	provider, _ := ordereddict.GetString(event, "System.Provider.Name")
+	provider_guid, _ := ordereddict.GetString(event, "System.Provider.Guid")
+	channel, _ := ordereddict.GetString(event, "System.Channel")
+
+	resolve := func(value string) (string, bool) {
+		changed := false
+		result := parameter_reference_re.ReplaceAllStringFunc(value,
+			func(match string) string {
+				param_id, err := strconv.Atoi(match[2:])
+				if err != nil {
+					return match
+				}
+
+				parameter := lookupEventParameter(resolver,
+					provider_guid, provider, channel, param_id)
+				if parameter == "" {
+					return match
+				}
+
+				changed = true
+				return strings.TrimSpace(parameter)
+			})
+		return result, changed
+	}
+
+	for _, section := range []string{"EventData", "UserData"} {
+		data, pres := ordereddict.GetMap(event, section)
+		if pres {
+			resolveParametersInDict(data, resolve)
+		}
+	}
+}
+
+// First try using the GUID then using the name.
+func lookupEventParameter(resolver MessageResolver,
+	provider_guid, provider, channel string, param_id int) string {
+	parameter := ""
+	if provider_guid != "" {
+		parameter = resolver.GetParameter(provider_guid, channel, param_id)
+	}
+	if parameter == "" && provider != "" {
+		parameter = resolver.GetParameter(provider, channel, param_id)
+	}
+	return parameter
+}
+
+func resolveParametersInDict(dict *ordereddict.Dict,
+	resolve func(value string) (string, bool)) {
+	rewriteDict(dict, func(key string, value interface{}) []dictItem {
+		item := []dictItem{{key, value}}
+
+		switch t := value.(type) {
+		case *ordereddict.Dict:
+			resolveParametersInDict(t, resolve)
+
+		case string:
+			// Do not clobber an existing field.
+			_, pres := dict.Get(key + "Code")
+			if pres {
+				return item
+			}
+
+			resolved, changed := resolve(t)
+			if changed {
+				return []dictItem{{key, resolved}, {key + "Code", t}}
+			}
+		}
+		return item
+	})
+}
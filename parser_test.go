@@ -6,9 +6,16 @@ package evtx
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/alecthomas/assert"
@@ -60,6 +67,211 @@ func (self *EVTXTestSuite) TestTemplates() {
 	goldie.Assert(self.T(), fixture_name, out)
 }
 
+func (self *EVTXTestSuite) TestMmap() {
+	cmdline := []string{
+		"parse", "testdata/Microsoft-Windows-CAPI2_Operational_EventID70.evtx",
+		"--disable_messages", "--mmap",
+	}
+	cmd := exec.Command(self.binary, cmdline...)
+	out, err := cmd.CombinedOutput()
+	assert.NoError(self.T(), err)
+
+	// Output should be identical to reading the file normally.
+	out = bytes.ReplaceAll(out, []byte{'\r', '\n'}, []byte{'\n'})
+	goldie.Assert(self.T(), "CAPI2_Operational", out)
+}
+
+func (self *EVTXTestSuite) TestCompressedStdin() {
+	data, err := ioutil.ReadFile(
+		"testdata/Microsoft-Windows-CAPI2_Operational_EventID70.evtx")
+	assert.NoError(self.T(), err)
+
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	writer.Write(data)
+	writer.Close()
+
+	cmd := exec.Command(self.binary, "parse", "-", "--disable_messages")
+	cmd.Stdin = compressed
+	out, err := cmd.CombinedOutput()
+	assert.NoError(self.T(), err)
+
+	// Output should be identical to reading the file normally.
+	out = bytes.ReplaceAll(out, []byte{'\r', '\n'}, []byte{'\n'})
+	goldie.Assert(self.T(), "CAPI2_Operational", out)
+}
+
+func (self *EVTXTestSuite) TestArchive() {
+	for _, archive := range []string{"testdata/logs.zip", "testdata/logs.tar.gz"} {
+		cmd := exec.Command(self.binary, "parse",
+			archive+"!**/winevt/Logs/*.evtx", "--disable_messages")
+		out, err := cmd.Output()
+		assert.NoError(self.T(), err)
+
+		// Each event is tagged with the member it came from.
+		for _, source := range self.eventSources(out) {
+			assert.Equal(self.T(), archive, source["Archive"])
+			assert.Equal(self.T(), archive+"!"+source["Member"].(string), source["Path"])
+		}
+
+		assert.Equal(self.T(), []string{
+			archive + "!C/Windows/System32/winevt/Logs/Security.evtx",
+			archive + "!C/Windows/System32/winevt/Logs/CAPI2.evtx",
+		}, self.eventPaths(out))
+	}
+}
+
+// The Source of each event in the output.
+func (self *EVTXTestSuite) eventSources(out []byte) []map[string]interface{} {
+	result := []map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for decoder.More() {
+		event := make(map[string]interface{})
+		assert.NoError(self.T(), decoder.Decode(&event))
+
+		source, _ := event["Source"].(map[string]interface{})
+		result = append(result, source)
+	}
+	return result
+}
+
+// The Source.Path of each event in the output.
+func (self *EVTXTestSuite) eventPaths(out []byte) []string {
+	result := []string{}
+	for _, source := range self.eventSources(out) {
+		path, _ := source["Path"].(string)
+		result = append(result, path)
+	}
+	return result
+}
+
+func (self *EVTXTestSuite) TestSources() {
+	dir := self.T().TempDir()
+	logs := filepath.Join(dir, "logs")
+	security := filepath.Join(logs, "Security.evtx")
+	capi2 := filepath.Join(logs, "sub", "CAPI2.evtx")
+	corrupt := filepath.Join(logs, "corrupt.evtx")
+	notes := filepath.Join(logs, "sub", "notes.txt")
+	filelist := filepath.Join(dir, "filelist.txt")
+
+	for filename, data := range map[string][]byte{
+		security: self.readFile("testdata/Security_1_record.evtx"),
+		capi2:    self.readFile("testdata/Microsoft-Windows-CAPI2_Operational_EventID70.evtx"),
+		corrupt:  bytes.Repeat([]byte{0xaa}, 0x1000),
+		notes:    []byte("Not an event log."),
+		filelist: []byte(security + "\n" + capi2 + "\n"),
+	} {
+		assert.NoError(self.T(), os.MkdirAll(filepath.Dir(filename), 0700))
+		assert.NoError(self.T(), os.WriteFile(filename, data, 0600))
+	}
+
+	for _, tc := range []struct {
+		args   []string
+		paths  []string
+		errors int
+	}{
+		// Directories are searched recursively for --glob.
+		{[]string{logs}, []string{security, capi2}, 1},
+		{[]string{logs, "--glob", "*.EVTX"}, []string{security, capi2}, 1},
+		{[]string{logs, "--glob", "CAPI2.evtx"}, []string{capi2}, 0},
+		{[]string{filepath.Join(dir, "**", "*.evtx")}, []string{security, capi2}, 1},
+		{[]string{filepath.Join(logs, "*", "*.evtx")}, []string{capi2}, 0},
+		{[]string{filepath.Join(logs, "*")}, []string{security}, 1},
+
+		// Files are only parsed once.
+		{[]string{logs, security}, []string{security, capi2}, 1},
+
+		// A file of paths.
+		{[]string{"@" + filelist}, []string{security, capi2}, 0},
+
+		// A single file is not tagged.
+		{[]string{security}, []string{""}, 0},
+	} {
+		name := strings.Join(tc.args, " ")
+		args := append([]string{"parse", "--disable_messages",
+			"--workers", "4"}, tc.args...)
+		cmd := exec.Command(self.binary, args...)
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		assert.NoError(self.T(), err, name)
+
+		paths := self.eventPaths(out)
+		sort.Strings(paths)
+		assert.Equal(self.T(), tc.paths, paths, name)
+
+		// Corrupt files are reported and skipped.
+		if tc.errors > 0 {
+			assert.Contains(self.T(), stderr.String(), "Error parsing "+corrupt, name)
+			assert.Contains(self.T(), stderr.String(), fmt.Sprintf(
+				"%v of %v sources could not be parsed completely",
+				tc.errors, len(tc.paths)+tc.errors), name)
+		} else {
+			assert.Equal(self.T(), "", stderr.String(), name)
+		}
+	}
+}
+
+// Merging adds the same fields to the events as parsing.
+func (self *EVTXTestSuite) TestMergeEnrichment() {
+	flags := []string{"--disable_messages", "--message_errors",
+		"--resolve_names", "--decode_security"}
+	run := func(command string) []map[string]interface{} {
+		args := append([]string{command}, flags...)
+		cmd := exec.Command(self.binary,
+			append(args, "testdata/Security_1_record.evtx")...)
+		out, err := cmd.Output()
+		assert.NoError(self.T(), err, command)
+
+		events := []map[string]interface{}{}
+		decoder := json.NewDecoder(bytes.NewReader(out))
+		for decoder.More() {
+			event := make(map[string]interface{})
+			assert.NoError(self.T(), decoder.Decode(&event))
+			delete(event, "Source")
+			events = append(events, event)
+		}
+		return events
+	}
+
+	merged := run("merge")
+	assert.Equal(self.T(), 1, len(merged))
+	assert.NotEqual(self.T(), nil, merged[0]["MessageError"])
+	assert.Equal(self.T(), run("parse"), merged)
+}
+
+// Messages and names saved with the log take precedence over the
+// other message sources.
+func (self *EVTXTestSuite) TestLocaleMetaData() {
+	log_file := saveWithLocaleMetaData(self.T())
+	cmd := exec.Command(self.binary, "parse", "--resolve_names", log_file)
+	out, err := cmd.Output()
+	assert.NoError(self.T(), err)
+
+	events := []map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for decoder.More() {
+		event := make(map[string]interface{})
+		assert.NoError(self.T(), decoder.Decode(&event))
+		events = append(events, event)
+	}
+	assert.Equal(self.T(), 1, len(events))
+
+	assert.Equal(self.T(), "Das Überwachungsprotokoll wurde gelöscht.", events[0]["Message"])
+	assert.Equal(self.T(), "mta:"+filepath.Join(filepath.Dir(log_file), "LocaleMetaData",
+		"Security_1031.MTA"), events[0]["MessageSource"])
+
+	system := events[0]["System"].(map[string]interface{})
+	assert.Equal(self.T(), "Protokoll löschen", system["TaskName"])
+	assert.Equal(self.T(), "Informationen", system["LevelName"])
+}
+
+func (self *EVTXTestSuite) readFile(filename string) []byte {
+	data, err := ioutil.ReadFile(filename)
+	assert.NoError(self.T(), err)
+	return data
+}
+
 func TestEvtx(t *testing.T) {
 	suite.Run(t, &EVTXTestSuite{})
 }
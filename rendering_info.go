@@ -0,0 +1,74 @@
+package evtx
+
+import (
+	"github.com/Velocidex/ordereddict"
+)
+
+// The source of messages taken from the event's RenderingInfo.
+const RENDERING_INFO_SOURCE = "RenderingInfo"
+
+// RenderingInfo is the message and names Windows rendered into the
+// event. Forwarded events and logs exported from Event Viewer carry
+// them.
+type RenderingInfo struct {
+	Culture  string
+	Message  string
+	Level    string
+	Task     string
+	Opcode   string
+	Channel  string
+	Provider string
+	Keywords []string
+}
+
+// GetRenderingInfo returns the event's RenderingInfo, or nil if it
+// has none.
+func GetRenderingInfo(event *ordereddict.Dict) *RenderingInfo {
+	rendering, pres := ordereddict.GetMap(event, "RenderingInfo")
+	if !pres {
+		return nil
+	}
+
+	result := &RenderingInfo{}
+	for _, item := range []struct {
+		name  string
+		value *string
+	}{
+		{"Culture", &result.Culture},
+		{"Message", &result.Message},
+		{"Level", &result.Level},
+		{"Task", &result.Task},
+		{"Opcode", &result.Opcode},
+		{"Channel", &result.Channel},
+		{"Provider", &result.Provider},
+	} {
+		*item.value, _ = ordereddict.GetString(rendering, item.name)
+	}
+
+	keywords, _ := ordereddict.GetAny(rendering, "Keywords.Keyword")
+	switch t := keywords.(type) {
+	case string:
+		result.Keywords = append(result.Keywords, t)
+	case []interface{}:
+		for _, item := range t {
+			name, ok := item.(string)
+			if ok {
+				result.Keywords = append(result.Keywords, name)
+			}
+		}
+	}
+
+	return result
+}
+
+// The already rendered message of the event, or nil.
+func renderedMessage(event *ordereddict.Dict) *MessageResult {
+	rendering := GetRenderingInfo(event)
+	if rendering == nil || rendering.Message == "" {
+		return nil
+	}
+	return &MessageResult{
+		Message: rendering.Message,
+		Source:  RENDERING_INFO_SOURCE,
+	}
+}
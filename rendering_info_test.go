@@ -0,0 +1,82 @@
+package evtx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRenderedEvent(event_id int) *ordereddict.Dict {
+	event := testMessageEvent("Service Control Manager", event_id)
+	system, _ := ordereddict.GetMap(event, "System")
+	system.Set("Level", 4).Set("Task", 0).Set("Opcode", 0).
+		Set("Keywords", uint64(0x8080000000000000))
+	event.Set("EventData", ordereddict.NewDict().
+		Set("Name", "Spooler").
+		Set("State", "running"))
+
+	return event.Set("RenderingInfo", ordereddict.NewDict().
+		Set("Culture", "en-US").
+		Set("Message", "The Print Spooler service started.").
+		Set("Level", "Informational").
+		Set("Opcode", "Rendered Info").
+		Set("Keywords", ordereddict.NewDict().
+			Set("Keyword", "Classic")))
+}
+
+func TestRenderingInfo(t *testing.T) {
+	rendering := GetRenderingInfo(testRenderedEvent(7036))
+	assert.Equal(t, "en-US", rendering.Culture)
+	assert.Equal(t, "Informational", rendering.Level)
+	assert.Equal(t, []string{"Classic"}, rendering.Keywords)
+
+	assert.Nil(t, GetRenderingInfo(testMessageEvent("Provider", 1)))
+}
+
+func TestRenderingInfoMessage(t *testing.T) {
+	ctx := context.Background()
+	set := &MessageSet{Messages: make(map[int]string)}
+	set.AddMessage(7036, "The %1 service entered the %2 state.")
+	resolver := &messageSetResolver{set: set}
+
+	// The resolver's message is preferred.
+	result, err := ExpandMessageWithContext(ctx, testRenderedEvent(7036), nil, resolver, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "The Spooler service entered the running state.", result.Message)
+	assert.Equal(t, UNNAMED_MESSAGE_SOURCE, result.Source)
+
+	// Unless it has none.
+	result, err = ExpandMessageWithContext(ctx, testRenderedEvent(1), nil, resolver, "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "The Print Spooler service started.", result.Message)
+	assert.Equal(t, RENDERING_INFO_SOURCE, result.Source)
+
+	result, err = ExpandMessageWithContext(ctx, testRenderedEvent(7036), nil, resolver, "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "The Print Spooler service started.", result.Message)
+}
+
+func TestRenderingInfoNames(t *testing.T) {
+	resolver := &testNameResolver{names: map[string]string{
+		"Service Control Manager/System/level/0x4/0": "Provider Information",
+	}}
+
+	event := testRenderedEvent(7036)
+	ResolveSystemNames(event, resolver, false)
+	level_name, _ := ordereddict.GetString(event, "System.LevelName")
+	assert.Equal(t, "Provider Information", level_name)
+
+	// The rendered name is used before the standard names.
+	opcode_name, _ := ordereddict.GetString(event, "System.OpcodeName")
+	assert.Equal(t, "Rendered Info", opcode_name)
+
+	event = testRenderedEvent(7036)
+	ResolveSystemNames(event, resolver, true)
+	level_name, _ = ordereddict.GetString(event, "System.LevelName")
+	assert.Equal(t, "Informational", level_name)
+
+	keywords, _ := ordereddict.GetAny(event, "System.KeywordsNames")
+	assert.Equal(t, []string{"Classic"}, keywords)
+}
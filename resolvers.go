@@ -0,0 +1,422 @@
+package evtx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	yaml "github.com/Velocidex/yaml/v2"
+	lru "github.com/hashicorp/golang-lru"
+	errors "github.com/pkg/errors"
+)
+
+// ChainResolver tries each resolver in turn and uses the first
+// answer. Earlier resolvers take precedence.
+type ChainResolver struct {
+	resolvers []MessageResolver
+}
+
+func NewChainResolver(resolvers ...MessageResolver) *ChainResolver {
+	return &ChainResolver{resolvers: resolvers}
+}
+
+// Add appends a resolver with the lowest precedence.
+func (self *ChainResolver) Add(resolver MessageResolver) {
+	self.resolvers = append(self.resolvers, resolver)
+}
+
+func (self *ChainResolver) GetMessage(provider, channel string,
+	event_id, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id, -1,
+		number_of_expansions)
+}
+
+func (self *ChainResolver) GetVersionedMessage(provider, channel string,
+	event_id, version, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id,
+		version, number_of_expansions)
+}
+
+func (self *ChainResolver) GetQualifiedMessage(provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	return getMessageV2(self, provider, channel, qualifiers, event_id,
+		version, number_of_expansions)
+}
+
+func (self *ChainResolver) GetParameter(provider, channel string,
+	parameter_id int) string {
+	return getParameterV2(self, provider, channel, parameter_id)
+}
+
+// The chain reports real failures before not finding the message, and
+// not finding the message before not knowing the provider.
+func errorRank(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrProviderNotFound):
+		return 1
+	case errors.Is(err, ErrMessageNotFound):
+		return 2
+	}
+	return 3
+}
+
+func chainError(err, next error) error {
+	if errorRank(next) > errorRank(err) {
+		return next
+	}
+	return err
+}
+
+func (self *ChainResolver) LookupMessage(
+	ctx context.Context, request *MessageRequest) (*MessageResult, error) {
+	var err error = ErrProviderNotFound
+	for _, resolver := range self.resolvers {
+		result, lookup_err := AsMessageResolverV2(resolver).LookupMessage(
+			ctx, request)
+		if lookup_err == nil {
+			return result, nil
+		}
+		err = chainError(err, lookup_err)
+	}
+	return nil, err
+}
+
+func (self *ChainResolver) LookupParameter(
+	ctx context.Context, request *ParameterRequest) (*MessageResult, error) {
+	var err error = ErrProviderNotFound
+	for _, resolver := range self.resolvers {
+		result, lookup_err := AsMessageResolverV2(resolver).LookupParameter(
+			ctx, request)
+		if lookup_err == nil {
+			return result, nil
+		}
+		err = chainError(err, lookup_err)
+	}
+	return nil, err
+}
+
+func (self *ChainResolver) GetEventFields(provider, channel string,
+	event_id, version int) []string {
+	for _, resolver := range self.resolvers {
+		schema_resolver, ok := resolver.(EventSchemaResolver)
+		if !ok {
+			continue
+		}
+
+		fields := schema_resolver.GetEventFields(
+			provider, channel, event_id, version)
+		if fields != nil {
+			return fields
+		}
+	}
+	return nil
+}
+
+func (self *ChainResolver) GetProviderValueName(provider, channel, kind string,
+	value uint64, task int) string {
+	for _, resolver := range self.resolvers {
+		name_resolver, ok := resolver.(ProviderNameResolver)
+		if !ok {
+			continue
+		}
+
+		name := name_resolver.GetProviderValueName(
+			provider, channel, kind, value, task)
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func (self *ChainResolver) Close() {
+	for _, resolver := range self.resolvers {
+		resolver.Close()
+	}
+}
+
+// MapProvider holds the messages of a provider in a MapResolver. The
+// GUID and channel are optional. Message keys are event ids, or the
+// full message id of classic events with qualifiers.
+type MapProvider struct {
+	Name       string         `json:"name" yaml:"name"`
+	Guid       string         `json:"guid,omitempty" yaml:"guid,omitempty"`
+	Channel    string         `json:"channel,omitempty" yaml:"channel,omitempty"`
+	Messages   map[int]string `json:"messages,omitempty" yaml:"messages,omitempty"`
+	Parameters map[int]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+}
+
+type mapResolverFile struct {
+	Providers []*MapProvider `json:"providers" yaml:"providers"`
+}
+
+// MapResolver resolves messages from memory. It is useful for tests
+// and for hand curated messages which override a database. A map
+// file is YAML (or JSON) like:
+//
+//	providers:
+//	- name: Microsoft-Windows-Security-Auditing
+//	  guid: 54849625-5478-4994-A5BA-3E3B0328C30D
+//	  messages:
+//	    4624: An account was successfully logged on.
+//	  parameters:
+//	    1833: Impersonation
+type MapResolver struct {
+	providers []*MapProvider
+
+	// Describes where the messages came from.
+	source string
+}
+
+func NewMapResolver() *MapResolver {
+	return &MapResolver{source: "map"}
+}
+
+// LoadMapResolver loads the messages from a YAML or JSON (.json)
+// file.
+func LoadMapResolver(filename string) (*MapResolver, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON object keys are strings, which YAML does not convert to
+	// event ids.
+	file := &mapResolverFile{}
+	if strings.HasSuffix(strings.ToLower(filename), ".json") {
+		err = json.Unmarshal(data, file)
+	} else {
+		err = yaml.UnmarshalStrict(data, file)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, filename)
+	}
+
+	result := &MapResolver{source: "map:" + filename}
+	for _, provider := range file.Providers {
+		if provider.Name == "" && provider.Guid == "" {
+			return nil, errors.Errorf("%v: Provider without a name or GUID",
+				filename)
+		}
+		result.AddProvider(provider)
+	}
+	return result, nil
+}
+
+// AddProvider adds the provider's messages. Providers added earlier
+// take precedence.
+func (self *MapResolver) AddProvider(provider *MapProvider) {
+	if provider.Messages == nil {
+		provider.Messages = make(map[int]string)
+	}
+	if provider.Parameters == nil {
+		provider.Parameters = make(map[int]string)
+	}
+	self.providers = append(self.providers, provider)
+}
+
+// The provider may be given by name or GUID.
+func (self *MapResolver) getProviders(provider, channel string) []*MapProvider {
+	result := []*MapProvider{}
+	guid := NormalizeGUID(provider)
+	for _, item := range self.providers {
+		if !strings.EqualFold(item.Name, provider) &&
+			(item.Guid == "" || NormalizeGUID(item.Guid) != guid) {
+			continue
+		}
+
+		if item.Channel != "" && !strings.EqualFold(item.Channel, channel) {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+func (self *MapResolver) GetMessage(provider, channel string,
+	event_id, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id, -1,
+		number_of_expansions)
+}
+
+func (self *MapResolver) GetQualifiedMessage(provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	return getMessageV2(self, provider, channel, qualifiers, event_id,
+		version, number_of_expansions)
+}
+
+func (self *MapResolver) GetParameter(provider, channel string,
+	parameter_id int) string {
+	return getParameterV2(self, provider, channel, parameter_id)
+}
+
+func (self *MapResolver) LookupMessage(
+	ctx context.Context, request *MessageRequest) (*MessageResult, error) {
+	providers := self.getProviders(request.Provider, request.Channel)
+	if len(providers) == 0 {
+		return nil, ErrProviderNotFound
+	}
+
+	for _, item := range providers {
+		if request.Qualifiers >= 0 {
+			message, pres := item.Messages[int(QualifiedMessageId(
+				request.Qualifiers, request.EventId))]
+			if pres {
+				return &MessageResult{Message: message, Source: self.source}, nil
+			}
+		}
+
+		message, pres := item.Messages[request.EventId]
+		if pres {
+			return &MessageResult{Message: message, Source: self.source}, nil
+		}
+	}
+	return nil, ErrMessageNotFound
+}
+
+func (self *MapResolver) LookupParameter(
+	ctx context.Context, request *ParameterRequest) (*MessageResult, error) {
+	providers := self.getProviders(request.Provider, request.Channel)
+	if len(providers) == 0 {
+		return nil, ErrProviderNotFound
+	}
+
+	for _, item := range providers {
+		parameter, pres := item.Parameters[request.ParameterId]
+		if pres {
+			return &MessageResult{Message: parameter, Source: self.source}, nil
+		}
+	}
+	return nil, ErrMessageNotFound
+}
+
+func (self *MapResolver) Close() {}
+
+// CachingResolver remembers the answers of a slower resolver (e.g. a
+// DBResolver which runs a query for each lookup). Failed lookups are
+// cached too.
+type CachingResolver struct {
+	resolver MessageResolver
+	cache    *lru.Cache
+}
+
+func NewCachingResolver(resolver MessageResolver, size int) (*CachingResolver, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CachingResolver{
+		resolver: resolver,
+		cache:    cache,
+	}, nil
+}
+
+func (self *CachingResolver) cached(key string, cb func() interface{}) interface{} {
+	value, pres := self.cache.Get(key)
+	if pres {
+		return value
+	}
+
+	value = cb()
+	self.cache.Add(key, value)
+	return value
+}
+
+func (self *CachingResolver) GetMessage(provider, channel string,
+	event_id, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id, -1,
+		number_of_expansions)
+}
+
+func (self *CachingResolver) GetVersionedMessage(provider, channel string,
+	event_id, version, number_of_expansions int) string {
+	return self.GetQualifiedMessage(provider, channel, -1, event_id,
+		version, number_of_expansions)
+}
+
+func (self *CachingResolver) GetQualifiedMessage(provider, channel string,
+	qualifiers, event_id, version, number_of_expansions int) string {
+	return getMessageV2(self, provider, channel, qualifiers, event_id,
+		version, number_of_expansions)
+}
+
+func (self *CachingResolver) GetParameter(provider, channel string,
+	parameter_id int) string {
+	return getParameterV2(self, provider, channel, parameter_id)
+}
+
+type cachedResult struct {
+	result *MessageResult
+	err    error
+}
+
+// Only the answers are cached, not failures of the resolver.
+func (self *CachingResolver) cachedResult(key string,
+	cb func() (*MessageResult, error)) (*MessageResult, error) {
+	value, pres := self.cache.Get(key)
+	if pres {
+		cached := value.(*cachedResult)
+		return cached.result, cached.err
+	}
+
+	result, err := cb()
+	if err == nil || IsNotFound(err) {
+		self.cache.Add(key, &cachedResult{result: result, err: err})
+	}
+	return result, err
+}
+
+func (self *CachingResolver) LookupMessage(
+	ctx context.Context, request *MessageRequest) (*MessageResult, error) {
+	key := fmt.Sprintf("M|%v|%v|%v|%v|%v|%v|%v", request.Provider,
+		request.Channel, request.Qualifiers, request.EventId,
+		request.Version, request.Language, request.NumberOfExpansions)
+	return self.cachedResult(key, func() (*MessageResult, error) {
+		return AsMessageResolverV2(self.resolver).LookupMessage(ctx, request)
+	})
+}
+
+func (self *CachingResolver) LookupParameter(
+	ctx context.Context, request *ParameterRequest) (*MessageResult, error) {
+	key := fmt.Sprintf("P|%v|%v|%v|%v", request.Provider, request.Channel,
+		request.ParameterId, request.Language)
+	return self.cachedResult(key, func() (*MessageResult, error) {
+		return AsMessageResolverV2(self.resolver).LookupParameter(ctx, request)
+	})
+}
+
+func (self *CachingResolver) GetEventFields(provider, channel string,
+	event_id, version int) []string {
+	schema_resolver, ok := self.resolver.(EventSchemaResolver)
+	if !ok {
+		return nil
+	}
+
+	key := fmt.Sprintf("F|%v|%v|%v|%v", provider, channel, event_id, version)
+	return self.cached(key, func() interface{} {
+		return schema_resolver.GetEventFields(provider, channel, event_id, version)
+	}).([]string)
+}
+
+func (self *CachingResolver) GetProviderValueName(provider, channel, kind string,
+	value uint64, task int) string {
+	name_resolver, ok := self.resolver.(ProviderNameResolver)
+	if !ok {
+		return ""
+	}
+
+	key := fmt.Sprintf("V|%v|%v|%v|%v|%v", provider, channel, kind, value, task)
+	return self.cached(key, func() interface{} {
+		return name_resolver.GetProviderValueName(
+			provider, channel, kind, value, task)
+	}).(string)
+}
+
+func (self *CachingResolver) Close() {
+	self.resolver.Close()
+}
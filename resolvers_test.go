@@ -0,0 +1,100 @@
+package evtx
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Counts the lookups which reach the resolver.
+type countingResolver struct {
+	MapResolver
+	lookups int
+}
+
+func (self *countingResolver) LookupMessage(
+	ctx context.Context, request *MessageRequest) (*MessageResult, error) {
+	self.lookups++
+	return self.MapResolver.LookupMessage(ctx, request)
+}
+
+func TestMapResolver(t *testing.T) {
+	dir := t.TempDir()
+	yaml_file := filepath.Join(dir, "messages.yaml")
+	assert.NoError(t, os.WriteFile(yaml_file, []byte(`
+providers:
+- name: Service Control Manager
+  channel: System
+  messages:
+    7036: Generic
+    0x40001B7C: The %1 service entered the %2 state.
+- guid: "{54849625-5478-4994-A5BA-3E3B0328C30D}"
+  parameters:
+    1833: Impersonation
+`), 0600))
+
+	resolver, err := LoadMapResolver(yaml_file)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "The %1 service entered the %2 state.", resolver.GetQualifiedMessage(
+		"service control manager", "System", 0x4000, 7036, -1, 2))
+	assert.Equal(t, "Generic", resolver.GetMessage(
+		"Service Control Manager", "System", 7036, 2))
+	assert.Equal(t, "", resolver.GetMessage(
+		"Service Control Manager", "Application", 7036, 2))
+	assert.Equal(t, "Impersonation", resolver.GetParameter(
+		"54849625-5478-4994-a5ba-3e3b0328c30d", "Security", 1833))
+
+	json_file := filepath.Join(dir, "messages.json")
+	assert.NoError(t, os.WriteFile(json_file, []byte(`
+{"providers": [{"name": "Provider", "messages": {"1": "From JSON"}}]}
+`), 0600))
+
+	resolver, err = LoadMapResolver(json_file)
+	assert.NoError(t, err)
+	assert.Equal(t, "From JSON", resolver.GetMessage("Provider", "", 1, 0))
+
+	// Unknown fields are errors.
+	assert.NoError(t, os.WriteFile(yaml_file, []byte(`
+providers:
+- name: Provider
+  mesages: {}
+`), 0600))
+	_, err = LoadMapResolver(yaml_file)
+	assert.Error(t, err)
+}
+
+func TestChainAndCachingResolvers(t *testing.T) {
+	overrides := NewMapResolver()
+	overrides.AddProvider(&MapProvider{
+		Name:     "Provider",
+		Messages: map[int]string{1: "Override"},
+	})
+
+	database := &countingResolver{}
+	database.AddProvider(&MapProvider{
+		Name:       "Provider",
+		Messages:   map[int]string{1: "Database", 2: "Only in database"},
+		Parameters: map[int]string{1833: "Impersonation"},
+	})
+
+	resolver, err := NewCachingResolver(NewChainResolver(overrides, database), 100)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Override", resolver.GetMessage("Provider", "", 1, 0))
+	assert.Equal(t, 0, database.lookups)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "Only in database", resolver.GetMessage("Provider", "", 2, 0))
+		assert.Equal(t, "", resolver.GetMessage("Provider", "", 3, 0))
+	}
+
+	// Misses are cached too.
+	assert.Equal(t, 2, database.lookups)
+
+	assert.Equal(t, "Impersonation", resolver.GetParameter("Provider", "", 1833))
+	assert.Nil(t, resolver.GetEventFields("Provider", "", 1, 0))
+}
@@ -0,0 +1,165 @@
+package evtx
+
+import (
+	"fmt"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+// EventSchemaResolver is implemented by resolvers which know the data
+// fields the provider's manifest declares for its events.
+type EventSchemaResolver interface {
+	GetEventFields(provider, channel string, event_id, version int) []string
+}
+
+// Find the declared fields of the event, first using the provider's
+// GUID then its name. Returns nil if not known.
+func getEventFields(event *ordereddict.Dict, resolver MessageResolver) []string {
+	schema_resolver, ok := resolver.(EventSchemaResolver)
+	if !ok {
+		return nil
+	}
+
+	provider, _ := ordereddict.GetString(event, "System.Provider.Name")
+	provider_guid, _ := ordereddict.GetString(event, "System.Provider.Guid")
+	channel, _ := ordereddict.GetString(event, "System.Channel")
+	event_id, ok := ordereddict.GetInt(event, "System.EventID.Value")
+	if !ok {
+		event_id, _ = ordereddict.GetInt(event, "System.EventID")
+	}
+
+	version, ok := ordereddict.GetInt(event, "System.Version")
+	if !ok {
+		version = -1
+	}
+
+	if provider_guid != "" {
+		fields := schema_resolver.GetEventFields(
+			provider_guid, channel, event_id, version)
+		if fields != nil {
+			return fields
+		}
+	}
+	return schema_resolver.GetEventFields(provider, channel, event_id, version)
+}
+
+// The data of the event: EventData or the element within UserData.
+func getEventDataSection(event *ordereddict.Dict) (*ordereddict.Dict, bool) {
+	data, pres := ordereddict.GetMap(event, "EventData")
+	if pres {
+		return data, true
+	}
+
+	user_data, pres := ordereddict.GetMap(event, "UserData")
+	if !pres || user_data.Len() != 1 {
+		return nil, false
+	}
+
+	value, _ := user_data.Get(user_data.Keys()[0])
+	data, ok := value.(*ordereddict.Dict)
+	return data, ok
+}
+
+// NameEventData gives the names declared in the provider's manifest
+// to unnamed EventData fields, e.g. {"Data": ["a", "b"]} becomes
+// {"Field1": "a", "Field2": "b"}. Only the resolvers implementing
+// EventSchemaResolver know the names.
+func NameEventData(event *ordereddict.Dict, resolver MessageResolver) {
+	data, pres := ordereddict.GetMap(event, "EventData")
+	if !pres {
+		return
+	}
+
+	value, pres := data.Get("Data")
+	if !pres {
+		return
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	// Named fields were already normalized.
+	for _, item := range values {
+		_, ok := item.(*ordereddict.Dict)
+		if ok {
+			return
+		}
+	}
+
+	fields := getEventFields(event, resolver)
+	if len(fields) != len(values) {
+		return
+	}
+
+	rewriteDict(data, func(key string, value interface{}) []dictItem {
+		if key != "Data" {
+			return []dictItem{{key, value}}
+		}
+
+		items := []dictItem{}
+		for idx, field := range fields {
+			items = append(items, dictItem{field, values[idx]})
+		}
+		return items
+	})
+}
+
+// CheckEventSchema compares the event's data with the fields declared
+// in the provider's manifest. Returns a description of each
+// difference, or nil if the event matches or its schema is not known.
+func CheckEventSchema(event *ordereddict.Dict, resolver MessageResolver) []string {
+	fields := getEventFields(event, resolver)
+	if fields == nil {
+		return nil
+	}
+
+	data, pres := getEventDataSection(event)
+	if !pres {
+		if len(fields) == 0 {
+			return nil
+		}
+		return []string{fmt.Sprintf(
+			"Expected %v fields but the event has no data", len(fields))}
+	}
+
+	result := []string{}
+	keys := data.Keys()
+	if len(keys) != len(fields) {
+		result = append(result, fmt.Sprintf(
+			"Expected %v fields but got %v", len(fields), len(keys)))
+	}
+
+	declared := make(map[string]bool)
+	for _, field := range fields {
+		declared[field] = true
+		_, pres := data.Get(field)
+		if !pres {
+			result = append(result, "Missing field "+field)
+		}
+	}
+
+	for idx, key := range keys {
+		if !declared[key] {
+			result = append(result, "Undeclared field "+key)
+		} else if idx < len(fields) && fields[idx] != key {
+			result = append(result, fmt.Sprintf(
+				"Field %v is at position %v instead of %v", key, idx, fieldIndex(fields, key)))
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func fieldIndex(fields []string, name string) int {
+	for idx, field := range fields {
+		if field == name {
+			return idx
+		}
+	}
+	return -1
+}
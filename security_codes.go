@@ -0,0 +1,405 @@
+package evtx
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+const SECURITY_AUDITING_GUID = "54849625-5478-4994-A5BA-3E3B0328C30D"
+
+var (
+	// NTSTATUS codes of failed logons.
+	ntstatus_codes = map[uint64]string{
+		0x00000000: "Success",
+		0xC0000022: "Access denied",
+		0xC000005E: "There are currently no logon servers available",
+		0xC0000064: "User name does not exist",
+		0xC000006A: "User name is correct but the password is wrong",
+		0xC000006C: "Password does not meet the policy requirements",
+		0xC000006D: "Unknown user name or bad password",
+		0xC000006E: "Account restriction",
+		0xC000006F: "Logon outside of the allowed hours",
+		0xC0000070: "Logon from an unauthorized workstation",
+		0xC0000071: "Password has expired",
+		0xC0000072: "Account is disabled",
+		0xC000009A: "Insufficient system resources",
+		0xC00000DC: "The server is in the wrong state",
+		0xC0000133: "Clocks of the computer and the domain controller are out of sync",
+		0xC000015B: "Logon type not granted",
+		0xC000018C: "Trust relationship between the domains failed",
+		0xC0000192: "The Netlogon service is not started",
+		0xC0000193: "Account has expired",
+		0xC0000224: "User must change the password at next logon",
+		0xC0000225: "Not found",
+		0xC0000234: "Account is locked out",
+		0xC00002EE: "An error occurred during logon",
+		0xC0000371: "The local account store does not contain secret material",
+		0xC0000413: "The machine is not allowed to authenticate to the computer (authentication firewall)",
+	}
+
+	// Kerberos result codes (RFC 4120) of 4768, 4769, 4771 and 4772.
+	kerberos_codes = map[uint64]string{
+		0x0:  "Success",
+		0x1:  "Client's entry in the database has expired",
+		0x2:  "Server's entry in the database has expired",
+		0x3:  "Requested protocol version not supported",
+		0x6:  "Client not found in the Kerberos database",
+		0x7:  "Server not found in the Kerberos database",
+		0x8:  "Multiple principal entries in the database",
+		0x9:  "The client or server has a null key",
+		0xC:  "KDC policy rejects the request",
+		0xD:  "KDC cannot accommodate the requested option",
+		0xE:  "KDC has no support for the encryption type",
+		0xF:  "KDC has no support for the checksum type",
+		0x10: "KDC has no support for the pre-authentication type",
+		0x12: "Client's credentials have been revoked",
+		0x17: "Password has expired",
+		0x18: "Pre-authentication information was invalid",
+		0x19: "Additional pre-authentication required",
+		0x1F: "Integrity check on the decrypted field failed",
+		0x20: "Ticket expired",
+		0x21: "Ticket not yet valid",
+		0x22: "Request is a replay",
+		0x25: "Clock skew too great",
+		0x29: "Message stream modified",
+		0x3C: "Generic error",
+	}
+
+	kerberos_events = map[int]bool{
+		4768: true, 4769: true, 4770: true, 4771: true, 4772: true, 4773: true,
+	}
+
+	logon_types = map[uint64]string{
+		0:  "System",
+		2:  "Interactive",
+		3:  "Network",
+		4:  "Batch",
+		5:  "Service",
+		7:  "Unlock",
+		8:  "NetworkCleartext",
+		9:  "NewCredentials",
+		10: "RemoteInteractive",
+		11: "CachedInteractive",
+		12: "CachedRemoteInteractive",
+		13: "CachedUnlock",
+	}
+
+	ticket_encryption_types = map[uint64]string{
+		0x1:        "DES-CBC-CRC",
+		0x3:        "DES-CBC-MD5",
+		0x11:       "AES128-CTS-HMAC-SHA1-96",
+		0x12:       "AES256-CTS-HMAC-SHA1-96",
+		0x17:       "RC4-HMAC",
+		0x18:       "RC4-HMAC-EXP",
+		0xFFFFFFFF: "Failure",
+	}
+
+	standard_access_rights = map[uint64]string{
+		0x00010000: "DELETE",
+		0x00020000: "READ_CONTROL",
+		0x00040000: "WRITE_DAC",
+		0x00080000: "WRITE_OWNER",
+		0x00100000: "SYNCHRONIZE",
+		0x01000000: "ACCESS_SYS_SEC",
+		0x02000000: "MAXIMUM_ALLOWED",
+		0x10000000: "GENERIC_ALL",
+		0x20000000: "GENERIC_EXECUTE",
+		0x40000000: "GENERIC_WRITE",
+		0x80000000: "GENERIC_READ",
+	}
+
+	// The object specific access rights by the event's ObjectType.
+	object_access_rights = map[string]map[uint64]string{
+		"File": {
+			0x0001: "ReadData (or ListDirectory)",
+			0x0002: "WriteData (or AddFile)",
+			0x0004: "AppendData (or AddSubdirectory or CreatePipeInstance)",
+			0x0008: "ReadEA",
+			0x0010: "WriteEA",
+			0x0020: "Execute/Traverse",
+			0x0040: "DeleteChild",
+			0x0080: "ReadAttributes",
+			0x0100: "WriteAttributes",
+		},
+		"Key": {
+			0x0001: "Query key value",
+			0x0002: "Set key value",
+			0x0004: "Create sub-key",
+			0x0008: "Enumerate sub-keys",
+			0x0010: "Notify about changes to keys",
+			0x0020: "Create Link",
+		},
+	}
+
+	// The flags of the NewUacValue and OldUacValue fields, by bit.
+	uac_flags = []string{
+		"Account Disabled",
+		"Home Directory Required",
+		"Password Not Required",
+		"Temp Duplicate Account",
+		"Normal Account",
+		"MNS Logon Account",
+		"Interdomain Trust Account",
+		"Workstation Trust Account",
+		"Server Trust Account",
+		"Don't Expire Password",
+		"Account Locked",
+		"Encrypted Text Password Allowed",
+		"Smartcard Required",
+		"Trusted For Delegation",
+		"Not Delegated",
+		"Use DES Key Only",
+		"Don't Require Preauth",
+		"Password Expired",
+		"Trusted To Authenticate For Delegation",
+		"Exclude Authorization Information",
+		"Undefined UserAccountControl Bit 20",
+		"Protect Kerberos Service Tickets with AES Keys",
+	}
+
+	// The %%NNNN parameter strings of the Security auditing events,
+	// used when no message source has them.
+	security_parameters = buildSecurityParameters()
+)
+
+func buildSecurityParameters() map[int]string {
+	result := map[int]string{
+		1537: "DELETE",
+		1538: "READ_CONTROL",
+		1539: "WRITE_DAC",
+		1540: "WRITE_OWNER",
+		1541: "SYNCHRONIZE",
+		1542: "ACCESS_SYS_SEC",
+		1832: "Identification",
+		1833: "Impersonation",
+		1840: "Delegation",
+		1841: "Denied by Process Trust Label ACE",
+		1842: "Yes",
+		1843: "No",
+		1844: "System",
+		1845: "Not Available",
+		1846: "Default",
+		1847: "DisallowMmConfig",
+		1848: "Off",
+		1849: "Auto",
+		1936: "Type 1 is a full token with no privileges removed or groups disabled.",
+		1937: "Type 2 is an elevated token with no privileges removed or groups disabled.",
+		1938: "Type 3 is a limited token with administrative privileges removed and administrative groups disabled.",
+		2304: "An Error occured during Logon.",
+		2305: "The specified user account has expired.",
+		2306: "The NetLogon component is not active.",
+		2307: "Account locked out.",
+		2308: "The user has not been granted the requested logon type at this machine.",
+		2309: "The specified account's password has expired.",
+		2310: "Account currently disabled.",
+		2311: "Account logon time restriction violation.",
+		2312: "User not allowed to logon at this computer.",
+		2313: "Unknown user name or bad password.",
+	}
+
+	for mask, name := range object_access_rights["File"] {
+		result[4416+bitIndex(mask)] = name
+	}
+	for mask, name := range object_access_rights["Key"] {
+		result[4432+bitIndex(mask)] = name
+	}
+
+	// The UserAccountControl field lists the changed flags: 2048 +
+	// bit when a flag was cleared and 2080 + bit when it was set.
+	for bit, name := range uac_flags {
+		switch bit {
+		case 0:
+			result[2048] = "Account Enabled"
+			result[2080] = "Account Disabled"
+		case 10:
+			result[2058] = "Account Unlocked"
+			result[2090] = "Account Locked"
+		default:
+			result[2048+bit] = "'" + name + "' - Disabled"
+			result[2080+bit] = "'" + name + "' - Enabled"
+		}
+	}
+
+	return result
+}
+
+func bitIndex(mask uint64) int {
+	for bit := 0; bit < 64; bit++ {
+		if mask == uint64(1)<<bit {
+			return bit
+		}
+	}
+	return -1
+}
+
+// Codes are numbers or hex strings like 0xc000006d.
+func decodeNumber(value interface{}) (uint64, bool) {
+	str, ok := value.(string)
+	if !ok {
+		number, ok := toInt64(value)
+
+		// Negative 32 bit values are NTSTATUS codes like 0xC000006D.
+		if number < 0 && number >= math.MinInt32 {
+			return uint64(uint32(number)), ok
+		}
+		return uint64(number), ok
+	}
+
+	str = strings.TrimSpace(str)
+	if strings.HasPrefix(strings.ToLower(str), "0x") {
+		number, err := strconv.ParseUint(str[2:], 16, 64)
+		return number, err == nil
+	}
+
+	number, err := strconv.ParseUint(str, 10, 64)
+	return number, err == nil
+}
+
+// The names of the bits set in the mask, in order.
+func flagNames(mask uint64, names map[uint64]string) []string {
+	result := []string{}
+	for bit := 0; bit < 64; bit++ {
+		name, pres := names[uint64(1)<<bit]
+		if pres && mask&(uint64(1)<<bit) != 0 {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func isSecurityAuditingEvent(event *ordereddict.Dict) bool {
+	provider, _ := ordereddict.GetString(event, "System.Provider.Name")
+	guid, _ := ordereddict.GetString(event, "System.Provider.Guid")
+	return strings.EqualFold(provider, "Microsoft-Windows-Security-Auditing") ||
+		NormalizeGUID(guid) == SECURITY_AUDITING_GUID
+}
+
+// DecodeSecurityEventData adds readable names of the codes in the
+// EventData of Security auditing events as <Key>Name siblings:
+// NTSTATUS and Kerberos Status and SubStatus codes, LogonType,
+// TicketEncryptionType, AccessMask, NewUacValue and OldUacValue and
+// %%NNNN parameters, e.g. LogonType: 3 and LogonTypeName:
+// "Network". The tables are built in so no message source is
+// needed. Values which are not known are left alone.
+func DecodeSecurityEventData(event *ordereddict.Dict) {
+	if !isSecurityAuditingEvent(event) {
+		return
+	}
+
+	data, pres := ordereddict.GetMap(event, "EventData")
+	if !pres {
+		return
+	}
+
+	event_id, _ := ordereddict.GetInt(event, "System.EventID.Value")
+	object_type, _ := data.GetString("ObjectType")
+
+	rewriteDict(data, func(key string, value interface{}) []dictItem {
+		item := []dictItem{{key, value}}
+
+		// Do not clobber an existing field.
+		_, pres := data.Get(key + "Name")
+		if pres {
+			return item
+		}
+
+		var name interface{}
+		switch key {
+		case "Status", "SubStatus":
+			code, ok := decodeNumber(value)
+			if ok {
+				codes := ntstatus_codes
+				if kerberos_events[event_id] {
+					codes = kerberos_codes
+				}
+				name = lookupCode(codes, code)
+			}
+
+		case "LogonType":
+			code, ok := decodeNumber(value)
+			if ok {
+				name = lookupCode(logon_types, code)
+			}
+
+		case "TicketEncryptionType":
+			code, ok := decodeNumber(value)
+			if ok {
+				name = lookupCode(ticket_encryption_types, code)
+			}
+
+		case "AccessMask":
+			mask, ok := decodeNumber(value)
+			if ok {
+				names := flagNames(mask, object_access_rights[object_type])
+				names = append(names, flagNames(mask, standard_access_rights)...)
+				if len(names) > 0 {
+					name = names
+				}
+			}
+
+		case "NewUacValue", "OldUacValue":
+			mask, ok := decodeNumber(value)
+			if ok {
+				names := []string{}
+				for bit, flag := range uac_flags {
+					if mask&(uint64(1)<<bit) != 0 {
+						names = append(names, flag)
+					}
+				}
+				if len(names) > 0 {
+					name = names
+				}
+			}
+
+		default:
+			str, ok := value.(string)
+			if ok {
+				name = decodeSecurityParameters(str)
+			}
+		}
+
+		if name == nil {
+			return item
+		}
+		return append(item, dictItem{key + "Name", name})
+	})
+}
+
+func lookupCode(codes map[uint64]string, code uint64) interface{} {
+	name, pres := codes[code]
+	if !pres {
+		return nil
+	}
+	return name
+}
+
+// Fields like AccessList hold a list of %%NNNN references. A single
+// reference decodes to a string, several to a list.
+func decodeSecurityParameters(value string) interface{} {
+	matches := parameter_reference_re.FindAllStringSubmatch(value, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	names := []string{}
+	for _, match := range matches {
+		param_id, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil
+		}
+
+		name, pres := security_parameters[param_id]
+		if !pres {
+			return nil
+		}
+		names = append(names, name)
+	}
+
+	if len(names) == 1 {
+		return names[0]
+	}
+	return names
+}
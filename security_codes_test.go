@@ -0,0 +1,109 @@
+package evtx
+
+import (
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+)
+
+func securityEvent(event_id int, data *ordereddict.Dict) *ordereddict.Dict {
+	return ordereddict.NewDict().
+		Set("System", ordereddict.NewDict().
+			Set("Provider", ordereddict.NewDict().
+				Set("Name", "Microsoft-Windows-Security-Auditing").
+				Set("Guid", "{54849625-5478-4994-a5ba-3e3b0328c30d}")).
+			Set("EventID", ordereddict.NewDict().
+				Set("Value", event_id))).
+		Set("EventData", data)
+}
+
+func TestDecodeSecurityLogon(t *testing.T) {
+	event := securityEvent(4625, ordereddict.NewDict().
+		Set("Status", uint32(0xC000006D)).
+		Set("FailureReason", "%%2313").
+		Set("SubStatus", "0xc000006a").
+		Set("LogonType", uint32(3)).
+		Set("ImpersonationLevel", "%%1833").
+		Set("TokenElevationType", "%%9999"))
+
+	DecodeSecurityEventData(event)
+
+	data, _ := ordereddict.GetMap(event, "EventData")
+	assert.Equal(t, []string{"Status", "StatusName", "FailureReason",
+		"FailureReasonName", "SubStatus", "SubStatusName", "LogonType",
+		"LogonTypeName", "ImpersonationLevel", "ImpersonationLevelName",
+		"TokenElevationType"}, data.Keys())
+
+	status, _ := data.GetString("StatusName")
+	assert.Equal(t, "Unknown user name or bad password", status)
+
+	sub_status, _ := data.GetString("SubStatusName")
+	assert.Equal(t, "User name is correct but the password is wrong", sub_status)
+
+	logon_type, _ := data.GetString("LogonTypeName")
+	assert.Equal(t, "Network", logon_type)
+
+	reason, _ := data.GetString("FailureReasonName")
+	assert.Equal(t, "Unknown user name or bad password.", reason)
+}
+
+func TestDecodeSecurityKerberos(t *testing.T) {
+	event := securityEvent(4771, ordereddict.NewDict().
+		Set("Status", "0x18").
+		Set("TicketEncryptionType", uint32(0x17)))
+
+	DecodeSecurityEventData(event)
+
+	status, _ := ordereddict.GetString(event, "EventData.StatusName")
+	assert.Equal(t, "Pre-authentication information was invalid", status)
+
+	encryption, _ := ordereddict.GetString(event, "EventData.TicketEncryptionTypeName")
+	assert.Equal(t, "RC4-HMAC", encryption)
+}
+
+func TestDecodeSecurityFlags(t *testing.T) {
+	event := securityEvent(4663, ordereddict.NewDict().
+		Set("ObjectType", "File").
+		Set("AccessList", "%%1537\n\t\t\t\t%%4416\n\t\t\t\t").
+		Set("AccessMask", uint32(0x10001)))
+
+	DecodeSecurityEventData(event)
+
+	data, _ := ordereddict.GetMap(event, "EventData")
+	access_list, _ := data.Get("AccessListName")
+	assert.Equal(t, []string{"DELETE", "ReadData (or ListDirectory)"}, access_list)
+
+	access_mask, _ := data.Get("AccessMaskName")
+	assert.Equal(t, []string{"ReadData (or ListDirectory)", "DELETE"}, access_mask)
+
+	event = securityEvent(4720, ordereddict.NewDict().
+		Set("UserAccountControl", "\n\t\t%%2080\n\t\t%%2082\n\t\t%%2084").
+		Set("NewUacValue", "0x15").
+		Set("NewUacValueName", "Existing"))
+
+	DecodeSecurityEventData(event)
+
+	data, _ = ordereddict.GetMap(event, "EventData")
+	uac, _ := data.Get("UserAccountControlName")
+	assert.Equal(t, []string{"Account Disabled",
+		"'Password Not Required' - Enabled",
+		"'Normal Account' - Enabled"}, uac)
+
+	// Existing fields are not clobbered.
+	new_uac, _ := data.GetString("NewUacValueName")
+	assert.Equal(t, "Existing", new_uac)
+}
+
+func TestDecodeSecurityOtherProviders(t *testing.T) {
+	event := securityEvent(4625, ordereddict.NewDict().
+		Set("LogonType", 3))
+	event.Set("System", ordereddict.NewDict().
+		Set("Provider", ordereddict.NewDict().
+			Set("Name", "Service Control Manager")))
+
+	DecodeSecurityEventData(event)
+
+	data, _ := ordereddict.GetMap(event, "EventData")
+	assert.Equal(t, []string{"LogonType"}, data.Keys())
+}
@@ -0,0 +1,314 @@
+package evtx
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+var (
+	sid_re = regexp.MustCompile(`^S-1-[0-9]+(-[0-9]+)+$`)
+
+	// Accounts and groups which have the same SID everywhere.
+	well_known_sids = map[string]string{
+		"S-1-0-0":      "NULL SID",
+		"S-1-1-0":      "Everyone",
+		"S-1-2-0":      "LOCAL",
+		"S-1-2-1":      "CONSOLE LOGON",
+		"S-1-3-0":      "CREATOR OWNER",
+		"S-1-3-1":      "CREATOR GROUP",
+		"S-1-3-4":      "OWNER RIGHTS",
+		"S-1-5-1":      `NT AUTHORITY\DIALUP`,
+		"S-1-5-2":      `NT AUTHORITY\NETWORK`,
+		"S-1-5-3":      `NT AUTHORITY\BATCH`,
+		"S-1-5-4":      `NT AUTHORITY\INTERACTIVE`,
+		"S-1-5-6":      `NT AUTHORITY\SERVICE`,
+		"S-1-5-7":      `NT AUTHORITY\ANONYMOUS LOGON`,
+		"S-1-5-9":      `NT AUTHORITY\ENTERPRISE DOMAIN CONTROLLERS`,
+		"S-1-5-10":     `NT AUTHORITY\SELF`,
+		"S-1-5-11":     `NT AUTHORITY\Authenticated Users`,
+		"S-1-5-12":     `NT AUTHORITY\RESTRICTED`,
+		"S-1-5-13":     `NT AUTHORITY\TERMINAL SERVER USER`,
+		"S-1-5-14":     `NT AUTHORITY\REMOTE INTERACTIVE LOGON`,
+		"S-1-5-15":     `NT AUTHORITY\This Organization`,
+		"S-1-5-17":     `NT AUTHORITY\IUSR`,
+		"S-1-5-18":     `NT AUTHORITY\SYSTEM`,
+		"S-1-5-19":     `NT AUTHORITY\LOCAL SERVICE`,
+		"S-1-5-20":     `NT AUTHORITY\NETWORK SERVICE`,
+		"S-1-5-32-544": `BUILTIN\Administrators`,
+		"S-1-5-32-545": `BUILTIN\Users`,
+		"S-1-5-32-546": `BUILTIN\Guests`,
+		"S-1-5-32-547": `BUILTIN\Power Users`,
+		"S-1-5-32-548": `BUILTIN\Account Operators`,
+		"S-1-5-32-549": `BUILTIN\Server Operators`,
+		"S-1-5-32-550": `BUILTIN\Print Operators`,
+		"S-1-5-32-551": `BUILTIN\Backup Operators`,
+		"S-1-5-32-552": `BUILTIN\Replicator`,
+		"S-1-5-32-554": `BUILTIN\Pre-Windows 2000 Compatible Access`,
+		"S-1-5-32-555": `BUILTIN\Remote Desktop Users`,
+		"S-1-5-32-556": `BUILTIN\Network Configuration Operators`,
+		"S-1-5-32-558": `BUILTIN\Performance Monitor Users`,
+		"S-1-5-32-559": `BUILTIN\Performance Log Users`,
+		"S-1-5-32-560": `BUILTIN\Windows Authorization Access Group`,
+		"S-1-5-32-562": `BUILTIN\Distributed COM Users`,
+		"S-1-5-32-568": `BUILTIN\IIS_IUSRS`,
+		"S-1-5-32-569": `BUILTIN\Cryptographic Operators`,
+		"S-1-5-32-573": `BUILTIN\Event Log Readers`,
+		"S-1-5-32-574": `BUILTIN\Certificate Service DCOM Access`,
+		"S-1-5-32-578": `BUILTIN\Hyper-V Administrators`,
+		"S-1-5-32-579": `BUILTIN\Access Control Assistance Operators`,
+		"S-1-5-32-580": `BUILTIN\Remote Management Users`,
+		"S-1-5-64-10":  `NT AUTHORITY\NTLM Authentication`,
+		"S-1-5-64-14":  `NT AUTHORITY\SChannel Authentication`,
+		"S-1-5-64-21":  `NT AUTHORITY\Digest Authentication`,
+		"S-1-5-80-0":   `NT SERVICE\ALL SERVICES`,
+		"S-1-5-113":    `NT AUTHORITY\Local account`,
+		"S-1-5-114":    `NT AUTHORITY\Local account and member of Administrators group`,
+		"S-1-16-0":     `Mandatory Label\Untrusted Mandatory Level`,
+		"S-1-16-4096":  `Mandatory Label\Low Mandatory Level`,
+		"S-1-16-8192":  `Mandatory Label\Medium Mandatory Level`,
+		"S-1-16-8448":  `Mandatory Label\Medium Plus Mandatory Level`,
+		"S-1-16-12288": `Mandatory Label\High Mandatory Level`,
+		"S-1-16-16384": `Mandatory Label\System Mandatory Level`,
+		"S-1-16-20480": `Mandatory Label\Protected Process Mandatory Level`,
+		"S-1-16-28672": `Mandatory Label\Secure Process Mandatory Level`,
+	}
+
+	// Accounts and groups with the same relative id (the last
+	// component of the SID) in every domain or machine.
+	well_known_rids = map[int64]string{
+		500: "Administrator",
+		501: "Guest",
+		502: "krbtgt",
+		503: "DefaultAccount",
+		504: "WDAGUtilityAccount",
+		512: "Domain Admins",
+		513: "Domain Users",
+		514: "Domain Guests",
+		515: "Domain Computers",
+		516: "Domain Controllers",
+		517: "Cert Publishers",
+		518: "Schema Admins",
+		519: "Enterprise Admins",
+		520: "Group Policy Creator Owners",
+		521: "Read-only Domain Controllers",
+		522: "Cloneable Domain Controllers",
+		525: "Protected Users",
+		526: "Key Admins",
+		527: "Enterprise Key Admins",
+		553: "RAS and IAS Servers",
+		571: "Allowed RODC Password Replication Group",
+		572: "Denied RODC Password Replication Group",
+	}
+
+	// Event data fields which name the account of a SID field.
+	sid_name_fields = []struct {
+		sid, name, domain string
+	}{
+		{"SubjectUserSid", "SubjectUserName", "SubjectDomainName"},
+		{"TargetUserSid", "TargetUserName", "TargetDomainName"},
+		{"TargetSid", "TargetUserName", "TargetDomainName"},
+		{"MemberSid", "MemberName", ""},
+	}
+)
+
+// SIDResolver resolves SIDs to account names. Well-known SIDs are
+// built in. Other accounts are learned from events which give both
+// the SID and the name, e.g. SubjectUserSid and SubjectUserName.
+// Learn() all the events before resolving any, so events get names
+// from accounts which are only named later in the logs.
+type SIDResolver struct {
+	mu sync.Mutex
+
+	// Learned account names by SID.
+	accounts map[string]learnedName
+
+	// Learned domain names by domain SID, used to qualify well-known
+	// relative ids.
+	domains map[string]learnedName
+}
+
+// A name and the time of the event it was learned from. Renamed
+// accounts get their latest name whatever order the events are
+// learned in.
+type learnedName struct {
+	name string
+	time float64
+}
+
+func (self learnedName) newerThan(other learnedName) bool {
+	if self.time != other.time {
+		return self.time > other.time
+	}
+	return self.name < other.name
+}
+
+func learnName(names map[string]learnedName, key string, name learnedName) {
+	existing, pres := names[key]
+	if !pres || name.newerThan(existing) {
+		names[key] = name
+	}
+}
+
+func NewSIDResolver() *SIDResolver {
+	return &SIDResolver{
+		accounts: make(map[string]learnedName),
+		domains:  make(map[string]learnedName),
+	}
+}
+
+// Learn remembers the accounts named in the event.
+func (self *SIDResolver) Learn(event *ordereddict.Dict) {
+	data, pres := ordereddict.GetMap(event, "EventData")
+	if !pres {
+		return
+	}
+
+	time := getEventTime(event)
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for _, fields := range sid_name_fields {
+		sid, _ := data.GetString(fields.sid)
+		name, _ := data.GetString(fields.name)
+		if !sid_re.MatchString(sid) || !isAccountName(name) ||
+			sid == "S-1-0-0" {
+			continue
+		}
+
+		domain := ""
+		if fields.domain != "" {
+			domain, _ = data.GetString(fields.domain)
+		}
+
+		if isAccountName(domain) {
+			learnName(self.accounts, sid, learnedName{domain + `\` + name, time})
+
+			domain_sid, _, ok := splitDomainSID(sid)
+			if ok {
+				learnName(self.domains, domain_sid, learnedName{domain, time})
+			}
+		} else {
+			learnName(self.accounts, sid, learnedName{name, time})
+		}
+	}
+}
+
+// Lookup returns the account name of the SID, or "" if it is not
+// known.
+func (self *SIDResolver) Lookup(sid string) string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	// Events sometimes name well-known accounts differently, e.g.
+	// SYSTEM as the machine account.
+	name, pres := well_known_sids[sid]
+	if pres {
+		return name
+	}
+
+	account, pres := self.accounts[sid]
+	if pres {
+		return account.name
+	}
+
+	domain_sid, rid, ok := splitDomainSID(sid)
+	if !ok {
+		return ""
+	}
+
+	name, pres = well_known_rids[rid]
+	if !pres {
+		return ""
+	}
+
+	domain, pres := self.domains[domain_sid]
+	if pres {
+		return domain.name + `\` + name
+	}
+	return name
+}
+
+// ResolveSIDs adds the names of the SIDs in System.Security.UserID,
+// EventData and UserData as <Key>Name siblings, e.g. UserID:
+// "S-1-5-18" and UserIDName: "NT AUTHORITY\SYSTEM". SIDs whose
+// account the event already names are left alone.
+func (self *SIDResolver) ResolveSIDs(event *ordereddict.Dict) {
+	security, pres := ordereddict.GetMap(event, "System.Security")
+	if pres {
+		self.resolveSIDsInDict(security)
+	}
+
+	for _, section := range []string{"EventData", "UserData"} {
+		data, pres := ordereddict.GetMap(event, section)
+		if pres {
+			self.resolveSIDsInDict(data)
+		}
+	}
+}
+
+func (self *SIDResolver) resolveSIDsInDict(dict *ordereddict.Dict) {
+	// The fields which already have their account name.
+	named := make(map[string]bool)
+	for _, fields := range sid_name_fields {
+		name, _ := dict.GetString(fields.name)
+		if isAccountName(name) {
+			named[fields.sid] = true
+		}
+	}
+
+	rewriteDict(dict, func(key string, value interface{}) []dictItem {
+		item := []dictItem{{key, value}}
+
+		switch t := value.(type) {
+		case *ordereddict.Dict:
+			self.resolveSIDsInDict(t)
+
+		case string:
+			if named[key] || !sid_re.MatchString(t) {
+				return item
+			}
+
+			// Do not clobber an existing field.
+			_, pres := dict.Get(key + "Name")
+			if pres {
+				return item
+			}
+
+			name := self.Lookup(t)
+			if name != "" {
+				return append(item, dictItem{key + "Name", name})
+			}
+		}
+		return item
+	})
+}
+
+// Split a domain or machine account SID (S-1-5-21-X-Y-Z-RID) into
+// the domain SID and the relative id.
+func splitDomainSID(sid string) (string, int64, bool) {
+	if !strings.HasPrefix(sid, "S-1-5-21-") {
+		return "", 0, false
+	}
+
+	idx := strings.LastIndex(sid, "-")
+	rid, err := strconv.ParseInt(sid[idx+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	domain_sid := sid[:idx]
+	if strings.Count(domain_sid, "-") != 6 {
+		return "", 0, false
+	}
+	return domain_sid, rid, true
+}
+
+// Events use "-" when there is no account.
+func isAccountName(name string) bool {
+	return name != "" && name != "-"
+}
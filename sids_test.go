@@ -0,0 +1,104 @@
+package evtx
+
+import (
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+)
+
+const test_domain_sid = "S-1-5-21-546003962-2713609280-610790815"
+
+func TestSIDResolver(t *testing.T) {
+	resolver := NewSIDResolver()
+	assert.Equal(t, `NT AUTHORITY\SYSTEM`, resolver.Lookup("S-1-5-18"))
+	assert.Equal(t, `BUILTIN\Administrators`, resolver.Lookup("S-1-5-32-544"))
+	assert.Equal(t, "Domain Admins", resolver.Lookup(test_domain_sid+"-512"))
+	assert.Equal(t, "", resolver.Lookup(test_domain_sid+"-1001"))
+	assert.Equal(t, "", resolver.Lookup("S-1-5-21-1-2-512"))
+
+	// A logon teaches the account and the name of its domain.
+	resolver.Learn(ordereddict.NewDict().
+		Set("EventData", ordereddict.NewDict().
+			Set("SubjectUserSid", "S-1-5-18").
+			Set("SubjectUserName", "TESTCOMPUTER$").
+			Set("SubjectDomainName", "WORKGROUP").
+			Set("TargetUserSid", test_domain_sid+"-1001").
+			Set("TargetUserName", "user").
+			Set("TargetDomainName", "TESTCOMPUTER")))
+
+	assert.Equal(t, `TESTCOMPUTER\user`, resolver.Lookup(test_domain_sid+"-1001"))
+	assert.Equal(t, `TESTCOMPUTER\Domain Admins`, resolver.Lookup(test_domain_sid+"-512"))
+
+	// Well-known SIDs keep their names.
+	assert.Equal(t, `NT AUTHORITY\SYSTEM`, resolver.Lookup("S-1-5-18"))
+}
+
+func TestResolveSIDs(t *testing.T) {
+	resolver := NewSIDResolver()
+	resolver.Learn(ordereddict.NewDict().
+		Set("EventData", ordereddict.NewDict().
+			Set("TargetUserSid", test_domain_sid+"-1001").
+			Set("TargetUserName", "user").
+			Set("TargetDomainName", "TESTCOMPUTER")))
+
+	event := ordereddict.NewDict().
+		Set("System", ordereddict.NewDict().
+			Set("Security", ordereddict.NewDict().
+				Set("UserID", test_domain_sid+"-1001"))).
+		Set("EventData", ordereddict.NewDict().
+			Set("SubjectUserSid", "S-1-5-18").
+			Set("SubjectUserName", "SYSTEM").
+			Set("MemberSid", test_domain_sid+"-500").
+			Set("MemberName", "-").
+			Set("Other", "S-1-5-32-545").
+			Set("OtherName", "Mine").
+			Set("NotASid", "S-1-x"))
+
+	resolver.ResolveSIDs(event)
+
+	user_name, _ := ordereddict.GetString(event, "System.Security.UserIDName")
+	assert.Equal(t, `TESTCOMPUTER\user`, user_name)
+
+	// SIDs the event already names and existing fields are left
+	// alone.
+	data, _ := ordereddict.GetMap(event, "EventData")
+	assert.Equal(t, []string{"SubjectUserSid", "SubjectUserName",
+		"MemberSid", "MemberSidName", "MemberName", "Other", "OtherName",
+		"NotASid"}, data.Keys())
+
+	member_name, _ := data.GetString("MemberSidName")
+	assert.Equal(t, `TESTCOMPUTER\Administrator`, member_name)
+}
+
+func TestSIDResolverOrder(t *testing.T) {
+	logon := func(time float64, name string) *ordereddict.Dict {
+		return ordereddict.NewDict().
+			Set("System", ordereddict.NewDict().
+				Set("TimeCreated", ordereddict.NewDict().
+					Set("SystemTime", time))).
+			Set("EventData", ordereddict.NewDict().
+				Set("TargetUserSid", test_domain_sid+"-1001").
+				Set("TargetUserName", name).
+				Set("TargetDomainName", "TESTCOMPUTER"))
+	}
+
+	// The account was renamed: the latest name wins whatever order
+	// the events are learned in.
+	for _, events := range [][]*ordereddict.Dict{
+		{logon(100, "old"), logon(200, "new")},
+		{logon(200, "new"), logon(100, "old")},
+	} {
+		resolver := NewSIDResolver()
+		for _, event := range events {
+			resolver.Learn(event)
+		}
+		assert.Equal(t, `TESTCOMPUTER\new`, resolver.Lookup(test_domain_sid+"-1001"))
+	}
+
+	// Resolving does not learn, so the names do not depend on the
+	// order events are resolved in.
+	resolver := NewSIDResolver()
+	resolver.ResolveSIDs(logon(100, "user"))
+	assert.Equal(t, "", resolver.Lookup(test_domain_sid+"-1001"))
+}
@@ -0,0 +1,127 @@
+package evtx
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	errors "github.com/pkg/errors"
+)
+
+var (
+	// Split images are usually named image.001, image.002 ...
+	split_image_re = regexp.MustCompile(`^(.+\.)([0-9]{3,})$`)
+)
+
+// SplitReader presents a number of files as one contiguous
+// io.ReaderAt (e.g. a split dd image).
+type SplitReader struct {
+	files   []*os.File
+	offsets []int64
+	size    int64
+}
+
+// OpenSplitImage opens a disk image. If the filename looks like the
+// first part of a split image (e.g. image.001) all following parts
+// are opened as well.
+func OpenSplitImage(filename string) (*SplitReader, error) {
+	filenames := []string{filename}
+
+	match := split_image_re.FindStringSubmatch(filename)
+	if match != nil {
+		width := len(match[2])
+		first, _ := strconv.Atoi(match[2])
+		for i := first + 1; ; i++ {
+			next := fmt.Sprintf("%s%0*d", match[1], width, i)
+			_, err := os.Stat(next)
+			if err != nil {
+				break
+			}
+			filenames = append(filenames, next)
+		}
+	}
+
+	return NewSplitReader(filenames)
+}
+
+func NewSplitReader(filenames []string) (*SplitReader, error) {
+	self := &SplitReader{}
+	for _, filename := range filenames {
+		fd, err := os.Open(filename)
+		if err != nil {
+			self.Close()
+			return nil, err
+		}
+
+		stat, err := fd.Stat()
+		if err != nil {
+			fd.Close()
+			self.Close()
+			return nil, err
+		}
+
+		self.files = append(self.files, fd)
+		self.offsets = append(self.offsets, self.size)
+		self.size += stat.Size()
+	}
+
+	return self, nil
+}
+
+func (self *SplitReader) Size() int64 {
+	return self.size
+}
+
+func (self *SplitReader) ReadAt(buf []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("Invalid offset")
+	}
+
+	total := 0
+	for i, fd := range self.files {
+		if len(buf) == 0 {
+			break
+		}
+
+		start := self.offsets[i]
+		end := self.size
+		if i+1 < len(self.offsets) {
+			end = self.offsets[i+1]
+		}
+
+		if offset >= end {
+			continue
+		}
+
+		to_read := buf
+		if int64(len(to_read)) > end-offset {
+			to_read = to_read[:end-offset]
+		}
+
+		n, err := fd.ReadAt(to_read, offset-start)
+		total += n
+		offset += int64(n)
+		buf = buf[n:]
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			return total, err
+		}
+		if n < len(to_read) {
+			break
+		}
+	}
+
+	if len(buf) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+func (self *SplitReader) Close() error {
+	for _, fd := range self.files {
+		fd.Close()
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package evtx
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitReader(t *testing.T) {
+	// Three segments of 5, 3 and 7 bytes holding the bytes 0 to 14.
+	dir := t.TempDir()
+	data := []byte{}
+	for i := 0; i < 15; i++ {
+		data = append(data, byte(i))
+	}
+
+	for i, segment := range [][]byte{data[:5], data[5:8], data[8:]} {
+		filename := filepath.Join(dir, fmt.Sprintf("image.%03d", i+1))
+		require.NoError(t, os.WriteFile(filename, segment, 0600))
+	}
+
+	// A gap in the numbering ends the image.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "image.005"), data, 0600))
+
+	reader, err := OpenSplitImage(filepath.Join(dir, "image.001"))
+	require.NoError(t, err)
+	defer reader.Close()
+
+	assert.Equal(t, int64(15), reader.Size())
+
+	for _, tc := range []struct {
+		name   string
+		offset int64
+		length int
+		n      int
+		eof    bool
+	}{
+		{"Within the first segment", 1, 3, 3, false},
+		{"Up to the end of a segment", 2, 3, 3, false},
+		{"At the start of a segment", 5, 2, 2, false},
+		{"Within the middle segment", 6, 1, 1, false},
+		{"Across one boundary", 3, 4, 4, false},
+		{"Across two boundaries", 4, 6, 6, false},
+		{"All the segments", 0, 15, 15, false},
+		{"Past the end", 12, 5, 3, true},
+		{"After the end", 15, 4, 0, true},
+		{"Empty read", 7, 0, 0, false},
+	} {
+		buf := make([]byte, tc.length)
+		n, err := reader.ReadAt(buf, tc.offset)
+		assert.Equal(t, tc.n, n, tc.name)
+		assert.Equal(t, data[tc.offset:tc.offset+int64(n)], buf[:n], tc.name)
+		if tc.eof {
+			assert.Equal(t, io.EOF, err, tc.name)
+		} else {
+			assert.NoError(t, err, tc.name)
+		}
+	}
+
+	_, err = reader.ReadAt(make([]byte, 1), -1)
+	assert.Error(t, err)
+
+	// Other files are opened on their own.
+	reader, err = OpenSplitImage(filepath.Join(dir, "image.005"))
+	require.NoError(t, err)
+	defer reader.Close()
+
+	assert.Equal(t, int64(15), reader.Size())
+}
@@ -0,0 +1,7 @@
+//go:build cgo
+// +build cgo
+
+package evtx
+
+// Message databases are read with the cgo sqlite driver.
+const SQLITE_SUPPORTED = true
@@ -0,0 +1,8 @@
+//go:build !cgo
+// +build !cgo
+
+package evtx
+
+// Builds without cgo can not read message databases, only message
+// bundles.
+const SQLITE_SUPPORTED = false
@@ -0,0 +1,19 @@
+//go:build !cgo
+// +build !cgo
+
+package evtx
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageDBWithoutCgo(t *testing.T) {
+	_, err := NewDBResolver(filepath.Join(t.TempDir(), "messages.db"))
+	assert.ErrorContains(t, err, "dumpevtx bundle")
+
+	_, err = MessageDBToBundle(filepath.Join(t.TempDir(), "messages.db"))
+	assert.ErrorContains(t, err, "dumpevtx bundle")
+}
@@ -0,0 +1,125 @@
+package evtx
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	errors "github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzip_magic = []byte{0x1f, 0x8b}
+	zstd_magic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xz_magic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// NewChunkFromBuffer creates a chunk from data which has already been
+// read into memory. Such chunks do not need a file to parse.
+func NewChunkFromBuffer(buf []byte, offset int64) (*Chunk, error) {
+	if len(buf) < EVTX_CHUNK_SIZE {
+		return nil, errors.New("Chunk buffer too small")
+	}
+
+	self := &Chunk{Offset: offset, data: buf}
+	err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &self.Header)
+	return self, errors.WithStack(err)
+}
+
+// ChunkStream reads chunks sequentially from a reader which does not
+// need to be seekable (e.g. a pipe or a decompressor).
+type ChunkStream struct {
+	reader io.Reader
+	offset int64
+
+	Header EVTXHeader
+}
+
+func NewChunkStream(reader io.Reader) (*ChunkStream, error) {
+	self := &ChunkStream{reader: reader}
+	err := binary.Read(reader, binary.LittleEndian, &self.Header)
+	if err != nil {
+		return nil, errors.Wrap(err, "Read")
+	}
+
+	if string(self.Header.Magic[:]) != EVTX_HEADER_MAGIC {
+		return nil, errors.New("File is not an EVTX file (wrong magic).")
+	}
+
+	if !is_supported(self.Header.MinorVersion, self.Header.MajorVersion) {
+		return nil, errors.New("Unsupported EVTX version.")
+	}
+
+	// Skip the rest of the header block.
+	self.offset = int64(binary.Size(self.Header))
+	to_skip := int64(self.Header.HeaderBlockSize) - self.offset
+	if to_skip > 0 {
+		n, err := io.CopyN(io.Discard, reader, to_skip)
+		self.offset += n
+		if err != nil {
+			return nil, errors.Wrap(err, "Read")
+		}
+	}
+
+	return self, nil
+}
+
+// Next returns the next valid chunk in the stream or io.EOF when the
+// stream is exhausted. Chunks which are not in use are skipped.
+func (self *ChunkStream) Next() (*Chunk, error) {
+	for {
+		buf := make([]byte, EVTX_CHUNK_SIZE)
+		n, err := io.ReadFull(self.reader, buf)
+		offset := self.offset
+		self.offset += int64(n)
+
+		// A truncated last chunk can not be parsed.
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "Read")
+		}
+
+		chunk, err := NewChunkFromBuffer(buf, offset)
+		if err != nil {
+			continue
+		}
+
+		if string(chunk.Header.Magic[:]) != EVTX_CHUNK_HEADER_MAGIC ||
+			chunk.Header.LastEventRecID == 0xffffffffffffffff {
+			continue
+		}
+
+		return chunk, nil
+	}
+}
+
+// NewDecompressingReader detects gzip, zstd and xz compressed data and
+// transparently decompresses it. Other data is passed through as is.
+func NewDecompressingReader(reader io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(len(xz_magic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzip_magic):
+		return gzip.NewReader(buffered)
+
+	case bytes.HasPrefix(magic, zstd_magic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+
+	case bytes.HasPrefix(magic, xz_magic):
+		return xz.NewReader(buffered)
+	}
+
+	return buffered, nil
+}
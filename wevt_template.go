@@ -0,0 +1,482 @@
+package evtx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	errors "github.com/pkg/errors"
+	"www.velocidex.com/golang/binparsergen/reader"
+	pe "www.velocidex.com/golang/go-pe"
+)
+
+/*
+  Providers with an instrumentation manifest ship a compiled version
+  of it as the WEVT_TEMPLATE resource of their message DLL. All the
+  offsets in the resource are relative to its start:
+
+  CRIM header: signature, size, major and minor version, number of
+  providers followed by (GUID, offset) pairs.
+
+  WEVT provider: signature, size, message id, number of elements,
+  number of unknowns followed by (offset, unknown) pairs pointing at
+  the elements. Each element starts with its signature and size:
+
+  CHAN - channels, EVNT - events, KEYW - keywords, LEVL - levels,
+  OPCO - opcodes, TASK - tasks, TTBL - templates, MAPS - value maps.
+
+  TEMP templates contain the BinXML of the event's data followed by
+  the descriptors of the template's fields (their names and types).
+*/
+
+const (
+	// Resources larger than this are not manifests.
+	MAX_WEVT_TEMPLATE_SIZE = 64 * 1024 * 1024
+
+	// Message id of elements without a message.
+	NO_MESSAGE_ID = 0xffffffff
+)
+
+// WEVTManifest is a parsed WEVT_TEMPLATE resource.
+type WEVTManifest struct {
+	Providers []*WEVTProvider
+}
+
+type WEVTProvider struct {
+	Guid      string
+	MessageId int64
+
+	Channels []*WEVTValue
+	Events   []*WEVTEvent
+	Keywords []*WEVTValue
+	Levels   []*WEVTValue
+	Opcodes  []*WEVTValue
+	Tasks    []*WEVTValue
+}
+
+// WEVTValue is a named value of a channel, keyword, level, opcode or
+// task. The message id is -1 if there is no message.
+type WEVTValue struct {
+	Value     uint64
+	Name      string
+	MessageId int64
+
+	// Opcodes may be specific to a task.
+	Task uint64
+}
+
+type WEVTEvent struct {
+	Id       int
+	Version  int
+	Channel  int
+	Level    int
+	Opcode   int
+	Task     int
+	Keywords uint64
+
+	MessageId int64
+
+	// Nil if the event has no data.
+	Template *WEVTTemplate
+}
+
+type WEVTTemplate struct {
+	Guid   string
+	Fields []*WEVTField
+}
+
+type WEVTField struct {
+	Name    string
+	InType  int
+	OutType int
+}
+
+// ChannelName returns the name of the channel with this value.
+func (self *WEVTProvider) ChannelName(value int) string {
+	for _, channel := range self.Channels {
+		if channel.Value == uint64(value) {
+			return channel.Name
+		}
+	}
+	return ""
+}
+
+// FieldNames returns the names of the event's data fields in order.
+func (self *WEVTEvent) FieldNames() []string {
+	result := []string{}
+	if self.Template != nil {
+		for _, field := range self.Template.Fields {
+			result = append(result, field.Name)
+		}
+	}
+	return result
+}
+
+type wevtParser struct {
+	data []byte
+
+	// Templates are shared between events.
+	templates map[uint32]*WEVTTemplate
+}
+
+func (self *wevtParser) check(offset, size uint32) error {
+	if uint64(offset)+uint64(size) > uint64(len(self.data)) {
+		return errors.Errorf("Offset %#x out of range", offset)
+	}
+	return nil
+}
+
+// Check an array of items. The size is calculated so it can not
+// overflow.
+func (self *wevtParser) checkArray(offset, count, item_size uint32) error {
+	if uint64(offset)+uint64(count)*uint64(item_size) > uint64(len(self.data)) {
+		return errors.Errorf("Array at offset %#x out of range", offset)
+	}
+	return nil
+}
+
+func (self *wevtParser) uint16(offset uint32) uint16 {
+	return binary.LittleEndian.Uint16(self.data[offset:])
+}
+
+func (self *wevtParser) uint32(offset uint32) uint32 {
+	return binary.LittleEndian.Uint32(self.data[offset:])
+}
+
+func (self *wevtParser) uint64(offset uint32) uint64 {
+	return binary.LittleEndian.Uint64(self.data[offset:])
+}
+
+func (self *wevtParser) guid(offset uint32) string {
+	d := self.data[offset:]
+	return fmt.Sprintf("%08X-%04X-%04X-%02X%02X-%02X%02X%02X%02X%02X%02X",
+		binary.LittleEndian.Uint32(d), binary.LittleEndian.Uint16(d[4:]),
+		binary.LittleEndian.Uint16(d[6:]), d[8], d[9],
+		d[10], d[11], d[12], d[13], d[14], d[15])
+}
+
+// The signature and the number of items of an element.
+func (self *wevtParser) header(offset uint32, signature string) (uint32, error) {
+	err := self.check(offset, 12)
+	if err != nil {
+		return 0, err
+	}
+
+	if string(self.data[offset:offset+4]) != signature {
+		return 0, errors.Errorf("Expected %v at %#x", signature, offset)
+	}
+	return self.uint32(offset + 8), nil
+}
+
+// Strings are prefixed by their size in bytes, including the size
+// itself.
+func (self *wevtParser) string(offset uint32) (string, error) {
+	if offset == 0 {
+		return "", nil
+	}
+
+	err := self.check(offset, 4)
+	if err != nil {
+		return "", err
+	}
+
+	size := self.uint32(offset)
+	if size < 4 {
+		return "", nil
+	}
+
+	err = self.check(offset, size)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(UTF16LEToUTF8(
+		self.data[offset+4:offset+size])), "\x00"), nil
+}
+
+func messageId(id uint32) int64 {
+	if id == NO_MESSAGE_ID {
+		return -1
+	}
+	return int64(id)
+}
+
+// ParseWEVTTemplate parses the content of a WEVT_TEMPLATE resource.
+func ParseWEVTTemplate(data []byte) (*WEVTManifest, error) {
+	self := &wevtParser{
+		data:      data,
+		templates: make(map[uint32]*WEVTTemplate),
+	}
+
+	err := self.check(0, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(self.data[:4]) != "CRIM" {
+		return nil, errors.New("Expected CRIM signature")
+	}
+
+	// The header has a version before the number of providers.
+	count := self.uint32(12)
+	err = self.checkArray(16, count, 20)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &WEVTManifest{}
+	for i := uint32(0); i < count; i++ {
+		descriptor := 16 + i*20
+		provider, err := self.parseProvider(self.uint32(descriptor + 16))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Provider %v", i)
+		}
+		provider.Guid = self.guid(descriptor)
+		result.Providers = append(result.Providers, provider)
+	}
+
+	return result, nil
+}
+
+func (self *wevtParser) parseProvider(offset uint32) (*WEVTProvider, error) {
+	err := self.check(offset, 20)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(self.data[offset:offset+4]) != "WEVT" {
+		return nil, errors.Errorf("Expected WEVT at %#x", offset)
+	}
+
+	result := &WEVTProvider{
+		MessageId: messageId(self.uint32(offset + 8)),
+	}
+
+	count := self.uint32(offset + 12)
+	err = self.checkArray(offset+20, count, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		element := self.uint32(offset + 20 + i*8)
+		err := self.check(element, 4)
+		if err != nil {
+			return nil, err
+		}
+
+		signature := string(self.data[element : element+4])
+		switch signature {
+		case "CHAN":
+			result.Channels, err = self.parseValues(element, signature, 16,
+				func(item uint32, value *WEVTValue) uint32 {
+					value.Value = uint64(self.uint32(item))
+					value.MessageId = messageId(self.uint32(item + 12))
+					return self.uint32(item + 4)
+				})
+
+		case "LEVL":
+			result.Levels, err = self.parseValues(element, signature, 12,
+				func(item uint32, value *WEVTValue) uint32 {
+					value.Value = uint64(self.uint32(item))
+					value.MessageId = messageId(self.uint32(item + 4))
+					return self.uint32(item + 8)
+				})
+
+		// The opcode is in the high word and the task it is
+		// specific to (if any) in the low word.
+		case "OPCO":
+			result.Opcodes, err = self.parseValues(element, signature, 12,
+				func(item uint32, value *WEVTValue) uint32 {
+					id := self.uint32(item)
+					value.Value = uint64(id >> 16)
+					value.Task = uint64(id & 0xffff)
+					value.MessageId = messageId(self.uint32(item + 4))
+					return self.uint32(item + 8)
+				})
+
+		case "TASK":
+			result.Tasks, err = self.parseValues(element, signature, 28,
+				func(item uint32, value *WEVTValue) uint32 {
+					value.Value = uint64(self.uint32(item))
+					value.MessageId = messageId(self.uint32(item + 4))
+					return self.uint32(item + 24)
+				})
+
+		case "KEYW":
+			result.Keywords, err = self.parseValues(element, signature, 16,
+				func(item uint32, value *WEVTValue) uint32 {
+					value.Value = self.uint64(item)
+					value.MessageId = messageId(self.uint32(item + 8))
+					return self.uint32(item + 12)
+				})
+
+		case "EVNT":
+			result.Events, err = self.parseEvents(element)
+
+		default:
+			// Templates are parsed with the events which
+			// use them.
+			continue
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, signature)
+		}
+	}
+
+	return result, nil
+}
+
+// Parse an element consisting of fixed size items. The callback fills
+// in the value and returns the offset of its name.
+func (self *wevtParser) parseValues(offset uint32, signature string,
+	item_size uint32,
+	cb func(item uint32, value *WEVTValue) uint32) ([]*WEVTValue, error) {
+	count, err := self.header(offset, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.checkArray(offset+12, count, item_size)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*WEVTValue{}
+	for i := uint32(0); i < count; i++ {
+		value := &WEVTValue{}
+		value.Name, err = self.string(cb(offset+12+i*item_size, value))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+func (self *wevtParser) parseEvents(offset uint32) ([]*WEVTEvent, error) {
+	count, err := self.header(offset, "EVNT")
+	if err != nil {
+		return nil, err
+	}
+
+	// There is an unknown field after the count.
+	err = self.checkArray(offset+16, count, 48)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*WEVTEvent{}
+	for i := uint32(0); i < count; i++ {
+		item := offset + 16 + i*48
+		event := &WEVTEvent{
+			Id:        int(self.uint16(item)),
+			Version:   int(self.data[item+2]),
+			Channel:   int(self.data[item+3]),
+			Level:     int(self.data[item+4]),
+			Opcode:    int(self.data[item+5]),
+			Task:      int(self.uint16(item + 6)),
+			Keywords:  self.uint64(item + 8),
+			MessageId: messageId(self.uint32(item + 16)),
+		}
+
+		template_offset := self.uint32(item + 20)
+		if template_offset != 0 {
+			event.Template, err = self.parseTemplate(template_offset)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Event %v", event.Id)
+			}
+		}
+
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+func (self *wevtParser) parseTemplate(offset uint32) (*WEVTTemplate, error) {
+	template, pres := self.templates[offset]
+	if pres {
+		return template, nil
+	}
+
+	count, err := self.header(offset, "TEMP")
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.check(offset, 40)
+	if err != nil {
+		return nil, err
+	}
+
+	// The field descriptors follow the template's BinXML.
+	items := self.uint32(offset + 16)
+	err = self.checkArray(items, count, 20)
+	if err != nil {
+		return nil, err
+	}
+
+	template = &WEVTTemplate{Guid: self.guid(offset + 24)}
+	for i := uint32(0); i < count; i++ {
+		item := items + i*20
+		name, err := self.string(self.uint32(item + 16))
+		if err != nil {
+			return nil, err
+		}
+
+		template.Fields = append(template.Fields, &WEVTField{
+			Name:    name,
+			InType:  int(self.data[item+4]),
+			OutType: int(self.data[item+5]),
+		})
+	}
+
+	self.templates[offset] = template
+	return template, nil
+}
+
+// ReadWEVTTemplate reads the manifest of a PE file. Returns nil if the
+// file has no manifest.
+func ReadWEVTTemplate(filename string) (*WEVTManifest, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	reader, err := reader.NewPagedReader(fd, 4096, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	pe_file, err := pe.NewPEFile(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, resource := range pe_file.Resources() {
+		resource_type, _ := resource.GetString("Type")
+		if resource_type != "WEVT_TEMPLATE" {
+			continue
+		}
+
+		offset, _ := resource.GetInt64("FileOffset")
+		size, _ := resource.GetInt64("DataSize")
+		if size <= 0 || size > MAX_WEVT_TEMPLATE_SIZE {
+			return nil, errors.Errorf("Invalid WEVT_TEMPLATE size %v", size)
+		}
+
+		data := make([]byte, size)
+		_, err := fd.ReadAt(data, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		return ParseWEVTTemplate(data)
+	}
+
+	return nil, nil
+}
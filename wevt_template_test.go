@@ -0,0 +1,180 @@
+package evtx
+
+import (
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Builds a WEVT_TEMPLATE resource for tests.
+type wevtBuilder struct {
+	data []byte
+}
+
+func (self *wevtBuilder) offset() uint32 {
+	return uint32(len(self.data))
+}
+
+func (self *wevtBuilder) bytes(data ...byte) {
+	self.data = append(self.data, data...)
+}
+
+func (self *wevtBuilder) uint16(value uint16) {
+	self.data = binary.LittleEndian.AppendUint16(self.data, value)
+}
+
+// Returns the offset of the value so it can be patched later.
+func (self *wevtBuilder) uint32(value uint32) uint32 {
+	offset := self.offset()
+	self.data = binary.LittleEndian.AppendUint32(self.data, value)
+	return offset
+}
+
+func (self *wevtBuilder) uint64(value uint64) {
+	self.data = binary.LittleEndian.AppendUint64(self.data, value)
+}
+
+func (self *wevtBuilder) patch(offset, value uint32) {
+	binary.LittleEndian.PutUint32(self.data[offset:], value)
+}
+
+func (self *wevtBuilder) string(value string) uint32 {
+	offset := self.offset()
+	encoded := utf16.Encode([]rune(value + "\x00"))
+	self.uint32(uint32(4 + 2*len(encoded)))
+	for _, c := range encoded {
+		self.uint16(c)
+	}
+	return offset
+}
+
+func buildTestManifest() []byte {
+	self := &wevtBuilder{}
+	guid := []byte{0x25, 0x96, 0x84, 0x54, 0x78, 0x54, 0x94, 0x49,
+		0xa5, 0xba, 0x3e, 0x3b, 0x03, 0x28, 0xc3, 0x0d}
+
+	self.bytes([]byte("CRIM")...)
+	self.uint32(0)
+	self.uint16(3)
+	self.uint16(1)
+	self.uint32(1)
+	self.bytes(guid...)
+	provider_offset := self.uint32(0)
+
+	self.patch(provider_offset, self.offset())
+	self.bytes([]byte("WEVT")...)
+	self.uint32(0)
+	self.uint32(0x90000001)
+	self.uint32(3)
+	self.uint32(0)
+	elements := []uint32{}
+	for i := 0; i < 3; i++ {
+		elements = append(elements, self.uint32(0))
+		self.uint32(0)
+	}
+
+	// Channels
+	self.patch(elements[0], self.offset())
+	self.bytes([]byte("CHAN")...)
+	self.uint32(0)
+	self.uint32(1)
+	self.uint32(16)
+	channel_name := self.uint32(0)
+	self.uint32(0)
+	self.uint32(NO_MESSAGE_ID)
+	self.patch(channel_name, self.string("Security"))
+
+	// Levels
+	self.patch(elements[1], self.offset())
+	self.bytes([]byte("LEVL")...)
+	self.uint32(0)
+	self.uint32(1)
+	self.uint32(4)
+	self.uint32(0x50000004)
+	level_name := self.uint32(0)
+	self.patch(level_name, self.string("win:Informational"))
+
+	// Events: version 1 has two fields and version 2 three.
+	self.patch(elements[2], self.offset())
+	self.bytes([]byte("EVNT")...)
+	self.uint32(0)
+	self.uint32(2)
+	self.uint32(0)
+	templates := []uint32{}
+	for version := 1; version <= 2; version++ {
+		self.uint16(4624)
+		self.bytes(byte(version), 16, 0, 0)
+		self.uint16(12544)
+		self.uint64(0x8020000000000000)
+		self.uint32(0xB0001210 + uint32(version))
+		templates = append(templates, self.uint32(0))
+		for i := 0; i < 6; i++ {
+			self.uint32(0)
+		}
+	}
+
+	for idx, fields := range [][]string{
+		{"SubjectUserSid", "LogonType"},
+		{"SubjectUserSid", "LogonType", "ElevatedToken"},
+	} {
+		template := self.offset()
+		self.patch(templates[idx], template)
+		self.bytes([]byte("TEMP")...)
+		self.uint32(0)
+		self.uint32(uint32(len(fields)))
+		self.uint32(uint32(len(fields)))
+		items := self.uint32(0)
+		self.uint32(1)
+		self.bytes(guid...)
+
+		// The BinXML fragment is skipped.
+		self.bytes(0x0f, 0x01, 0x01, 0x00, 0x00)
+
+		self.patch(items, self.offset())
+		names := []uint32{}
+		for range fields {
+			self.uint32(0)
+			self.bytes(0x13, 0x01)
+			self.uint16(0)
+			self.uint32(0)
+			self.uint16(1)
+			self.uint16(0)
+			names = append(names, self.uint32(0))
+		}
+		for i, field := range fields {
+			self.patch(names[i], self.string(field))
+		}
+	}
+
+	self.patch(4, self.offset())
+	return self.data
+}
+
+func TestParseWEVTTemplate(t *testing.T) {
+	manifest, err := ParseWEVTTemplate(buildTestManifest())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(manifest.Providers))
+
+	provider := manifest.Providers[0]
+	assert.Equal(t, "54849625-5478-4994-A5BA-3E3B0328C30D", provider.Guid)
+	assert.Equal(t, int64(0x90000001), provider.MessageId)
+	assert.Equal(t, "Security", provider.ChannelName(16))
+	assert.Equal(t, "win:Informational", provider.Levels[0].Name)
+	assert.Equal(t, uint64(4), provider.Levels[0].Value)
+
+	assert.Equal(t, 2, len(provider.Events))
+	event := provider.Events[1]
+	assert.Equal(t, 4624, event.Id)
+	assert.Equal(t, 2, event.Version)
+	assert.Equal(t, 12544, event.Task)
+	assert.Equal(t, uint64(0x8020000000000000), event.Keywords)
+	assert.Equal(t, int64(0xB0001212), event.MessageId)
+	assert.Equal(t, []string{"SubjectUserSid", "LogonType", "ElevatedToken"},
+		event.FieldNames())
+
+	// Truncated resources are errors.
+	_, err = ParseWEVTTemplate(buildTestManifest()[:200])
+	assert.Error(t, err)
+}